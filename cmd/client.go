@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/signal"
 	"syscall"
@@ -14,9 +15,14 @@ import (
 	"github.com/mata-elang-stable/sensor-snort-service/internal/prometheus_exporter"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/mata-elang-stable/sensor-snort-service/internal/auth"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/listener"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/processor"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/spool"
 	"github.com/spf13/cobra"
 )
 
@@ -35,9 +41,24 @@ func init() {
 
 	clientConfig := conf.Client()
 	viper.SetDefault("file", "")
+	viper.SetDefault("no_follow", false)
 	viper.SetDefault("socket", "")
-	viper.SetDefault("server", "localhost")
+	viper.SetDefault("socket_type", listener.SocketTypeStream)
+	viper.SetDefault("socket_max_record_size", listener.DefaultMaxRecordSize)
+	viper.SetDefault("syslog_listen", "")
+	viper.SetDefault("syslog_protocol", listener.SyslogProtocolUDP)
+	viper.SetDefault("syslog_tls_cert", "")
+	viper.SetDefault("syslog_tls_key", "")
+	viper.SetDefault("unified2_dir", "")
+	viper.SetDefault("unified2_prefix", "merged.log")
+	viper.SetDefault("sid_msg_map", "")
+	viper.SetDefault("classification_config", "")
+	viper.SetDefault("format", parser.FormatSnortJSON)
+	viper.SetDefault("server", []string{"localhost"})
 	viper.SetDefault("port", 50051)
+	viper.SetDefault("balance_policy", string(grpc.BalanceRoundRobin))
+	viper.SetDefault("eject_failures", grpc.DefaultEjectionPolicy().Failures)
+	viper.SetDefault("eject_duration", grpc.DefaultEjectionPolicy().Duration)
 	viper.SetDefault("interval", 1*time.Second)
 	viper.SetDefault("sensor_id", "sensor1")
 	viper.SetDefault("testing_mode", false)
@@ -46,6 +67,31 @@ func init() {
 	viper.SetDefault("secure", false)
 	viper.SetDefault("certificate", "")
 	viper.SetDefault("server_name", "")
+	viper.SetDefault("client_certificate", "")
+	viper.SetDefault("client_key", "")
+	viper.SetDefault("auth_token_file", "")
+	viper.SetDefault("auth_token", "")
+	viper.SetDefault("auth_token_mode", string(auth.TokenHMAC))
+	viper.SetDefault("bookmark_file", "")
+	viper.SetDefault("truncate_on_exit", false)
+	viper.SetDefault("spool_dir", "")
+	viper.SetDefault("spool_retry_interval", 5*time.Second)
+	viper.SetDefault("send_retry_initial_backoff", grpc.DefaultRetryPolicy().InitialBackoff)
+	viper.SetDefault("send_retry_max_backoff", grpc.DefaultRetryPolicy().MaxBackoff)
+	viper.SetDefault("send_retry_max_attempts", grpc.DefaultRetryPolicy().MaxAttempts)
+	viper.SetDefault("shutdown_drain_timeout", 20*time.Second)
+	viper.SetDefault("batch_idle_window", 1*time.Second)
+	viper.SetDefault("batch_max_age", 30*time.Second)
+	viper.SetDefault("batch_max_metrics", 1000)
+	viper.SetDefault("batch_max_size", 0)
+	viper.SetDefault("queue_max_bytes", 128*1024*1024)
+	viper.SetDefault("queue_max_events", 0)
+	viper.SetDefault("queue_overflow_policy", string(queue.OverflowBlock))
+	viper.SetDefault("queue_spill_dir", "")
+	viper.SetDefault("hash_fields", processor.DefaultHashFields)
+	viper.SetDefault("hash_exclude_fields", []string{})
+	viper.SetDefault("metrics_listen", ":9101")
+	viper.SetDefault("alert_metrics_top_k", 50)
 
 	if err := viper.Unmarshal(&clientConfig); err != nil {
 		log.WithField("error", err).Fatalln("Failed to unmarshal configuration.")
@@ -56,20 +102,73 @@ func init() {
 	flags := clientCmd.PersistentFlags()
 
 	flags.StringVarP(&clientConfig.AlertFilePath, "file", "f", clientConfig.AlertFilePath,
-		"Specifies the path to the Snort alert file.")
+		"Specifies the path to the Snort alert file, a glob pattern or a directory of alert files.")
+	flags.BoolVar(&clientConfig.NoFollow, "no-follow", clientConfig.NoFollow,
+		"Specifies whether the alert files are read to the end once, then the client exits. Compressed .gz and .zst files are always read this way.")
+	flags.StringVar(&clientConfig.SyslogListen, "syslog-listen", clientConfig.SyslogListen,
+		"Specifies the address to receive Snort alerts forwarded over syslog on, e.g. :514.")
+	flags.StringVar(&clientConfig.SyslogProtocol, "syslog-protocol", clientConfig.SyslogProtocol,
+		"Specifies the transport of the syslog listener. Valid values: udp, tcp, tls.")
+	flags.StringVar(&clientConfig.SyslogTLSCert, "syslog-tls-cert", clientConfig.SyslogTLSCert,
+		"Specifies the path to the TLS certificate of the syslog listener.")
+	flags.StringVar(&clientConfig.SyslogTLSKey, "syslog-tls-key", clientConfig.SyslogTLSKey,
+		"Specifies the path to the TLS private key of the syslog listener.")
+	flags.StringVar(&clientConfig.Unified2Dir, "unified2-dir", clientConfig.Unified2Dir,
+		"Specifies the directory containing the Snort unified2 spool files.")
+	flags.StringVar(&clientConfig.Unified2Prefix, "unified2-prefix", clientConfig.Unified2Prefix,
+		"Specifies the file name prefix of the unified2 spool files.")
+	flags.StringVar(&clientConfig.SidMsgMap, "sid-msg-map", clientConfig.SidMsgMap,
+		"Specifies the path to the sid-msg.map file used to resolve unified2 rule messages.")
+	flags.StringVar(&clientConfig.ClassificationConfig, "classification-config", clientConfig.ClassificationConfig,
+		"Specifies the path to the classification.config file used to resolve unified2 classifications.")
+	flags.StringVar(&clientConfig.AlertFormat, "format", clientConfig.AlertFormat,
+		"Specifies the format of the alert records. Valid values: snort, suricata, fast, full.")
+	flags.StringVar(&clientConfig.BookmarkFile, "bookmark-file", clientConfig.BookmarkFile,
+		"Specifies the file used to persist the read position of the alert file or unified2 spool. Empty disables the bookmark.")
+	flags.BoolVar(&clientConfig.TruncateOnExit, "truncate-on-exit", clientConfig.TruncateOnExit,
+		"Specifies whether the alert file is truncated when the client stops.")
 	flags.StringVar(&clientConfig.AlertSocketPath, "socket", clientConfig.AlertSocketPath,
 		"Specifies the path to the Snort alert unix socket. Should be /var/run/snort/snort_alert.")
-	flags.StringVarP(&clientConfig.GRPCServer, "server", "s", clientConfig.GRPCServer, "Specifies the gRPC server.")
-	flags.IntVarP(&clientConfig.GRPCPort, "port", "p", clientConfig.GRPCPort, "Specifies the gRPC port.")
+	flags.StringVar(&clientConfig.SocketType, "socket-type", clientConfig.SocketType,
+		"Specifies the type of the Snort alert unix socket. Valid values: stream, datagram.")
+	flags.IntVar(&clientConfig.SocketMaxRecordSize, "socket-max-record-size", clientConfig.SocketMaxRecordSize,
+		"Specifies the maximum size in bytes of a single alert record read from the unix socket.")
+	flags.StringSliceVarP(&clientConfig.GRPCServer, "server", "s", clientConfig.GRPCServer, "Specifies the gRPC servers as host or host:port, separated by commas.")
+	flags.IntVarP(&clientConfig.GRPCPort, "port", "p", clientConfig.GRPCPort, "Specifies the gRPC port of the servers given without one.")
+	flags.StringVar(&clientConfig.GRPCBalancePolicy, "balance-policy", clientConfig.GRPCBalancePolicy, "Specifies how events are spread over the servers: round-robin or priority.")
+	flags.IntVar(&clientConfig.GRPCEjectFailures, "eject-failures", clientConfig.GRPCEjectFailures, "Specifies the number of consecutive failures after which a server is ejected. 0 never ejects.")
+	flags.DurationVar(&clientConfig.GRPCEjectDuration, "eject-duration", clientConfig.GRPCEjectDuration, "Specifies how long an ejected server is skipped.")
 	flags.BoolVar(&clientConfig.GRPCSecure, "secure", clientConfig.GRPCSecure, "Specifies whether the connection is secure or not.")
 	flags.StringVar(&clientConfig.GRPCCertFile, "certificate", clientConfig.GRPCCertFile, "Path to TLS certificate file.")
 	flags.StringVar(&clientConfig.GRPCServerName, "server-name", clientConfig.GRPCServerName, "Server name for TLS verification.")
+	flags.StringVar(&clientConfig.GRPCClientCertFile, "client-certificate", clientConfig.GRPCClientCertFile, "Path to the TLS certificate the sensor authenticates with.")
+	flags.StringVar(&clientConfig.GRPCClientKeyFile, "client-key", clientConfig.GRPCClientKeyFile, "Path to the TLS key of the client certificate.")
+	flags.StringVar(&clientConfig.AuthTokenFile, "auth-token-file", clientConfig.AuthTokenFile, "Path to the file holding the secret the sensor authenticates with. MES_CLIENT_AUTH_TOKEN is used when not set.")
+	flags.StringVar(&clientConfig.AuthTokenMode, "auth-token-mode", clientConfig.AuthTokenMode, "Specifies how the secret is sent: hmac or bearer. hmac signs every call once without sending the secret, bearer sends it and needs TLS.")
 	flags.CountVarP(&conf.VerboseCount, "verbose", "v", "Increase verbosity of the output.")
 	flags.StringVar(&clientConfig.SensorID, "sensor-id", clientConfig.SensorID, "Specifies the sensor ID.")
 	flags.DurationVarP(&clientConfig.GRPCInterval, "interval", "i", clientConfig.GRPCInterval, "Specifies the interval to send the data to the server.")
 	flags.BoolVarP(&clientConfig.TestingMode, "testing-mode", "t", clientConfig.TestingMode, "Specifies whether the application is running in testing mode. Testing mode will activate insecure connection and skip the gRPC server name verification.")
 	flags.IntVarP(&clientConfig.MaxClients, "max-clients", "k", clientConfig.MaxClients, "Specifies the maximum number of clients.")
 	flags.IntVarP(&conf.GRPCMaxMsgSize, "max-message-size", "m", conf.GRPCMaxMsgSize, "Specifies the maximum message size.")
+	flags.StringVar(&clientConfig.SpoolDir, "spool-dir", clientConfig.SpoolDir, "Specifies the directory to spool batches on disk until the server accepts them. Empty disables the spool.")
+	flags.DurationVar(&clientConfig.SpoolRetryInterval, "spool-retry-interval", clientConfig.SpoolRetryInterval, "Specifies the interval between delivery attempts of spooled batches.")
+	flags.DurationVar(&clientConfig.SendRetryInitialBackoff, "send-retry-initial-backoff", clientConfig.SendRetryInitialBackoff, "Specifies the wait after the first failure to send an event, it doubles after every failure.")
+	flags.DurationVar(&clientConfig.SendRetryMaxBackoff, "send-retry-max-backoff", clientConfig.SendRetryMaxBackoff, "Specifies the longest wait between two attempts to send an event.")
+	flags.IntVar(&clientConfig.SendRetryMaxAttempts, "send-retry-max-attempts", clientConfig.SendRetryMaxAttempts, "Specifies the number of failures allowed per batch before it is queued again. 0 retries until the client stops.")
+	flags.DurationVar(&clientConfig.ShutdownDrainTimeout, "shutdown-drain-timeout", clientConfig.ShutdownDrainTimeout, "Specifies how long queued events are still sent after the client is asked to stop. 0 stops right away.")
+	flags.DurationVar(&clientConfig.BatchIdleWindow, "batch-idle-window", clientConfig.BatchIdleWindow, "Specifies how long an event must go without new alerts before it is sent.")
+	flags.DurationVar(&clientConfig.BatchMaxAge, "batch-max-age", clientConfig.BatchMaxAge, "Specifies how long an event may aggregate alerts before it is sent. 0 disables the limit.")
+	flags.IntVar(&clientConfig.BatchMaxMetrics, "batch-max-metrics", clientConfig.BatchMaxMetrics, "Specifies the maximum number of alerts aggregated into a single event. 0 disables the limit.")
+	flags.IntVar(&clientConfig.BatchMaxSize, "batch-max-size", clientConfig.BatchMaxSize, "Specifies the maximum size of a single event in bytes. 0 uses the maximum message size.")
+	flags.IntVar(&clientConfig.QueueMaxBytes, "queue-max-bytes", clientConfig.QueueMaxBytes, "Specifies the maximum size in bytes of all events held by the client. 0 disables the limit.")
+	flags.IntVar(&clientConfig.QueueMaxEvents, "queue-max-events", clientConfig.QueueMaxEvents, "Specifies the maximum number of alerts held by the client. 0 disables the limit.")
+	flags.StringVar(&clientConfig.QueueOverflowPolicy, "queue-overflow-policy", clientConfig.QueueOverflowPolicy, "Specifies what happens to new alerts when the queue is full. Valid values: block, drop-oldest, drop-lowest-priority, spill.")
+	flags.StringVar(&clientConfig.QueueSpillDir, "queue-spill-dir", clientConfig.QueueSpillDir, "Specifies the directory events are spilled to with the spill policy.")
+	flags.StringSliceVar(&clientConfig.HashFields, "hash-fields", clientConfig.HashFields, "Specifies the event fields hashed to group alerts into one event, in order.")
+	flags.StringSliceVar(&clientConfig.HashExcludeFields, "hash-exclude-fields", clientConfig.HashExcludeFields, "Specifies event fields left out of the hash, e.g. snort_seconds to group alerts regardless of their time.")
+	flags.StringVar(&clientConfig.MetricsListen, "metrics-listen", clientConfig.MetricsListen, "Specifies the address the Prometheus metrics, /healthz and /readyz are served on, as host:port or unix:/path/to/socket. Empty or disabled disables them.")
+	flags.IntVar(&clientConfig.AlertMetricsTopK, "alert-metrics-top-k", clientConfig.AlertMetricsTopK, "Specifies the number of rules, priorities, classifications and actions with their own alert counter, the others are counted as other. 0 disables the alert counters.")
 
 	if err := viper.BindPFlags(flags); err != nil {
 		log.WithField("error", err).Fatalln("Failed to bind flags.")
@@ -85,59 +184,171 @@ func runClient(cmd *cobra.Command, args []string) {
 
 	log.Infof("Starting server with configuration:")
 	log.Infof("AlertFilePath: %s", conf.AlertFilePath)
+	log.Infof("NoFollow: %t", conf.NoFollow)
+	log.Infof("SyslogListen: %s", conf.SyslogListen)
+	log.Infof("SyslogProtocol: %s", conf.SyslogProtocol)
+	log.Infof("Unified2Dir: %s", conf.Unified2Dir)
+	log.Infof("Unified2Prefix: %s", conf.Unified2Prefix)
+	log.Infof("AlertFormat: %s", conf.AlertFormat)
+	log.Infof("BookmarkFile: %s", conf.BookmarkFile)
+	log.Infof("TruncateOnExit: %t", conf.TruncateOnExit)
 	log.Infof("AlertSocketPath: %s", conf.AlertSocketPath)
-	log.Infof("GRPCServer: %s", conf.GRPCServer)
+	log.Infof("SocketType: %s", conf.SocketType)
+	log.Infof("SocketMaxRecordSize: %d", conf.SocketMaxRecordSize)
+	log.Infof("GRPCServer: %v", conf.GRPCServer)
 	log.Infof("GRPCPort: %d", conf.GRPCPort)
+	log.Infof("GRPCBalancePolicy: %s", conf.GRPCBalancePolicy)
+	log.Infof("GRPCEjectFailures: %d", conf.GRPCEjectFailures)
+	log.Infof("GRPCEjectDuration: %s", conf.GRPCEjectDuration)
 	log.Infof("GRPCSecure: %t", conf.GRPCSecure)
+	log.Infof("GRPCClientCertFile: %s", conf.GRPCClientCertFile)
+	log.Infof("AuthTokenFile: %s", conf.AuthTokenFile)
+	log.Infof("AuthTokenMode: %s", conf.AuthTokenMode)
 	log.Infof("GRPCInterval: %s", conf.GRPCInterval)
 	log.Infof("SensorID: %s", conf.SensorID)
 	log.Infof("TestingMode: %t", conf.TestingMode)
 	log.Infof("MaxClients: %d", conf.MaxClients)
 	log.Infof("GRPCMaxMsgSize: %d", confInstance.GRPCMaxMsgSize)
+	log.Infof("SpoolDir: %s", conf.SpoolDir)
+	log.Infof("SpoolRetryInterval: %s", conf.SpoolRetryInterval)
+	log.Infof("SendRetryInitialBackoff: %s", conf.SendRetryInitialBackoff)
+	log.Infof("SendRetryMaxBackoff: %s", conf.SendRetryMaxBackoff)
+	log.Infof("SendRetryMaxAttempts: %d", conf.SendRetryMaxAttempts)
+	log.Infof("ShutdownDrainTimeout: %s", conf.ShutdownDrainTimeout)
+	log.Infof("BatchIdleWindow: %s", conf.BatchIdleWindow)
+	log.Infof("BatchMaxAge: %s", conf.BatchMaxAge)
+	log.Infof("BatchMaxMetrics: %d", conf.BatchMaxMetrics)
+	log.Infof("BatchMaxSize: %d", conf.BatchMaxSize)
+	log.Infof("QueueMaxBytes: %d", conf.QueueMaxBytes)
+	log.Infof("QueueMaxEvents: %d", conf.QueueMaxEvents)
+	log.Infof("QueueOverflowPolicy: %s", conf.QueueOverflowPolicy)
+	log.Infof("QueueSpillDir: %s", conf.QueueSpillDir)
+	log.Infof("HashFields: %v", conf.HashFields)
+	log.Infof("HashExcludeFields: %v", conf.HashExcludeFields)
+	log.Infof("MetricsListen: %s", conf.MetricsListen)
+	log.Infof("AlertMetricsTopK: %d", conf.AlertMetricsTopK)
 	log.Infof("")
 
 	// Create a context with cancel function on interrupt signal
 	mainContext, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	alertParser, err := parser.New(conf.AlertFormat)
+	if err != nil {
+		log.WithField("error", err).Fatalln("failed to create alert parser")
+	}
+
+	if err := processor.SetHashFields(conf.HashFields, conf.HashExcludeFields); err != nil {
+		log.WithField("error", err).Fatalln("invalid event hash fields")
+	}
+
 	// Determine the alert source: socket or file (mutually exclusive)
 	var lis listener.Listener
-	var err error
+
+	sources := 0
+	for _, source := range []string{conf.AlertFilePath, conf.AlertSocketPath, conf.Unified2Dir, conf.SyslogListen} {
+		if source != "" {
+			sources++
+		}
+	}
 
 	switch {
-	case conf.AlertSocketPath != "" && conf.AlertFilePath != "":
-		log.Fatalln("cannot specify both --file and --socket (or MES_CLIENT_FILE / MES_CLIENT_SOCKET env vars); choose one")
+	case sources > 1:
+		log.Fatalln("cannot specify more than one of --file, --socket, --unified2-dir and --syslog-listen (or MES_CLIENT_FILE / MES_CLIENT_SOCKET / MES_CLIENT_UNIFIED2_DIR / MES_CLIENT_SYSLOG_LISTEN env vars); choose one")
 	case conf.AlertSocketPath != "":
-		lis, err = listener.NewUnixListener(conf.AlertSocketPath)
+		switch conf.SocketType {
+		case listener.SocketTypeStream, "":
+			lis, err = listener.NewUnixListener(conf.AlertSocketPath, conf.SocketMaxRecordSize, alertParser)
+		case listener.SocketTypeDatagram:
+			lis, err = listener.NewUnixDatagramListener(conf.AlertSocketPath, conf.SocketMaxRecordSize, alertParser)
+		default:
+			log.WithField("socket_type", conf.SocketType).Fatalln("unsupported socket type, must be stream or datagram")
+		}
 		if err != nil {
 			log.WithField("error", err).Fatalln("failed to create socket listener")
 		}
-		log.Infoln("Using unix socket listener")
+		log.Infof("Using unix %s socket listener", conf.SocketType)
 	case conf.AlertFilePath != "":
-		lis, err = listener.NewFileListener(conf.AlertFilePath)
+		switch {
+		case conf.NoFollow || listener.IsCompressedFile(conf.AlertFilePath):
+			lis, err = listener.NewBatchFileListener(conf.AlertFilePath, alertParser)
+		case listener.IsFilePattern(conf.AlertFilePath):
+			lis, err = listener.NewGlobFileListener(conf.AlertFilePath, alertParser)
+		default:
+			lis, err = listener.NewFileListener(conf.AlertFilePath, alertParser)
+		}
 		if err != nil {
 			log.WithField("error", err).Fatalln("failed to create file listener")
 		}
 		log.Infoln("Using file listener")
+	case conf.Unified2Dir != "":
+		lis, err = listener.NewUnified2Listener(conf.Unified2Dir, conf.Unified2Prefix)
+		if err != nil {
+			log.WithField("error", err).Fatalln("failed to create unified2 listener")
+		}
+		log.Infoln("Using unified2 listener")
+	case conf.SyslogListen != "":
+		lis, err = listener.NewSyslogListener(conf.SyslogProtocol, conf.SyslogListen, conf.SyslogTLSCert, conf.SyslogTLSKey, alertParser)
+		if err != nil {
+			log.WithField("error", err).Fatalln("failed to create syslog listener")
+		}
+		log.Infof("Using syslog %s listener", conf.SyslogProtocol)
 	default:
-		log.Fatalln("must specify either --file or --socket (or MES_CLIENT_FILE / MES_CLIENT_SOCKET env vars)")
+		log.Fatalln("must specify one of --file, --socket, --unified2-dir or --syslog-listen (or MES_CLIENT_FILE / MES_CLIENT_SOCKET / MES_CLIENT_UNIFIED2_DIR / MES_CLIENT_SYSLOG_LISTEN env vars)")
 	}
 
 	// Create an event queue to store sensor events
-	eventQueue := queue.NewEventBatchQueue()
+	limits, err := eventQueueLimits(conf, confInstance.GRPCMaxMsgSize)
+	if err != nil {
+		log.WithField("error", err).Fatalln("invalid queue configuration")
+	}
 
-	streamManager, err := grpc.NewStreamManager(conf.GRPCServer, conf.GRPCPort, grpc.CertOpts{
-		Insecure:   !conf.GRPCSecure,
-		CertFile:   conf.GRPCCertFile,
-		ServerName: conf.GRPCServerName,
-	}, confInstance.GRPCMaxMsgSize, 10*time.Second)
+	metricsAddr, err := prometheus_exporter.ParseListenAddress(conf.MetricsListen)
 	if err != nil {
-		log.Errorf("Failed to create stream manager: %v", err)
+		log.WithField("error", err).Fatalln("invalid metrics address")
+	}
+	eventQueue := queue.NewEventBatchQueueWithLimits(limits)
+
+	streamManager, err := newStreamManager(conf, confInstance.GRPCMaxMsgSize)
+	if err != nil {
+		log.WithField("error", err).Fatalln("failed to create stream manager")
+	}
+
+	// When the spool is enabled, batches are written to disk first and
+	// delivered to the server by the spool sender.
+	var batchSender queue.BatchSender = streamManager
+	var batchSpool *spool.Spool
+	if conf.SpoolDir != "" {
+		batchSpool, err = spool.NewSpool(conf.SpoolDir, conf.SpoolRetryInterval)
+		if err != nil {
+			log.WithField("error", err).Fatalln("failed to open spool directory")
+		}
+		batchSender = batchSpool
+		log.Infoln("Using on-disk spool")
+	}
+
+	// With the spill policy, events that do not fit in memory are written to their own
+	// spool and delivered from there.
+	var spillSpool *spool.Spool
+	if limits.Overflow == queue.OverflowSpill {
+		if conf.QueueSpillDir == "" {
+			log.Fatalln("the spill policy requires --queue-spill-dir (or MES_CLIENT_QUEUE_SPILL_DIR env var)")
+		}
+		spillSpool, err = spool.NewSpool(conf.QueueSpillDir, conf.SpoolRetryInterval)
+		if err != nil {
+			log.WithField("error", err).Fatalln("failed to open spill directory")
+		}
+		eventQueue.SetSpiller(spillSpool)
 	}
 
 	// Prometheus exporter is used to expose metrics to Prometheus
 	// The metrics are used to monitor the application
 	prom := prometheus_exporter.NewMetrics()
+	streamManager.SetSendObserver(prom.ObserveSent)
+	if conf.AlertMetricsTopK > 0 {
+		eventQueue.EnableAlertCounts()
+		prom.EnableAlertMetrics(conf.AlertMetricsTopK)
+	}
 
 	// Create a wait group to wait for all goroutines to finish
 	g, gCtx := errgroup.WithContext(mainContext)
@@ -146,11 +357,31 @@ func runClient(cmd *cobra.Command, args []string) {
 	g.Go(func() error {
 		defer cancel()
 		log.Infof("Starting Watcher...")
-		err := eventQueue.StartWatcher(gCtx, streamManager)
+		err := eventQueue.StartWatcher(gCtx, batchSender)
 		defer log.WithField("package", "main").Infof("Watcher Job is stopped. (%v)\n", err)
 		return err
 	})
 
+	// Start the spool sender
+	if batchSpool != nil {
+		g.Go(func() error {
+			log.Infof("Starting Spool Sender...")
+			err := batchSpool.Start(gCtx, streamManager)
+			defer log.WithField("package", "main").Infof("Spool Sender Job is stopped. (%v)\n", err)
+			return err
+		})
+	}
+
+	// Start the spill sender
+	if spillSpool != nil {
+		g.Go(func() error {
+			log.Infof("Starting Spill Sender...")
+			err := spillSpool.Start(gCtx, streamManager)
+			defer log.WithField("package", "main").Infof("Spill Sender Job is stopped. (%v)\n", err)
+			return err
+		})
+	}
+
 	// Start the listener
 	g.Go(func() error {
 		defer cancel()
@@ -160,13 +391,25 @@ func runClient(cmd *cobra.Command, args []string) {
 		return err
 	})
 
-	// Start the prometheus exporter server
-	g.Go(func() error {
-		log.Infof("Starting Prometheus Exporter Server...")
-		err := prom.StartServer(gCtx)
-		log.WithField("package", "main").Infof("Prometheus Exporter Job is stopped. (%v)\n", err)
-		return err
-	})
+	// Start the prometheus exporter server, it also answers the health and readiness checks
+	if metricsAddr != nil {
+		g.Go(func() error {
+			log.Infof("Starting Prometheus Exporter Server on %s...", metricsAddr)
+			err := prom.StartServer(gCtx, metricsAddr, func() error {
+				if !lis.Ready() {
+					return errors.New("the alert input is not open")
+				}
+				if !streamManager.Healthy() {
+					return errors.New("no server is connected")
+				}
+				return nil
+			})
+			log.WithField("package", "main").Infof("Prometheus Exporter Job is stopped. (%v)\n", err)
+			return err
+		})
+	} else {
+		log.Infoln("Prometheus Exporter Server is disabled")
+	}
 
 	// Handle the main context cancellation
 	g.Go(func() error {
@@ -174,7 +417,7 @@ func runClient(cmd *cobra.Command, args []string) {
 		<-mainContext.Done()
 		log.Infof("Shutting down the client...")
 
-		streamManager.Close()
+		// The stream stays open until the queue is drained.
 		return lis.Stop()
 	})
 
@@ -197,13 +440,154 @@ func runClient(cmd *cobra.Command, args []string) {
 				return nil
 			case <-ticker.C:
 				prom.RecordMetrics(lis, eventQueue)
+				prom.RecordAlertMetrics(eventQueue)
+				if batchSpool != nil {
+					prom.RecordSpoolMetrics(prometheus_exporter.SpoolBatch, batchSpool)
+				}
+				if spillSpool != nil {
+					prom.RecordSpoolMetrics(prometheus_exporter.SpoolSpill, spillSpool)
+				}
 			}
 		}
 	})
 
 	// Wait for all goroutines to finish
-	if err := g.Wait(); err != nil {
+	err = g.Wait()
+
+	// Send what is left once the listener and the watcher have stopped.
+	drainClient(conf.ShutdownDrainTimeout, eventQueue, batchSender, streamManager)
+
+	if err != nil {
 		log.WithField("error", err).Fatalln("failed to start the application")
 		return
 	}
 }
+
+// drainClient sends the events left in the queue and waits for the server to acknowledge them
+// before the stream is closed. Events that are not delivered before the timeout are logged.
+func drainClient(timeout time.Duration, q *queue.EventBatchQueue, sender queue.BatchSender, sm *grpc.StreamManager) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.WithFields(logger.Fields{
+		"package": "main",
+		"queued":  q.GetEventQueueSize(),
+		"timeout": timeout.String(),
+	}).Infoln("Draining queued events")
+
+	started := time.Now()
+	left := q.Drain(ctx, sender)
+
+	unacked := 0
+	if sm != nil {
+		unacked = sm.Flush(ctx)
+		sm.Close()
+	}
+
+	fields := logger.Fields{
+		"package":  "main",
+		"left":     left,
+		"unacked":  unacked,
+		"duration": time.Since(started).Round(time.Millisecond).String(),
+	}
+	if left > 0 || unacked > 0 {
+		log.WithFields(fields).Warnln("Shutdown drain timed out, some events were not delivered")
+		return
+	}
+	log.WithFields(fields).Infoln("Drained all queued events")
+}
+
+// newStreamManager creates a stream manager sending to the configured servers
+// with the configured balance and retry policies.
+func newStreamManager(conf *config.ClientConfig, maxMessageSize int) (*grpc.StreamManager, error) {
+	servers, err := grpc.ParseEndpoints(conf.GRPCServer, conf.GRPCPort)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := grpc.ParseBalancePolicy(conf.GRPCBalancePolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	certOpts, err := clientCertOpts(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	streamManager, err := grpc.NewStreamManager(servers, certOpts, maxMessageSize, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	streamManager.SetRetryPolicy(sendRetryPolicy(conf))
+	streamManager.SetBalancePolicy(policy, grpc.EjectionPolicy{
+		Failures: conf.GRPCEjectFailures,
+		Duration: conf.GRPCEjectDuration,
+	})
+
+	return streamManager, nil
+}
+
+// clientCertOpts returns the TLS and token credentials the client connects to the servers with.
+func clientCertOpts(conf *config.ClientConfig) (grpc.CertOpts, error) {
+	certOpts := grpc.CertOpts{
+		Insecure:       !conf.GRPCSecure,
+		CertFile:       conf.GRPCCertFile,
+		ServerName:     conf.GRPCServerName,
+		ClientCertFile: conf.GRPCClientCertFile,
+		ClientKeyFile:  conf.GRPCClientKeyFile,
+	}
+
+	if conf.AuthTokenFile != "" || conf.AuthToken != "" {
+		mode, err := auth.ParseTokenMode(conf.AuthTokenMode)
+		if err != nil {
+			return certOpts, err
+		}
+		if mode == auth.TokenBearer && !conf.GRPCSecure {
+			log.Warnln("Sending the bearer token without TLS, use --secure or --auth-token-mode hmac")
+		}
+
+		certOpts.PerRPCCredentials, err = auth.NewTokenCredentials(conf.SensorID, mode, conf.AuthTokenFile, conf.AuthToken)
+		if err != nil {
+			return certOpts, err
+		}
+	}
+
+	return certOpts, nil
+}
+
+// sendRetryPolicy returns how the stream manager retries events that could not be sent.
+func sendRetryPolicy(conf *config.ClientConfig) grpc.RetryPolicy {
+	policy := grpc.DefaultRetryPolicy()
+	policy.InitialBackoff = conf.SendRetryInitialBackoff
+	policy.MaxBackoff = conf.SendRetryMaxBackoff
+	policy.MaxAttempts = conf.SendRetryMaxAttempts
+
+	return policy
+}
+
+// eventQueueLimits returns the limits of the event queue. Events are never allowed
+// to grow beyond the maximum gRPC message size, given in megabytes.
+func eventQueueLimits(conf *config.ClientConfig, maxMessageSize int) (queue.Limits, error) {
+	overflow, err := queue.ParseOverflowPolicy(conf.QueueOverflowPolicy)
+	if err != nil {
+		return queue.Limits{}, err
+	}
+
+	maxBytes := maxMessageSize * 1024 * 1024
+	if conf.BatchMaxSize > 0 && conf.BatchMaxSize < maxBytes {
+		maxBytes = conf.BatchMaxSize
+	}
+
+	return queue.Limits{
+		IdleWindow: conf.BatchIdleWindow,
+		MaxAge:     conf.BatchMaxAge,
+		MaxMetrics: conf.BatchMaxMetrics,
+		MaxBytes:   maxBytes,
+
+		MaxQueueBytes:  conf.QueueMaxBytes,
+		MaxQueueEvents: conf.QueueMaxEvents,
+		Overflow:       overflow,
+	}, nil
+}
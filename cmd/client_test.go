@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
+)
+
+func Test_NewStreamManager(t *testing.T) {
+	base := config.ClientConfig{
+		GRPCServer:        []string{"localhost"},
+		GRPCPort:          50051,
+		GRPCBalancePolicy: "round-robin",
+		GRPCEjectFailures: 3,
+		GRPCEjectDuration: 30 * time.Second,
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *config.ClientConfig)
+		wantErr bool
+	}{
+		{name: "Must create a stream manager from a valid configuration", modify: func(*config.ClientConfig) {}},
+		{name: "Must reject an unknown balance policy", modify: func(c *config.ClientConfig) { c.GRPCBalancePolicy = "bogus" }, wantErr: true},
+		{name: "Must reject an invalid server", modify: func(c *config.ClientConfig) { c.GRPCServer = []string{"localhost:grpc"} }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := base
+			tt.modify(&conf)
+
+			sm, err := newStreamManager(&conf, 4)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newStreamManager() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				sm.Close()
+			} else if sm != nil {
+				t.Errorf("Expected no stream manager on error")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/output/grpc"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/prometheus_exporter"
+)
+
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Check that the client and the servers are healthy.",
+	Long: "Ask /readyz of the running client and the gRPC health service of the servers whether they are ready. " +
+		"Exits with a non-zero status when the client is not, or when no server is serving, for container healthchecks. " +
+		"A single serving server is enough, as the client fails over to it.",
+	Run: runHealthcheck,
+}
+
+func init() {
+	rootCmd.AddCommand(healthcheckCmd)
+
+	conf := config.GetConfig()
+
+	clientConfig := conf.Client()
+	viper.SetDefault("healthcheck_targets", []string{"client", "server"})
+	viper.SetDefault("healthcheck_timeout", 5*time.Second)
+
+	if err := viper.Unmarshal(&clientConfig); err != nil {
+		log.WithField("error", err).Fatalln("Failed to unmarshal configuration.")
+	}
+
+	flags := healthcheckCmd.PersistentFlags()
+
+	flags.StringSliceVar(&clientConfig.HealthcheckTargets, "check", clientConfig.HealthcheckTargets, "Specifies what is checked: client, server or both, separated by commas.")
+	flags.DurationVar(&clientConfig.HealthcheckTimeout, "timeout", clientConfig.HealthcheckTimeout, "Specifies how long every check may take.")
+	flags.StringVar(&clientConfig.MetricsListen, "metrics-listen", clientConfig.MetricsListen, "Specifies the address the client serves /readyz on, as host:port or unix:/path/to/socket.")
+	flags.StringSliceVarP(&clientConfig.GRPCServer, "server", "s", clientConfig.GRPCServer, "Specifies the gRPC servers as host or host:port, separated by commas.")
+	flags.IntVarP(&clientConfig.GRPCPort, "port", "p", clientConfig.GRPCPort, "Specifies the gRPC port of the servers given without one.")
+	flags.BoolVar(&clientConfig.GRPCSecure, "secure", clientConfig.GRPCSecure, "Specifies whether the connection is secure or not.")
+	flags.StringVar(&clientConfig.GRPCCertFile, "certificate", clientConfig.GRPCCertFile, "Path to TLS certificate file.")
+	flags.StringVar(&clientConfig.GRPCServerName, "server-name", clientConfig.GRPCServerName, "Server name for TLS verification.")
+	flags.StringVar(&clientConfig.GRPCClientCertFile, "client-certificate", clientConfig.GRPCClientCertFile, "Path to the TLS certificate the sensor authenticates with.")
+	flags.StringVar(&clientConfig.GRPCClientKeyFile, "client-key", clientConfig.GRPCClientKeyFile, "Path to the TLS key of the client certificate.")
+	flags.CountVarP(&conf.VerboseCount, "verbose", "v", "Increase verbosity of the output.")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		log.WithField("error", err).Fatalln("Failed to bind flags.")
+	}
+}
+
+func runHealthcheck(cmd *cobra.Command, args []string) {
+	confInstance := config.GetConfig()
+	confInstance.SetupLogging()
+
+	conf := confInstance.Client()
+
+	for _, target := range conf.HealthcheckTargets {
+		if target != "client" && target != "server" {
+			log.Fatalf("unknown healthcheck target %q, expected client or server", target)
+		}
+	}
+
+	healthy := true
+
+	if slices.Contains(conf.HealthcheckTargets, "client") {
+		if err := checkClient(conf); err != nil {
+			fmt.Printf("client: %v\n", err)
+			healthy = false
+		} else {
+			fmt.Println("client: ok")
+		}
+	}
+
+	if slices.Contains(conf.HealthcheckTargets, "server") {
+		if !checkServers(conf) {
+			healthy = false
+		}
+	}
+
+	if !healthy {
+		os.Exit(1)
+	}
+}
+
+// checkClient asks the running client whether it is ready.
+func checkClient(conf *config.ClientConfig) error {
+	addr, err := prometheus_exporter.ParseListenAddress(conf.MetricsListen)
+	if err != nil {
+		return err
+	}
+	if addr == nil {
+		return fmt.Errorf("the metrics address is disabled, the client cannot be checked")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.HealthcheckTimeout)
+	defer cancel()
+
+	return prometheus_exporter.CheckReady(ctx, addr)
+}
+
+// checkServers asks the health service of every server whether it is serving and prints the state of each.
+// It reports whether at least one server is serving.
+func checkServers(conf *config.ClientConfig) bool {
+	servers, err := grpc.ParseEndpoints(conf.GRPCServer, conf.GRPCPort)
+	if err != nil {
+		fmt.Printf("server: %v\n", err)
+		return false
+	}
+
+	certOpts, err := clientCertOpts(conf)
+	if err != nil {
+		fmt.Printf("server: %v\n", err)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.HealthcheckTimeout)
+	defer cancel()
+
+	results, err := grpc.CheckServers(ctx, servers, certOpts)
+	if err != nil {
+		fmt.Printf("server: %v\n", err)
+		return false
+	}
+
+	serving := false
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("server %s: %v\n", result.Server, result.Err)
+			continue
+		}
+		fmt.Printf("server %s: ok\n", result.Server)
+		serving = true
+	}
+
+	return serving
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/listener"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/output/grpc"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/processor"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Resend historical alert files to the server.",
+	Long: "Read alert files, plain or compressed, and send the alerts to the server with the spacing " +
+		"of their original timestamps, scaled by --speed, or as fast as possible with --fast.",
+	Run: runReplay,
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	conf := config.GetConfig()
+
+	clientConfig := conf.Client()
+	viper.SetDefault("replay_speed", 1.0)
+	viper.SetDefault("replay_fast", false)
+
+	if err := viper.Unmarshal(&clientConfig); err != nil {
+		log.WithField("error", err).Fatalln("Failed to unmarshal configuration.")
+	}
+
+	flags := replayCmd.PersistentFlags()
+
+	flags.StringVarP(&clientConfig.AlertFilePath, "file", "f", clientConfig.AlertFilePath,
+		"Specifies the path to the alert file to replay, a glob pattern or a directory of alert files.")
+	flags.StringVar(&clientConfig.AlertFormat, "format", clientConfig.AlertFormat,
+		"Specifies the format of the alert records. Valid values: snort, suricata, fast, full.")
+	flags.Float64Var(&clientConfig.ReplaySpeed, "speed", clientConfig.ReplaySpeed,
+		"Specifies how many times faster than recorded the alerts are sent, e.g. 2 halves the time between alerts.")
+	flags.BoolVar(&clientConfig.ReplayFast, "fast", clientConfig.ReplayFast,
+		"Specifies whether the alerts are sent as fast as possible, ignoring their timestamps.")
+	flags.DurationVar(&clientConfig.BatchIdleWindow, "batch-idle-window", clientConfig.BatchIdleWindow, "Specifies how long an event must go without new alerts before it is sent.")
+	flags.DurationVar(&clientConfig.BatchMaxAge, "batch-max-age", clientConfig.BatchMaxAge, "Specifies how long an event may aggregate alerts before it is sent. 0 disables the limit.")
+	flags.IntVar(&clientConfig.BatchMaxMetrics, "batch-max-metrics", clientConfig.BatchMaxMetrics, "Specifies the maximum number of alerts aggregated into a single event. 0 disables the limit.")
+	flags.IntVar(&clientConfig.BatchMaxSize, "batch-max-size", clientConfig.BatchMaxSize, "Specifies the maximum size of a single event in bytes. 0 uses the maximum message size.")
+	flags.DurationVar(&clientConfig.ShutdownDrainTimeout, "shutdown-drain-timeout", clientConfig.ShutdownDrainTimeout, "Specifies how long queued and unacknowledged events are still sent once all files are read or the replay is interrupted. 0 stops right away.")
+	flags.StringSliceVar(&clientConfig.HashFields, "hash-fields", clientConfig.HashFields, "Specifies the event fields hashed to group alerts into one event, in order.")
+	flags.StringSliceVar(&clientConfig.HashExcludeFields, "hash-exclude-fields", clientConfig.HashExcludeFields, "Specifies event fields left out of the hash, e.g. snort_seconds to group alerts regardless of their time.")
+	flags.StringSliceVarP(&clientConfig.GRPCServer, "server", "s", clientConfig.GRPCServer, "Specifies the gRPC servers as host or host:port, separated by commas.")
+	flags.IntVarP(&clientConfig.GRPCPort, "port", "p", clientConfig.GRPCPort, "Specifies the gRPC port of the servers given without one.")
+	flags.StringVar(&clientConfig.GRPCBalancePolicy, "balance-policy", clientConfig.GRPCBalancePolicy, "Specifies how events are spread over the servers: round-robin or priority.")
+	flags.IntVar(&clientConfig.GRPCEjectFailures, "eject-failures", clientConfig.GRPCEjectFailures, "Specifies the number of consecutive failures after which a server is ejected. 0 never ejects.")
+	flags.DurationVar(&clientConfig.GRPCEjectDuration, "eject-duration", clientConfig.GRPCEjectDuration, "Specifies how long an ejected server is skipped.")
+	flags.BoolVar(&clientConfig.GRPCSecure, "secure", clientConfig.GRPCSecure, "Specifies whether the connection is secure or not.")
+	flags.StringVar(&clientConfig.GRPCCertFile, "certificate", clientConfig.GRPCCertFile, "Path to TLS certificate file.")
+	flags.StringVar(&clientConfig.GRPCServerName, "server-name", clientConfig.GRPCServerName, "Server name for TLS verification.")
+	flags.StringVar(&clientConfig.GRPCClientCertFile, "client-certificate", clientConfig.GRPCClientCertFile, "Path to the TLS certificate the sensor authenticates with.")
+	flags.StringVar(&clientConfig.GRPCClientKeyFile, "client-key", clientConfig.GRPCClientKeyFile, "Path to the TLS key of the client certificate.")
+	flags.StringVar(&clientConfig.AuthTokenFile, "auth-token-file", clientConfig.AuthTokenFile, "Path to the file holding the secret the sensor authenticates with. MES_CLIENT_AUTH_TOKEN is used when not set.")
+	flags.StringVar(&clientConfig.AuthTokenMode, "auth-token-mode", clientConfig.AuthTokenMode, "Specifies how the secret is sent: hmac or bearer. hmac signs every call once without sending the secret, bearer sends it and needs TLS.")
+	flags.StringVar(&clientConfig.SensorID, "sensor-id", clientConfig.SensorID, "Specifies the sensor ID.")
+	flags.IntVarP(&conf.GRPCMaxMsgSize, "max-message-size", "m", conf.GRPCMaxMsgSize, "Specifies the maximum message size.")
+	flags.CountVarP(&conf.VerboseCount, "verbose", "v", "Increase verbosity of the output.")
+
+	if err := viper.BindPFlags(flags); err != nil {
+		log.WithField("error", err).Fatalln("Failed to bind flags.")
+	}
+}
+
+// replaySender counts the events handed to the server.
+type replaySender struct {
+	sender queue.BatchSender
+	sent   atomic.Int64
+}
+
+func (r *replaySender) SendBulkEvent(ctx context.Context, events []*pb.SensorEvent) (int64, error) {
+	total, err := r.sender.SendBulkEvent(ctx, events)
+	r.sent.Add(total)
+
+	return total, err
+}
+
+func runReplay(cmd *cobra.Command, args []string) {
+	confInstance := config.GetConfig()
+	confInstance.SetupLogging()
+
+	conf := confInstance.Client()
+
+	log.Infof("Starting replay with configuration:")
+	log.Infof("AlertFilePath: %s", conf.AlertFilePath)
+	log.Infof("AlertFormat: %s", conf.AlertFormat)
+	log.Infof("ReplaySpeed: %g", conf.ReplaySpeed)
+	log.Infof("ReplayFast: %t", conf.ReplayFast)
+	log.Infof("BatchIdleWindow: %s", conf.BatchIdleWindow)
+	log.Infof("BatchMaxAge: %s", conf.BatchMaxAge)
+	log.Infof("BatchMaxMetrics: %d", conf.BatchMaxMetrics)
+	log.Infof("BatchMaxSize: %d", conf.BatchMaxSize)
+	log.Infof("HashFields: %v", conf.HashFields)
+	log.Infof("HashExcludeFields: %v", conf.HashExcludeFields)
+	log.Infof("ShutdownDrainTimeout: %s", conf.ShutdownDrainTimeout)
+	log.Infof("GRPCServer: %v", conf.GRPCServer)
+	log.Infof("GRPCPort: %d", conf.GRPCPort)
+	log.Infof("GRPCBalancePolicy: %s", conf.GRPCBalancePolicy)
+	log.Infof("GRPCEjectFailures: %d", conf.GRPCEjectFailures)
+	log.Infof("GRPCEjectDuration: %s", conf.GRPCEjectDuration)
+	log.Infof("GRPCSecure: %t", conf.GRPCSecure)
+	log.Infof("GRPCClientCertFile: %s", conf.GRPCClientCertFile)
+	log.Infof("AuthTokenFile: %s", conf.AuthTokenFile)
+	log.Infof("AuthTokenMode: %s", conf.AuthTokenMode)
+	log.Infof("SensorID: %s", conf.SensorID)
+	log.Infof("GRPCMaxMsgSize: %d", confInstance.GRPCMaxMsgSize)
+	log.Infof("")
+
+	if conf.AlertFilePath == "" {
+		log.Fatalln("must specify the alert files to replay with --file")
+	}
+
+	speed := conf.ReplaySpeed
+	if conf.ReplayFast {
+		speed = 0
+	} else if speed <= 0 {
+		log.WithField("speed", speed).Fatalln("--speed must be greater than 0, use --fast to send as fast as possible")
+	}
+
+	mainContext, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	alertParser, err := parser.New(conf.AlertFormat)
+	if err != nil {
+		log.WithField("error", err).Fatalln("failed to create alert parser")
+	}
+
+	if err := processor.SetHashFields(conf.HashFields, conf.HashExcludeFields); err != nil {
+		log.WithField("error", err).Fatalln("invalid event hash fields")
+	}
+
+	lis, err := listener.NewReplayListener(conf.AlertFilePath, speed, alertParser)
+	if err != nil {
+		log.WithField("error", err).Fatalln("failed to create replay listener")
+	}
+
+	limits, err := eventQueueLimits(conf, confInstance.GRPCMaxMsgSize)
+	if err != nil {
+		log.WithField("error", err).Fatalln("invalid queue configuration")
+	}
+	// A replay waits for the server instead of dropping alerts.
+	limits.Overflow = queue.OverflowBlock
+	eventQueue := queue.NewEventBatchQueueWithLimits(limits)
+
+	streamManager, err := newStreamManager(conf, confInstance.GRPCMaxMsgSize)
+	if err != nil {
+		log.WithField("error", err).Fatalln("failed to create stream manager")
+	}
+
+	sender := &replaySender{sender: streamManager}
+
+	// The watcher keeps running after the listener is done until the queue is drained.
+	watcherContext, stopWatcher := context.WithCancel(mainContext)
+	defer stopWatcher()
+
+	g, gCtx := errgroup.WithContext(mainContext)
+
+	g.Go(func() error {
+		err := eventQueue.StartWatcher(watcherContext, sender)
+		log.WithField("package", "main").Infof("Watcher Job is stopped. (%v)\n", err)
+		return err
+	})
+
+	g.Go(func() error {
+		defer stopWatcher()
+
+		if err := lis.Start(gCtx, eventQueue); err != nil {
+			return err
+		}
+
+		waitForReplayDrain(gCtx, eventQueue, streamManager, conf.ShutdownDrainTimeout)
+		return nil
+	})
+
+	g.Go(func() error {
+		<-watcherContext.Done()
+		return lis.Stop()
+	})
+
+	err = g.Wait()
+	drainClient(conf.ShutdownDrainTimeout, eventQueue, sender, streamManager)
+
+	summary := lis.GetSummary()
+	alertSpan := time.Duration(summary.LastSeconds-summary.FirstSeconds) * time.Second
+
+	log.WithFields(logger.Fields{
+		"package":      "main",
+		"files":        summary.Files,
+		"failed_files": summary.FailedFiles,
+		"events":       summary.Events,
+		"sent_events":  sender.sent.Load(),
+		"unacked":      streamManager.GetUnackedEvents(),
+		"alert_span":   alertSpan.String(),
+		"duration":     summary.Duration.Round(time.Millisecond).String(),
+	}).Infoln("Replay finished")
+
+	if err != nil {
+		log.WithField("error", err).Fatalln("failed to replay the alert files")
+	}
+}
+
+// waitForReplayDrain waits until every queued event has been sent and acknowledged, or the timeout passes.
+func waitForReplayDrain(ctx context.Context, q *queue.EventBatchQueue, sm *grpc.StreamManager, timeout time.Duration) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	deadline := time.After(timeout)
+
+	// The queue holds events until they are handed to the stream manager, including failed batches.
+	for q.GetQueueBytes() > 0 || sm.GetUnackedEvents() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			log.WithFields(logger.Fields{
+				"package": "main",
+				"queued":  q.GetQueueSize(),
+				"unacked": sm.GetUnackedEvents(),
+			}).Warnln("Timed out waiting for the replayed events to be delivered")
+			return
+		case <-ticker.C:
+		}
+	}
+}
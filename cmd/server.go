@@ -2,22 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/mata-elang-stable/sensor-snort-service/internal/auth"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/kafka_producer"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/prometheus_exporter"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -40,6 +47,12 @@ func init() {
 	viper.SetDefault("secure", false)
 	viper.SetDefault("certificate", "")
 	viper.SetDefault("key", "")
+	viper.SetDefault("client_ca", "")
+	viper.SetDefault("require_client_cert", false)
+	viper.SetDefault("client_identity", string(auth.IdentityCommonName))
+	viper.SetDefault("auth_secrets_file", "")
+	viper.SetDefault("metrics_listen", ":9102")
+	viper.SetDefault("reflection", false)
 	viper.SetDefault("max_message_size", 100)
 	viper.SetDefault("kafka_brokers", "localhost:9092")
 	viper.SetDefault("schema_registry_url", "http://localhost:8081")
@@ -63,6 +76,12 @@ func init() {
 	flags.BoolVar(&serverConfig.GRPCSecure, "secure", serverConfig.GRPCSecure, "Specifies whether the connection is secure or not.")
 	flags.StringVar(&serverConfig.GRPCCertFile, "certificate", serverConfig.GRPCCertFile, "Path to TLS certificate file.")
 	flags.StringVar(&serverConfig.GRPCKeyFile, "key", serverConfig.GRPCKeyFile, "Path to TLS key file.")
+	flags.StringVar(&serverConfig.GRPCClientCAFile, "client-ca", serverConfig.GRPCClientCAFile, "Path to the CA certificate file client certificates are verified with.")
+	flags.BoolVar(&serverConfig.GRPCRequireClientCert, "require-client-cert", serverConfig.GRPCRequireClientCert, "Specifies whether clients without a valid certificate are rejected.")
+	flags.StringVar(&serverConfig.GRPCClientIdentity, "client-identity", serverConfig.GRPCClientIdentity, "Specifies the part of the client certificate that names the sensor: cn or san.")
+	flags.StringVar(&serverConfig.MetricsListen, "metrics-listen", serverConfig.MetricsListen, "Specifies the address the Prometheus metrics are served on, as host:port or unix:/path/to/socket. Empty or disabled disables them.")
+	flags.BoolVar(&serverConfig.GRPCReflection, "reflection", serverConfig.GRPCReflection, "Specifies whether the gRPC server reflection service is registered.")
+	flags.StringVar(&serverConfig.AuthSecretsFile, "auth-secrets-file", serverConfig.AuthSecretsFile, "Path to the file of sensor IDs and the SHA-256 of their secrets, one per line. Reloaded on change. The hashes sign hmac tokens, protect the file like the secrets.")
 	flags.IntVarP(&conf.GRPCMaxMsgSize, "max-message-size", "m", conf.GRPCMaxMsgSize, "Specifies the maximum message size.")
 	flags.StringVar(&serverConfig.SchemaRegistryUrl, "schema-registry-url", serverConfig.SchemaRegistryUrl, "Specifies the schema registry URL.")
 	flags.StringVar(&serverConfig.KafkaBrokers, "kafka-broker", serverConfig.KafkaBrokers, "Specifies the Kafka broker to connect to.")
@@ -81,6 +100,38 @@ func init() {
 type server struct {
 	pb.UnimplementedSensorServiceServer
 	kafkaProducerInstance *kafka_producer.Producer
+
+	// identitySource is the part of a client certificate that names the sensor.
+	identitySource auth.IdentitySource
+
+	metrics *prometheus_exporter.ServerMetrics
+}
+
+// streamMetrics counts a stream as open for the sensor of its first event and counts the events it receives.
+// Sensors are labeled by their authenticated identity when the stream has one.
+type streamMetrics struct {
+	metrics     *prometheus_exporter.ServerMetrics
+	identities  []string
+	sensorLabel string
+}
+
+// received counts an event that was authorized and returns the label of its sensor.
+func (sm *streamMetrics) received(payload *pb.SensorEvent) string {
+	// An authorized event names one of the identities of the stream.
+	label := sm.metrics.SensorLabel(payload.SensorId, sm.identities != nil)
+	if sm.sensorLabel == "" {
+		sm.sensorLabel = label
+		sm.metrics.StreamStarted(label)
+	}
+	sm.metrics.EventReceived(label, payload)
+
+	return label
+}
+
+func (sm *streamMetrics) close() {
+	if sm.sensorLabel != "" {
+		sm.metrics.StreamEnded(sm.sensorLabel)
+	}
 }
 
 func (s *server) StreamData(stream pb.SensorService_StreamDataServer) error {
@@ -88,6 +139,11 @@ func (s *server) StreamData(stream pb.SensorService_StreamDataServer) error {
 	currentSessionStreamCount := int64(0)
 	currentSessionBatchCount := int64(0)
 
+	identities := auth.Identities(stream.Context(), s.identitySource)
+
+	streamStats := &streamMetrics{metrics: s.metrics, identities: identities}
+	defer streamStats.close()
+
 	for {
 		payload, err := stream.Recv()
 		if err == io.EOF {
@@ -101,14 +157,24 @@ func (s *server) StreamData(stream pb.SensorService_StreamDataServer) error {
 			return fmt.Errorf("failed to receive data from client via gRPC stream: %w", err)
 		}
 
+		if err := auth.AuthorizeSensor(identities, payload.SensorId); err != nil {
+			log.Warnf("Rejected event from gRPC stream: %v\n", err)
+			return err
+		}
 		currentTime := time.Now()
 		payload.EventReceivedAt = currentTime.UnixMicro()
+		sensorLabel := streamStats.received(payload)
 
 		// calculate the total events received
 		currentSessionStreamCount += payload.EventMetricsCount
 		currentSessionBatchCount++
 
-		err = s.kafkaProducerInstance.Produce(payload)
+		receivedAt := payload.EventReceivedAt
+		err = s.kafkaProducerInstance.ProduceWithAck(payload, func(err error) {
+			if err == nil {
+				s.metrics.EventDelivered(sensorLabel, receivedAt)
+			}
+		})
 		if err != nil {
 			log.Errorf("Failed to produce message to Kafka: %v\n", err)
 			return err
@@ -119,6 +185,190 @@ func (s *server) StreamData(stream pb.SensorService_StreamDataServer) error {
 	}
 }
 
+// ackQueue holds the acks of a stream until its sender goroutine sends them. Pushing never blocks,
+// as the Kafka delivery callbacks of all streams run on the single event goroutine of the producer.
+type ackQueue struct {
+	mu     sync.Mutex
+	acks   []*pb.EventAck
+	closed bool
+	ready  chan struct{}
+}
+
+func newAckQueue() *ackQueue {
+	return &ackQueue{ready: make(chan struct{}, 1)}
+}
+
+// push queues an ack. Acks pushed after close are dropped.
+func (q *ackQueue) push(ack *pb.EventAck) {
+	q.mu.Lock()
+	if !q.closed {
+		q.acks = append(q.acks, ack)
+	}
+	q.mu.Unlock()
+	q.notify()
+}
+
+// close lets pop return once the queued acks have been taken.
+func (q *ackQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.notify()
+}
+
+func (q *ackQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop waits for acks and takes all of them, or returns false once the queue is closed and empty.
+func (q *ackQueue) pop() ([]*pb.EventAck, bool) {
+	for {
+		q.mu.Lock()
+		acks, closed := q.acks, q.closed
+		q.acks = nil
+		q.mu.Unlock()
+
+		if len(acks) > 0 {
+			return acks, true
+		}
+		if closed {
+			return nil, false
+		}
+		<-q.ready
+	}
+}
+
+// StreamDataWithAck receives events like StreamData, and acknowledges every event
+// by its hash once the Kafka delivery report for it has been received.
+func (s *server) StreamDataWithAck(stream pb.SensorService_StreamDataWithAckServer) (err error) {
+	log.Traceln("Waiting for data from client via gRPC ack stream...")
+	currentSessionStreamCount := int64(0)
+	currentSessionBatchCount := int64(0)
+
+	ctx := stream.Context()
+	identities := auth.Identities(ctx, s.identitySource)
+
+	streamStats := &streamMetrics{metrics: s.metrics, identities: identities}
+	defer streamStats.close()
+	acks := newAckQueue()
+	senderDone := make(chan error, 1)
+
+	// gRPC streams do not allow concurrent Send calls, so all acks go through a single goroutine.
+	go func() {
+		var sendErr error
+		for {
+			batch, ok := acks.pop()
+			if !ok {
+				break
+			}
+			for _, ack := range batch {
+				if sendErr != nil {
+					break
+				}
+				if err := stream.Send(ack); err != nil {
+					sendErr = fmt.Errorf("failed to send ack to client: %w", err)
+				}
+			}
+		}
+		senderDone <- sendErr
+	}()
+
+	var inFlight sync.WaitGroup
+
+	// Stop the ack sender on every exit, once the outstanding delivery reports have been received.
+	defer func() {
+		inFlight.Wait()
+		acks.close()
+		if sendErr := <-senderDone; err == nil {
+			err = sendErr
+		}
+	}()
+
+	for {
+		payload, err := stream.Recv()
+		if err == io.EOF {
+			log.Infof("Received %d events (%d) in total from gRPC ack stream session\n", currentSessionStreamCount, currentSessionBatchCount)
+			return nil
+		}
+		if err != nil {
+			log.Errorf("Failed to receive data from client via gRPC ack stream: %v\n", err)
+			return fmt.Errorf("failed to receive data from client via gRPC ack stream: %w", err)
+		}
+
+		if err := auth.AuthorizeSensor(identities, payload.SensorId); err != nil {
+			log.Warnf("Rejected event from gRPC ack stream: %v\n", err)
+			return err
+		}
+		payload.EventReceivedAt = time.Now().UnixMicro()
+		sensorLabel := streamStats.received(payload)
+
+		currentSessionStreamCount += payload.EventMetricsCount
+		currentSessionBatchCount++
+
+		hash, receivedAt := payload.EventHashSha256, payload.EventReceivedAt
+		inFlight.Add(1)
+		err = s.kafkaProducerInstance.ProduceWithAck(payload, func(err error) {
+			defer inFlight.Done()
+			if err == nil {
+				s.metrics.EventDelivered(sensorLabel, receivedAt)
+			}
+			ack := &pb.EventAck{EventHashSha256: hash, Success: err == nil}
+			if err != nil {
+				ack.Error = err.Error()
+			}
+			acks.push(ack)
+		})
+		if err != nil {
+			inFlight.Done()
+			log.Errorf("Failed to produce message to Kafka: %v\n", err)
+			acks.push(&pb.EventAck{EventHashSha256: hash, Success: false, Error: err.Error()})
+			continue
+		}
+
+		log.Tracef("Received payload: %v\n", payload)
+	}
+}
+
+// healthCheckInterval is how often Kafka and the schema registry are checked for the health service.
+const healthCheckInterval = 10 * time.Second
+
+// watchHealth reports the server as serving through the health service while the Kafka brokers and
+// the schema registry can be reached, checking them every healthCheckInterval until the context is done.
+func watchHealth(ctx context.Context, producer *kafka_producer.Producer, healthServer *health.Server) error {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	current := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		err := errors.Join(producer.CheckBrokers(5*time.Second), producer.CheckSchemaRegistry())
+
+		next := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			next = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if next != current {
+			if err != nil {
+				log.Warnf("Server is not serving: %v", err)
+			} else {
+				log.Infoln("Server is serving")
+			}
+
+			current = next
+			healthServer.SetServingStatus("", current)
+			healthServer.SetServingStatus(pb.SensorService_ServiceDesc.ServiceName, current)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
 func runServer(cmd *cobra.Command, args []string) {
 	confInstance := config.GetConfig()
 	confInstance.SetupLogging()
@@ -129,6 +379,12 @@ func runServer(cmd *cobra.Command, args []string) {
 	log.Infof("Host: %s", conf.GRPCHost)
 	log.Infof("Port: %d", conf.GRPCPort)
 	log.Infof("Secure: %t", conf.GRPCSecure)
+	log.Infof("Client CA: %s", conf.GRPCClientCAFile)
+	log.Infof("Require client certificate: %t", conf.GRPCRequireClientCert)
+	log.Infof("Client identity: %s", conf.GRPCClientIdentity)
+	log.Infof("Auth secrets file: %s", conf.AuthSecretsFile)
+	log.Infof("Metrics listen: %s", conf.MetricsListen)
+	log.Infof("Reflection: %t", conf.GRPCReflection)
 	log.Infof("GRPCMaxMsgSize: %d", confInstance.GRPCMaxMsgSize)
 	log.Infof("Kafka broker: %s", conf.KafkaBrokers)
 	log.Infof("Schema registry URL: %s", conf.SchemaRegistryUrl)
@@ -165,6 +421,9 @@ func runServer(cmd *cobra.Command, args []string) {
 		log.Fatalf("Failed to create kafka producer: %v", err)
 	}
 
+	metrics := prometheus_exporter.NewServerMetrics()
+	producer.SetSerializeObserver(metrics.ObserveSerialization)
+
 	// Initialize gRPC server
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", conf.GRPCHost, conf.GRPCPort))
 	if err != nil {
@@ -176,18 +435,92 @@ func runServer(cmd *cobra.Command, args []string) {
 		grpc.MaxSendMsgSize(confInstance.GRPCMaxMsgSize * 1024 * 1024),
 	}
 	if conf.GRPCSecure {
-		creds, err := credentials.NewServerTLSFromFile(conf.GRPCCertFile, conf.GRPCKeyFile)
+		tlsConfig, err := auth.ServerTLSConfig(conf.GRPCCertFile, conf.GRPCKeyFile, conf.GRPCClientCAFile, conf.GRPCRequireClientCert)
 		if err != nil {
 			log.Fatalf("Failed to load TLS %v", err)
 		}
 
-		opts = append(opts, grpc.Creds(creds))
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	} else if conf.GRPCClientCAFile != "" || conf.GRPCRequireClientCert {
+		log.Fatalf("Client certificates require --secure")
+	}
+
+	identitySource, err := auth.ParseIdentitySource(conf.GRPCClientIdentity)
+	if err != nil {
+		log.Fatalf("Invalid client identity: %v", err)
+	}
+
+	metricsAddr, err := prometheus_exporter.ParseListenAddress(conf.MetricsListen)
+	if err != nil {
+		log.Fatalf("Invalid metrics address: %v", err)
+	}
+
+	var authenticator *auth.TokenAuthenticator
+	if conf.AuthSecretsFile != "" {
+		authenticator, err = auth.NewTokenAuthenticator(conf.AuthSecretsFile)
+		if err != nil {
+			log.Fatalf("Failed to load the secrets file: %v", err)
+		}
+
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(authenticator.UnaryInterceptor()),
+			grpc.ChainStreamInterceptor(authenticator.StreamInterceptor()),
+		)
 	}
 
 	grpcServer := grpc.NewServer(opts...)
 
 	pb.RegisterSensorServiceServer(grpcServer, &server{
 		kafkaProducerInstance: producer,
+		identitySource:        identitySource,
+		metrics:               metrics,
+	})
+
+	// The health service reports whether events can be written to Kafka, it is served without authentication.
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthServer.SetServingStatus(pb.SensorService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	if conf.GRPCReflection {
+		reflection.Register(grpcServer)
+	}
+
+	g.Go(func() error {
+		return watchHealth(mainContext, producer, healthServer)
+	})
+
+	if authenticator != nil {
+		g.Go(func() error {
+			return authenticator.Watch(mainContext)
+		})
+	}
+
+	if metricsAddr != nil {
+		g.Go(func() error {
+			log.Infof("Starting Prometheus Exporter Server on %s...", metricsAddr)
+			err := metrics.StartServer(mainContext, metricsAddr)
+			log.Infof("Prometheus Exporter Job is stopped. (%v)", err)
+			return err
+		})
+	}
+
+	// Record the metrics of the producer and the authenticator every 10 seconds
+	g.Go(func() error {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-mainContext.Done():
+				return nil
+			case <-ticker.C:
+				metrics.RecordProducerMetrics(producer)
+				if authenticator != nil {
+					metrics.RecordAuthMetrics(authenticator)
+				}
+			}
+		}
 	})
 
 	g.Go(func() error {
@@ -195,6 +528,7 @@ func runServer(cmd *cobra.Command, args []string) {
 
 		log.Infoln("Shutting down the server...")
 		cancel()
+		healthServer.Shutdown()
 		grpcServer.Stop()
 		producer.Flush(15 * 1000)
 		producer.Close()
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+)
+
+func Test_AckQueue(t *testing.T) {
+	q := newAckQueue()
+
+	// Pushing never blocks, however far the sender is behind.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5000; i++ {
+			q.push(&pb.EventAck{EventHashSha256: fmt.Sprint(i)})
+		}
+		q.close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected push to never block")
+	}
+
+	got := 0
+	for {
+		batch, ok := q.pop()
+		if !ok {
+			break
+		}
+		for _, ack := range batch {
+			if want := fmt.Sprint(got); ack.EventHashSha256 != want {
+				t.Fatalf("Expected ack %s, got %s", want, ack.EventHashSha256)
+			}
+			got++
+		}
+	}
+	if got != 5000 {
+		t.Errorf("Expected 5000 acks, got %d", got)
+	}
+
+	q.push(&pb.EventAck{})
+	if _, ok := q.pop(); ok {
+		t.Error("Expected acks pushed after close to be dropped")
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// IdentitySource is the part of a client certificate that names the sensor.
+type IdentitySource string
+
+const (
+	// IdentityCommonName takes the sensor ID from the common name of the certificate subject.
+	IdentityCommonName IdentitySource = "cn"
+
+	// IdentitySAN takes the sensor ID from the DNS and URI subject alternative names of the certificate.
+	IdentitySAN IdentitySource = "san"
+)
+
+// ParseIdentitySource parses the name of an identity source.
+func ParseIdentitySource(name string) (IdentitySource, error) {
+	switch source := IdentitySource(strings.ToLower(name)); source {
+	case IdentityCommonName, IdentitySAN:
+		return source, nil
+	}
+
+	return "", fmt.Errorf("unknown client identity %q, expected %q or %q", name, IdentityCommonName, IdentitySAN)
+}
+
+// ServerTLSConfig loads the certificate of the server. With a client CA file, client certificates
+// signed by it are verified, and requireClientCert rejects clients that do not present one.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile == "" {
+		if requireClientCert {
+			return nil, fmt.Errorf("a client CA file is required to verify client certificates")
+		}
+		return tlsConfig, nil
+	}
+
+	tlsConfig.ClientCAs, err = LoadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if requireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// LoadCertPool reads the PEM encoded certificates in file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+
+	return pool, nil
+}
+
+// PeerIdentities returns the sensor IDs the verified client certificate of the peer is issued to.
+// It returns nil when the peer did not present a verified certificate.
+func PeerIdentities(ctx context.Context, source IdentitySource) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return CertificateIdentities(info.State.VerifiedChains[0][0], source)
+}
+
+// CertificateIdentities returns the sensor IDs named by the certificate.
+// The result is never nil, so a certificate without any identity is not mistaken for no certificate.
+func CertificateIdentities(cert *x509.Certificate, source IdentitySource) []string {
+	identities := make([]string, 0, 1)
+
+	if source != IdentitySAN {
+		if cert.Subject.CommonName != "" {
+			identities = append(identities, cert.Subject.CommonName)
+		}
+		return identities
+	}
+
+	identities = append(identities, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+
+	return identities
+}
+
+// AuthorizeSensor checks that the sensor ID of an event is one the client is authenticated as.
+// Clients that are not authenticated by a certificate may send events of any sensor.
+func AuthorizeSensor(identities []string, sensorID string) error {
+	if identities == nil || slices.Contains(identities, sensorID) {
+		return nil
+	}
+
+	return status.Errorf(codes.PermissionDenied, "sensor %q does not match the client certificate %v", sensorID, identities)
+}
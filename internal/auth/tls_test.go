@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// testCA issues certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a certificate signed by the CA, modified by the template function.
+func (ca *testCA) issue(t *testing.T, modify func(*x509.Certificate)) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	modify(template)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// writePEM writes the certificate and key of cert to dir and returns their paths.
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func Test_CertificateIdentities(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, func(c *x509.Certificate) {
+		c.Subject.CommonName = "sensor-1"
+		c.DNSNames = []string{"sensor-1.example.org"}
+		c.URIs = []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/sensor/1"}}
+	})
+
+	tests := []struct {
+		name   string
+		source IdentitySource
+		want   []string
+	}{
+		{
+			name:   "Must use the common name",
+			source: IdentityCommonName,
+			want:   []string{"sensor-1"},
+		},
+		{
+			name:   "Must use the DNS and URI SANs",
+			source: IdentitySAN,
+			want:   []string{"sensor-1.example.org", "spiffe://example.org/sensor/1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, CertificateIdentities(cert.Leaf, tt.source)); diff != "" {
+				t.Errorf("CertificateIdentities() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_AuthorizeSensor(t *testing.T) {
+	tests := []struct {
+		name       string
+		identities []string
+		sensorID   string
+		wantErr    bool
+	}{
+		{name: "Must allow any sensor without a certificate", identities: nil, sensorID: "sensor-2"},
+		{name: "Must allow the authenticated sensor", identities: []string{"sensor-1"}, sensorID: "sensor-1"},
+		{name: "Must reject another sensor", identities: []string{"sensor-1"}, sensorID: "sensor-2", wantErr: true},
+		{name: "Must reject a certificate without identity", identities: []string{}, sensorID: "sensor-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AuthorizeSensor(tt.identities, tt.sensorID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthorizeSensor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && status.Code(err) != codes.PermissionDenied {
+				t.Errorf("Expected PermissionDenied, got %v", status.Code(err))
+			}
+		})
+	}
+}
+
+func Test_ServerTLSConfigMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	serverCert, serverKey := writePEM(t, dir, "server", ca.issue(t, func(c *x509.Certificate) {
+		c.DNSNames = []string{"collector"}
+	}))
+	sensor := ca.issue(t, func(c *x509.Certificate) {
+		c.Subject.CommonName = "sensor-1"
+	})
+
+	serverConfig, err := ServerTLSConfig(serverCert, serverKey, caFile, true)
+	if err != nil {
+		t.Fatalf("ServerTLSConfig() error = %v", err)
+	}
+
+	// handshake connects a client with the given certificates and returns the identities the server sees.
+	handshake := func(certs []tls.Certificate) ([]string, error) {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+
+		client := tls.Client(clientConn, &tls.Config{
+			RootCAs:      ca.pool(),
+			ServerName:   "collector",
+			Certificates: certs,
+		})
+		go func() {
+			_ = client.Handshake()
+			// Read the server's error alert, if any, so the server side handshake can finish.
+			_, _ = client.Read(make([]byte, 1))
+		}()
+
+		server := tls.Server(serverConn, serverConfig)
+		if err := server.Handshake(); err != nil {
+			return nil, err
+		}
+
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: server.ConnectionState()},
+		})
+		return PeerIdentities(ctx, IdentityCommonName), nil
+	}
+
+	identities, err := handshake([]tls.Certificate{sensor})
+	if err != nil {
+		t.Fatalf("Expected the sensor certificate to be accepted, got %v", err)
+	}
+	if diff := cmp.Diff([]string{"sensor-1"}, identities); diff != "" {
+		t.Errorf("PeerIdentities() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := handshake(nil); err == nil {
+		t.Errorf("Expected a client without certificate to be rejected")
+	}
+
+	if _, err := ServerTLSConfig(serverCert, serverKey, "", true); err == nil {
+		t.Errorf("Expected requiring client certificates without a client CA to fail")
+	}
+}
@@ -0,0 +1,489 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var log = logger.GetLogger()
+
+// TokenMode is how a sensor proves it knows its secret.
+type TokenMode string
+
+const (
+	// TokenBearer sends the secret itself. It should only be used over TLS.
+	TokenBearer TokenMode = "bearer"
+
+	// TokenHMAC sends an HMAC of the sensor ID, the called method, the current time and a nonce keyed
+	// with the hashed secret, so the secret never leaves the sensor and a captured signature can neither
+	// be used for another method nor a second time on the same server. As the key is the hash stored in the secrets file,
+	// that file is as sensitive as the secrets themselves.
+	TokenHMAC TokenMode = "hmac"
+)
+
+const (
+	// SensorIDMetadata is the metadata key of the sensor a token belongs to.
+	SensorIDMetadata = "x-sensor-id"
+
+	// AuthorizationMetadata is the metadata key of the bearer token or HMAC signature.
+	AuthorizationMetadata = "authorization"
+
+	bearerPrefix = "Bearer "
+	hmacPrefix   = "HMAC-SHA256 "
+
+	// MaxClockSkew is how far the time of an HMAC signature may be from the time of the server.
+	MaxClockSkew = 5 * time.Minute
+
+	// secretsPollInterval is how often the secrets file is checked for changes.
+	secretsPollInterval = 5 * time.Second
+)
+
+// Reasons an authentication attempt is rejected.
+const (
+	RejectMissingCredentials = "missing_credentials"
+	RejectUnknownSensor      = "unknown_sensor"
+	RejectInvalidToken       = "invalid_token"
+	RejectExpiredSignature   = "expired_signature"
+	RejectReplayedSignature  = "replayed_signature"
+)
+
+// ParseTokenMode parses the name of a token mode.
+func ParseTokenMode(name string) (TokenMode, error) {
+	switch mode := TokenMode(strings.ToLower(name)); mode {
+	case TokenBearer, TokenHMAC:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown token mode %q, expected %q or %q", name, TokenBearer, TokenHMAC)
+}
+
+// HashSecret returns the hash of a sensor secret as it is stored in the secrets file.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// signature returns the HMAC of the sensor ID, method, time and nonce, keyed with the hashed secret.
+func signature(hashedSecret []byte, sensorID, method string, unix int64, nonce string) []byte {
+	mac := hmac.New(sha256.New, hashedSecret)
+	mac.Write([]byte(sensorID + ":" + method + ":" + strconv.FormatInt(unix, 10) + ":" + nonce))
+	return mac.Sum(nil)
+}
+
+// hmacAuthorization returns the authorization of a sensor for a call of the method signed at the given time.
+func hmacAuthorization(secret, sensorID, method string, unix int64, nonce string) string {
+	hashed := sha256.Sum256([]byte(secret))
+	sig := signature(hashed[:], sensorID, method, unix, nonce)
+	return fmt.Sprintf("%s%d:%s:%s", hmacPrefix, unix, nonce, hex.EncodeToString(sig))
+}
+
+// newNonce returns a random nonce that makes every HMAC signature unique.
+func newNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(nonce), nil
+}
+
+// TokenCredentials attaches the token of a sensor to every call.
+type TokenCredentials struct {
+	sensorID string
+	mode     TokenMode
+	secret   func() (string, error)
+}
+
+// NewTokenCredentials returns credentials for the sensor. The secret is read from file on every new
+// stream so it can be rotated, or taken from secret when file is empty.
+func NewTokenCredentials(sensorID string, mode TokenMode, file, secret string) (*TokenCredentials, error) {
+	if sensorID == "" {
+		return nil, fmt.Errorf("a sensor ID is required for token authentication")
+	}
+
+	t := &TokenCredentials{
+		sensorID: sensorID,
+		mode:     mode,
+		secret:   func() (string, error) { return secret, nil },
+	}
+
+	if file != "" {
+		t.secret = func() (string, error) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return "", fmt.Errorf("failed to read token file: %w", err)
+			}
+			return string(bytes.TrimSpace(data)), nil
+		}
+	}
+
+	if s, err := t.secret(); err != nil {
+		return nil, err
+	} else if s == "" {
+		return nil, fmt.Errorf("the token is empty")
+	}
+
+	return t, nil
+}
+
+func (t *TokenCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	secret, err := t.secret()
+	if err != nil {
+		return nil, err
+	}
+
+	md := map[string]string{SensorIDMetadata: t.sensorID}
+	switch t.mode {
+	case TokenHMAC:
+		nonce, err := newNonce()
+		if err != nil {
+			return nil, err
+		}
+		info, _ := credentials.RequestInfoFromContext(ctx)
+		md[AuthorizationMetadata] = hmacAuthorization(secret, t.sensorID, info.Method, time.Now().Unix(), nonce)
+	default:
+		md[AuthorizationMetadata] = bearerPrefix + secret
+	}
+
+	return md, nil
+}
+
+// RequireTransportSecurity allows tokens on insecure connections, e.g. on a trusted network.
+// Use TokenHMAC there so the secret is not sent in the clear. The calls themselves are still not
+// protected without TLS.
+func (t *TokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+type sensorKey struct{}
+
+// SensorFromContext returns the sensor authenticated by its token.
+func SensorFromContext(ctx context.Context) (string, bool) {
+	sensor, ok := ctx.Value(sensorKey{}).(string)
+	return sensor, ok
+}
+
+// Identities returns the sensor IDs the client is authenticated as by its certificate and its token.
+// When both authenticate the client, only a sensor named by both is returned.
+// It returns nil when the client is not authenticated at all.
+func Identities(ctx context.Context, source IdentitySource) []string {
+	identities := PeerIdentities(ctx, source)
+
+	sensor, ok := SensorFromContext(ctx)
+	if !ok {
+		return identities
+	}
+	if identities == nil || slices.Contains(identities, sensor) {
+		return []string{sensor}
+	}
+
+	return []string{}
+}
+
+// TokenAuthenticator checks the tokens of the sensors against the hashed secrets in a file,
+// which is reloaded when it changes.
+type TokenAuthenticator struct {
+	path string
+
+	mu      sync.RWMutex
+	secrets map[string][]byte
+	modTime time.Time
+	size    int64
+
+	rejectedMu sync.Mutex
+	rejected   map[string]int64
+
+	// nonces are the nonces of the HMAC signatures accepted within the clock skew, by the time they expire.
+	noncesMu sync.Mutex
+	nonces   map[string]time.Time
+}
+
+// NewTokenAuthenticator loads the secrets file. Every line holds a sensor ID and the hex
+// SHA-256 of its secret, separated by whitespace. Empty lines and lines starting with # are ignored.
+// The hashes are the keys of the HMAC signatures, so the file must be protected like the secrets.
+func NewTokenAuthenticator(path string) (*TokenAuthenticator, error) {
+	a := &TokenAuthenticator{
+		path:     path,
+		rejected: make(map[string]int64),
+		nonces:   make(map[string]time.Time),
+	}
+
+	if _, err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Watch reloads the secrets file when it changes until the context is done.
+// A file that cannot be loaded is logged and the previous secrets stay in use.
+func (a *TokenAuthenticator) Watch(ctx context.Context) error {
+	ticker := time.NewTicker(secretsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		reloaded, err := a.reload()
+		if err != nil {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "auth",
+				"file":    a.path,
+			}).Errorln("failed to reload secrets file, keeping the previous secrets")
+			continue
+		}
+		if reloaded {
+			log.WithFields(logger.Fields{
+				"package": "auth",
+				"file":    a.path,
+				"sensors": a.sensors(),
+			}).Infoln("Reloaded secrets file")
+		}
+	}
+}
+
+// reload reads the secrets file if it changed since it was last read.
+func (a *TokenAuthenticator) reload() (bool, error) {
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		return false, err
+	}
+
+	a.mu.RLock()
+	unchanged := a.secrets != nil && fi.ModTime().Equal(a.modTime) && fi.Size() == a.size
+	a.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	secrets, err := readSecrets(a.path)
+	if err != nil {
+		return false, err
+	}
+
+	a.mu.Lock()
+	a.secrets = secrets
+	a.modTime = fi.ModTime()
+	a.size = fi.Size()
+	a.mu.Unlock()
+
+	return true, nil
+}
+
+func readSecrets(path string) (map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	secrets := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a sensor ID and a secret hash", path, line)
+		}
+
+		hashed, err := hex.DecodeString(fields[1])
+		if err != nil || len(hashed) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: the secret hash is not a hex SHA-256", path, line)
+		}
+		secrets[fields[0]] = hashed
+	}
+
+	return secrets, scanner.Err()
+}
+
+func (a *TokenAuthenticator) sensors() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return len(a.secrets)
+}
+
+// Authenticate checks the token in the metadata of a call of the method and returns the sensor it belongs to.
+func (a *TokenAuthenticator) Authenticate(ctx context.Context, method string) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	sensorID := first(md.Get(SensorIDMetadata))
+	authorization := first(md.Get(AuthorizationMetadata))
+
+	if sensorID == "" || authorization == "" {
+		return "", a.reject(ctx, RejectMissingCredentials, sensorID)
+	}
+
+	a.mu.RLock()
+	hashed, ok := a.secrets[sensorID]
+	a.mu.RUnlock()
+	if !ok {
+		return "", a.reject(ctx, RejectUnknownSensor, sensorID)
+	}
+
+	switch {
+	case strings.HasPrefix(authorization, bearerPrefix):
+		sum := sha256.Sum256([]byte(strings.TrimPrefix(authorization, bearerPrefix)))
+		if subtle.ConstantTimeCompare(sum[:], hashed) != 1 {
+			return "", a.reject(ctx, RejectInvalidToken, sensorID)
+		}
+
+	case strings.HasPrefix(authorization, hmacPrefix):
+		parts := strings.Split(strings.TrimPrefix(authorization, hmacPrefix), ":")
+		if len(parts) != 3 || parts[1] == "" {
+			return "", a.reject(ctx, RejectInvalidToken, sensorID)
+		}
+		unix, err := strconv.ParseInt(parts[0], 10, 64)
+		got, hexErr := hex.DecodeString(parts[2])
+		if err != nil || hexErr != nil || !hmac.Equal(got, signature(hashed, sensorID, method, unix, parts[1])) {
+			return "", a.reject(ctx, RejectInvalidToken, sensorID)
+		}
+		if skew := time.Since(time.Unix(unix, 0)); skew > MaxClockSkew || skew < -MaxClockSkew {
+			return "", a.reject(ctx, RejectExpiredSignature, sensorID)
+		}
+		if !a.useNonce(sensorID + ":" + parts[1]) {
+			return "", a.reject(ctx, RejectReplayedSignature, sensorID)
+		}
+
+	default:
+		return "", a.reject(ctx, RejectInvalidToken, sensorID)
+	}
+
+	return sensorID, nil
+}
+
+// useNonce records the nonce of an accepted signature and reports whether it was not used before.
+// A nonce is kept as long as its signature could pass the clock skew check.
+func (a *TokenAuthenticator) useNonce(nonce string) bool {
+	a.noncesMu.Lock()
+	defer a.noncesMu.Unlock()
+
+	now := time.Now()
+	for n, expires := range a.nonces {
+		if now.After(expires) {
+			delete(a.nonces, n)
+		}
+	}
+
+	if _, ok := a.nonces[nonce]; ok {
+		return false
+	}
+	a.nonces[nonce] = now.Add(2 * MaxClockSkew)
+
+	return true
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// reject logs and counts a rejected attempt and returns the error for the client.
+func (a *TokenAuthenticator) reject(ctx context.Context, reason, sensorID string) error {
+	a.rejectedMu.Lock()
+	a.rejected[reason]++
+	a.rejectedMu.Unlock()
+
+	fields := logger.Fields{
+		"package":   "auth",
+		"reason":    reason,
+		"sensor_id": sensorID,
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields["peer"] = p.Addr.String()
+	}
+	log.WithFields(fields).Warnln("Rejected sensor authentication")
+
+	return status.Error(codes.Unauthenticated, "invalid sensor credentials")
+}
+
+// GetRejected returns the number of rejected attempts by reason since the last call.
+func (a *TokenAuthenticator) GetRejected() map[string]int64 {
+	a.rejectedMu.Lock()
+	defer a.rejectedMu.Unlock()
+
+	rejected := a.rejected
+	a.rejected = make(map[string]int64)
+
+	return rejected
+}
+
+// healthMethodPrefix is the prefix of the methods of the gRPC health service. Health checks are not
+// authenticated, so container and load balancer probes work without a sensor secret.
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// UnaryInterceptor authenticates unary calls.
+func (a *TokenAuthenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(ctx, req)
+		}
+
+		sensorID, err := a.Authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(context.WithValue(ctx, sensorKey{}, sensorID), req)
+	}
+}
+
+// StreamInterceptor authenticates streams.
+func (a *TokenAuthenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(srv, ss)
+		}
+
+		sensorID, err := a.Authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &authenticatedStream{
+			ServerStream: ss,
+			ctx:          context.WithValue(ss.Context(), sensorKey{}, sensorID),
+		})
+	}
+}
+
+// authenticatedStream carries the authenticated sensor in the context of the stream.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
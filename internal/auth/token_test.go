@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testMethod is the method the calls of the tests are made to.
+const testMethod = "/pb.SensorService/StreamDataWithAck"
+
+// incomingContext returns the context the server sees for a call of testMethod made with the credentials.
+func incomingContext(t *testing.T, creds *TokenCredentials) context.Context {
+	t.Helper()
+
+	ctx := credentials.NewContextWithRequestInfo(context.Background(), credentials.RequestInfo{Method: testMethod})
+	md, err := creds.GetRequestMetadata(ctx)
+	if err != nil {
+		t.Fatalf("GetRequestMetadata() error = %v", err)
+	}
+
+	return metadata.NewIncomingContext(context.Background(), metadata.New(md))
+}
+
+func writeSecrets(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_TokenAuthenticator(t *testing.T) {
+	dir := t.TempDir()
+	secretsFile := filepath.Join(dir, "secrets")
+	writeSecrets(t, secretsFile, "# sensors\nsensor-1 "+HashSecret("s3cret")+"\n\nsensor-2 "+HashSecret("other")+"\n")
+
+	a, err := NewTokenAuthenticator(secretsFile)
+	if err != nil {
+		t.Fatalf("NewTokenAuthenticator() error = %v", err)
+	}
+
+	credentials := func(sensorID string, mode TokenMode, secret string) *TokenCredentials {
+		creds, err := NewTokenCredentials(sensorID, mode, "", secret)
+		if err != nil {
+			t.Fatalf("NewTokenCredentials() error = %v", err)
+		}
+		return creds
+	}
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		wantSensor string
+		wantReason string
+	}{
+		{
+			name:       "Must accept a bearer token",
+			ctx:        incomingContext(t, credentials("sensor-1", TokenBearer, "s3cret")),
+			wantSensor: "sensor-1",
+		},
+		{
+			name:       "Must accept an HMAC signature",
+			ctx:        incomingContext(t, credentials("sensor-2", TokenHMAC, "other")),
+			wantSensor: "sensor-2",
+		},
+		{
+			name:       "Must reject the secret of another sensor",
+			ctx:        incomingContext(t, credentials("sensor-2", TokenBearer, "s3cret")),
+			wantReason: RejectInvalidToken,
+		},
+		{
+			name:       "Must reject an unknown sensor",
+			ctx:        incomingContext(t, credentials("sensor-3", TokenHMAC, "s3cret")),
+			wantReason: RejectUnknownSensor,
+		},
+		{
+			name:       "Must reject a call without credentials",
+			ctx:        context.Background(),
+			wantReason: RejectMissingCredentials,
+		},
+		{
+			name: "Must reject an old HMAC signature",
+			ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+				SensorIDMetadata, "sensor-1",
+				AuthorizationMetadata, hmacAuthorization("s3cret", "sensor-1", testMethod, time.Now().Add(-time.Hour).Unix(), "nonce"),
+			)),
+			wantReason: RejectExpiredSignature,
+		},
+		{
+			name: "Must reject an HMAC signature for another method",
+			ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+				SensorIDMetadata, "sensor-1",
+				AuthorizationMetadata, hmacAuthorization("s3cret", "sensor-1", "/pb.SensorService/StreamData", time.Now().Unix(), "nonce"),
+			)),
+			wantReason: RejectInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sensor, err := a.Authenticate(tt.ctx, testMethod)
+			if sensor != tt.wantSensor {
+				t.Errorf("Authenticate() = %q, want %q", sensor, tt.wantSensor)
+			}
+
+			rejected := a.GetRejected()
+			if tt.wantReason == "" {
+				if err != nil || len(rejected) != 0 {
+					t.Errorf("Expected no rejection, got %v and %v", err, rejected)
+				}
+				return
+			}
+			if status.Code(err) != codes.Unauthenticated {
+				t.Errorf("Expected Unauthenticated, got %v", err)
+			}
+			if diff := cmp.Diff(map[string]int64{tt.wantReason: 1}, rejected); diff != "" {
+				t.Errorf("GetRejected() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_TokenAuthenticatorReplay(t *testing.T) {
+	secretsFile := filepath.Join(t.TempDir(), "secrets")
+	writeSecrets(t, secretsFile, "sensor-1 "+HashSecret("s3cret")+"\n")
+
+	a, err := NewTokenAuthenticator(secretsFile)
+	if err != nil {
+		t.Fatalf("NewTokenAuthenticator() error = %v", err)
+	}
+	creds, _ := NewTokenCredentials("sensor-1", TokenHMAC, "", "s3cret")
+
+	ctx := incomingContext(t, creds)
+	if _, err := a.Authenticate(ctx, testMethod); err != nil {
+		t.Fatalf("Expected the signature to be accepted, got %v", err)
+	}
+
+	// A captured signature cannot be used a second time.
+	if _, err := a.Authenticate(ctx, testMethod); err == nil {
+		t.Error("Expected a replayed signature to be rejected")
+	}
+	if diff := cmp.Diff(map[string]int64{RejectReplayedSignature: 1}, a.GetRejected()); diff != "" {
+		t.Errorf("GetRejected() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Every call is signed with a new nonce.
+	if _, err := a.Authenticate(incomingContext(t, creds), testMethod); err != nil {
+		t.Errorf("Expected a new signature to be accepted, got %v", err)
+	}
+}
+
+func Test_TokenAuthenticatorReload(t *testing.T) {
+	secretsFile := filepath.Join(t.TempDir(), "secrets")
+	writeSecrets(t, secretsFile, "sensor-1 "+HashSecret("old")+"\n")
+
+	a, err := NewTokenAuthenticator(secretsFile)
+	if err != nil {
+		t.Fatalf("NewTokenAuthenticator() error = %v", err)
+	}
+
+	creds, _ := NewTokenCredentials("sensor-1", TokenHMAC, "", "new")
+	if _, err := a.Authenticate(incomingContext(t, creds), testMethod); err == nil {
+		t.Fatalf("Expected the new secret to be rejected before the reload")
+	}
+
+	// A broken file keeps the previous secrets.
+	writeSecrets(t, secretsFile, "sensor-1\n")
+	if _, err := a.reload(); err == nil {
+		t.Errorf("Expected a broken secrets file to fail to load")
+	}
+
+	writeSecrets(t, secretsFile, "sensor-1 "+HashSecret("new")+"\n")
+	// The new file has the same size as the first one, make sure its time differs too.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(secretsFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := a.reload(); !reloaded || err != nil {
+		t.Fatalf("reload() = %t, %v, want true, nil", reloaded, err)
+	}
+	if _, err := a.Authenticate(incomingContext(t, creds), testMethod); err != nil {
+		t.Errorf("Expected the new secret to be accepted after the reload, got %v", err)
+	}
+}
+
+func Test_StreamInterceptorIdentity(t *testing.T) {
+	secretsFile := filepath.Join(t.TempDir(), "secrets")
+	writeSecrets(t, secretsFile, "sensor-1 "+HashSecret("s3cret")+"\n")
+
+	a, err := NewTokenAuthenticator(secretsFile)
+	if err != nil {
+		t.Fatalf("NewTokenAuthenticator() error = %v", err)
+	}
+	creds, _ := NewTokenCredentials("sensor-1", TokenBearer, "", "s3cret")
+
+	var identities []string
+	info := &grpc.StreamServerInfo{FullMethod: testMethod}
+	err = a.StreamInterceptor()(nil, &fakeServerStream{ctx: incomingContext(t, creds)}, info, func(_ any, stream grpc.ServerStream) error {
+		identities = Identities(stream.Context(), IdentityCommonName)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamInterceptor() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{"sensor-1"}, identities); diff != "" {
+		t.Errorf("Identities() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_InterceptorsSkipHealthChecks(t *testing.T) {
+	secretsFile := filepath.Join(t.TempDir(), "secrets")
+	writeSecrets(t, secretsFile, "sensor-1 "+HashSecret("s3cret")+"\n")
+
+	a, err := NewTokenAuthenticator(secretsFile)
+	if err != nil {
+		t.Fatalf("NewTokenAuthenticator() error = %v", err)
+	}
+
+	unary := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	if _, err := a.UnaryInterceptor()(context.Background(), nil, unary, func(context.Context, any) (any, error) {
+		return nil, nil
+	}); err != nil {
+		t.Errorf("Expected the health check to be allowed without credentials, got %v", err)
+	}
+
+	stream := &grpc.StreamServerInfo{FullMethod: "/grpc.health.v1.Health/Watch"}
+	if err := a.StreamInterceptor()(nil, &fakeServerStream{ctx: context.Background()}, stream, func(any, grpc.ServerStream) error {
+		return nil
+	}); err != nil {
+		t.Errorf("Expected the health watch to be allowed without credentials, got %v", err)
+	}
+
+	unary = &grpc.UnaryServerInfo{FullMethod: "/pb.SensorService/StreamData"}
+	if _, err := a.UnaryInterceptor()(context.Background(), nil, unary, func(context.Context, any) (any, error) {
+		return nil, nil
+	}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected other methods to require credentials, got %v", err)
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (f *fakeServerStream) Context() context.Context {
+	return f.ctx
+}
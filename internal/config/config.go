@@ -11,18 +11,64 @@ import (
 )
 
 type ClientConfig struct {
-	// AlertFilePath is the path to the Snort alert file (used with --file flag).
+	// AlertFilePath is the path to the Snort alert file, a glob pattern or a directory (used with --file flag).
 	AlertFilePath string `mapstructure:"file"`
 
+	// NoFollow reads the alert files, plain or compressed, to the end once and exits instead of following them.
+	NoFollow bool `mapstructure:"no_follow"`
+
 	// AlertSocketPath is the path to the Snort alert unix socket (used with --socket flag).
 	AlertSocketPath string `mapstructure:"socket"`
 
-	// GRPCServer is the server to connect to.
-	GRPCServer string `mapstructure:"server"`
+	// SocketType is the type of the alert unix socket, "stream" or "datagram".
+	SocketType string `mapstructure:"socket_type"`
+
+	// SocketMaxRecordSize is the maximum size in bytes of a single record read from the alert unix socket.
+	SocketMaxRecordSize int `mapstructure:"socket_max_record_size"`
+
+	// SyslogListen is the address to receive Snort alerts forwarded over syslog on (used with --syslog-listen flag).
+	SyslogListen string `mapstructure:"syslog_listen"`
+
+	// SyslogProtocol is the transport of the syslog listener, "udp", "tcp" or "tls".
+	SyslogProtocol string `mapstructure:"syslog_protocol"`
+
+	// SyslogTLSCert is the certificate file of the syslog listener when SyslogProtocol is "tls".
+	SyslogTLSCert string `mapstructure:"syslog_tls_cert"`
+
+	// SyslogTLSKey is the private key file of the syslog listener when SyslogProtocol is "tls".
+	SyslogTLSKey string `mapstructure:"syslog_tls_key"`
+
+	// Unified2Dir is the directory containing the Snort unified2 spool files (used with --unified2-dir flag).
+	Unified2Dir string `mapstructure:"unified2_dir"`
 
-	// GRPCPort is the port to connect to the server.
+	// Unified2Prefix is the file name prefix of the unified2 spool files.
+	Unified2Prefix string `mapstructure:"unified2_prefix"`
+
+	// SidMsgMap is the path to the sid-msg.map file used to resolve unified2 rule messages.
+	SidMsgMap string `mapstructure:"sid_msg_map"`
+
+	// ClassificationConfig is the path to the classification.config file used to resolve unified2 classifications.
+	ClassificationConfig string `mapstructure:"classification_config"`
+
+	// AlertFormat is the format of the alert records, "snort", "suricata", "fast" or "full".
+	AlertFormat string `mapstructure:"format"`
+
+	// GRPCServer are the servers to connect to, as host or host:port.
+	// A host that resolves to several addresses is balanced over all of them.
+	GRPCServer []string `mapstructure:"server"`
+
+	// GRPCPort is the port to connect to the servers that are given without one.
 	GRPCPort int `mapstructure:"port"`
 
+	// GRPCBalancePolicy is how events are spread over the servers, "round-robin" or "priority".
+	GRPCBalancePolicy string `mapstructure:"balance_policy"`
+
+	// GRPCEjectFailures is the number of consecutive failures after which a server is ejected. Zero never ejects.
+	GRPCEjectFailures int `mapstructure:"eject_failures"`
+
+	// GRPCEjectDuration is how long an ejected server is skipped.
+	GRPCEjectDuration time.Duration `mapstructure:"eject_duration"`
+
 	// GRPCSecure is a flag to determine whether the connection is secure or not.
 	GRPCSecure bool `mapstructure:"secure"`
 
@@ -38,6 +84,21 @@ type ClientConfig struct {
 	// GRPCServerName is the name of the server.
 	GRPCServerName string `mapstructure:"server_name"`
 
+	// GRPCClientCertFile is the certificate the sensor authenticates itself to the server with (mutual TLS).
+	GRPCClientCertFile string `mapstructure:"client_certificate"`
+
+	// GRPCClientKeyFile is the private key of GRPCClientCertFile.
+	GRPCClientKeyFile string `mapstructure:"client_key"`
+
+	// AuthTokenFile is the file holding the secret the sensor authenticates to the server with.
+	AuthTokenFile string `mapstructure:"auth_token_file"`
+
+	// AuthToken is the secret of the sensor when AuthTokenFile is not set, e.g. from MES_CLIENT_AUTH_TOKEN.
+	AuthToken string `mapstructure:"auth_token"`
+
+	// AuthTokenMode is how the secret is sent, "hmac" or "bearer".
+	AuthTokenMode string `mapstructure:"auth_token_mode"`
+
 	// FieldsToSkip is the fields to skip in the log.
 	FieldsToSkip []string
 
@@ -46,6 +107,85 @@ type ClientConfig struct {
 
 	// TestingMode is the flag to determine whether the application is in testing mode or not.
 	TestingMode bool `mapstructure:"testing_mode"`
+
+	// BookmarkFile is the file used to persist the read position of the alert file or unified2 spool.
+	// An empty value disables the bookmark.
+	BookmarkFile string `mapstructure:"bookmark_file"`
+
+	// TruncateOnExit is a flag to determine whether the alert file is truncated when the listener stops.
+	TruncateOnExit bool `mapstructure:"truncate_on_exit"`
+
+	// SpoolDir is the directory used to spool batches on disk before they are sent.
+	// An empty value disables the spool.
+	SpoolDir string `mapstructure:"spool_dir"`
+
+	// SpoolRetryInterval is the interval between delivery attempts of spooled batches.
+	SpoolRetryInterval time.Duration `mapstructure:"spool_retry_interval"`
+
+	// SendRetryInitialBackoff is the wait after the first failure to send an event, it doubles after every failure.
+	SendRetryInitialBackoff time.Duration `mapstructure:"send_retry_initial_backoff"`
+
+	// SendRetryMaxBackoff is the longest wait between two attempts to send an event.
+	SendRetryMaxBackoff time.Duration `mapstructure:"send_retry_max_backoff"`
+
+	// SendRetryMaxAttempts is the number of failures allowed per batch before it is queued again.
+	// Zero retries until the client stops.
+	SendRetryMaxAttempts int `mapstructure:"send_retry_max_attempts"`
+
+	// ShutdownDrainTimeout is how long the client keeps sending queued events after it is asked to stop.
+	ShutdownDrainTimeout time.Duration `mapstructure:"shutdown_drain_timeout"`
+
+	// BatchIdleWindow is how long an event must go without new alerts before it is sent.
+	BatchIdleWindow time.Duration `mapstructure:"batch_idle_window"`
+
+	// BatchMaxAge is how long an event may aggregate alerts before it is sent. Zero disables the limit.
+	BatchMaxAge time.Duration `mapstructure:"batch_max_age"`
+
+	// BatchMaxMetrics is the maximum number of alerts aggregated into a single event. Zero disables the limit.
+	BatchMaxMetrics int `mapstructure:"batch_max_metrics"`
+
+	// BatchMaxSize is the maximum serialized size of a single event in bytes.
+	// Zero uses the maximum gRPC message size.
+	BatchMaxSize int `mapstructure:"batch_max_size"`
+
+	// QueueMaxBytes is the maximum serialized size of all events held by the client. Zero disables the limit.
+	QueueMaxBytes int `mapstructure:"queue_max_bytes"`
+
+	// QueueMaxEvents is the maximum number of alerts held by the client. Zero disables the limit.
+	QueueMaxEvents int `mapstructure:"queue_max_events"`
+
+	// QueueOverflowPolicy is what happens to new alerts when the queue is full:
+	// block, drop-oldest, drop-lowest-priority or spill.
+	QueueOverflowPolicy string `mapstructure:"queue_overflow_policy"`
+
+	// QueueSpillDir is the directory events are spilled to with the spill policy.
+	QueueSpillDir string `mapstructure:"queue_spill_dir"`
+
+	// HashFields are the event fields hashed to group alerts into one event, in order.
+	HashFields []string `mapstructure:"hash_fields"`
+
+	// HashExcludeFields are left out of HashFields, e.g. snort_seconds to group alerts regardless of their time.
+	HashExcludeFields []string `mapstructure:"hash_exclude_fields"`
+
+	// MetricsListen is the address the Prometheus metrics, /healthz and /readyz are served on,
+	// as host:port or unix:/path/to/socket. An empty value or "disabled" disables them.
+	MetricsListen string `mapstructure:"metrics_listen"`
+
+	// AlertMetricsTopK is the number of rules, priorities, classifications and actions exported with
+	// their own alert counter, the others are counted as other. Zero disables the alert counters.
+	AlertMetricsTopK int `mapstructure:"alert_metrics_top_k"`
+
+	// ReplaySpeed is the factor the spacing of replayed alerts is shortened by (used with the replay command).
+	ReplaySpeed float64 `mapstructure:"replay_speed"`
+
+	// ReplayFast sends replayed alerts as fast as possible, ignoring their timestamps.
+	ReplayFast bool `mapstructure:"replay_fast"`
+
+	// HealthcheckTargets are the sides checked by the healthcheck command, "client" and "server".
+	HealthcheckTargets []string `mapstructure:"healthcheck_targets"`
+
+	// HealthcheckTimeout bounds every check of the healthcheck command.
+	HealthcheckTimeout time.Duration `mapstructure:"healthcheck_timeout"`
 }
 
 type ServerConfig struct {
@@ -64,6 +204,26 @@ type ServerConfig struct {
 	// GRPCKeyFile is the key file for the gRPC server.
 	GRPCKeyFile string `mapstructure:"key"`
 
+	// GRPCClientCAFile is the CA certificate file client certificates are verified with.
+	GRPCClientCAFile string `mapstructure:"client_ca"`
+
+	// GRPCRequireClientCert rejects clients that do not present a certificate signed by GRPCClientCAFile.
+	GRPCRequireClientCert bool `mapstructure:"require_client_cert"`
+
+	// GRPCClientIdentity is the part of the client certificate that names the sensor, "cn" or "san".
+	GRPCClientIdentity string `mapstructure:"client_identity"`
+
+	// AuthSecretsFile lists the sensors allowed to connect with the hashes of their secrets.
+	// It is reloaded when it changes. An empty value disables token authentication.
+	AuthSecretsFile string `mapstructure:"auth_secrets_file"`
+
+	// MetricsListen is the address the Prometheus metrics are served on, as host:port or unix:/path/to/socket.
+	// An empty value or "disabled" disables them.
+	MetricsListen string `mapstructure:"metrics_listen"`
+
+	// GRPCReflection is a flag to determine whether the gRPC server reflection service is registered.
+	GRPCReflection bool `mapstructure:"reflection"`
+
 	// SchemaRegistryUrl is the schema registry URL.
 	SchemaRegistryUrl string `mapstructure:"schema_registry_url"`
 
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
@@ -17,10 +19,23 @@ var log = logger.GetLogger()
 
 type Producer struct {
 	p          *kafka.Producer
+	registry   schemaregistry.Client
 	serializer *protobuf.Serializer
 	topic      string
+
+	onSerialize SerializeObserver
+
+	produceErrors    atomic.Int64
+	deliveryFailures atomic.Int64
 }
 
+// DeliveryCallback is called once the delivery report of a produced message is received.
+// err is nil when the message was successfully written to Kafka.
+type DeliveryCallback func(err error)
+
+// SerializeObserver is called with the time it took to serialize a message.
+type SerializeObserver func(d time.Duration)
+
 // ProducerTLSConfig holds TLS-related configuration for the Kafka producer.
 type ProducerTLSConfig struct {
 	SecurityProtocol       string
@@ -77,17 +92,26 @@ func NewKafkaProducer(brokers, schemaRegistryUrl, topic string, tls ProducerTLSC
 		return nil, fmt.Errorf("failed to create kafka producer: %w", err)
 	}
 
+	k := &Producer{
+		p:     p,
+		topic: topic,
+	}
+
 	// Start a go routine to handle delivery reports
 	go func() {
 		for e := range p.Events() {
 			switch ev := e.(type) {
 			case *kafka.Message:
 				if ev.TopicPartition.Error != nil {
+					k.deliveryFailures.Add(1)
 					log.Errorf("Failed to deliver message %s: %v\n", ev.Key, ev.TopicPartition.Error)
 				} else {
 					log.Tracef("Delivered message to topic %s [%d] at offset %v\n",
 						*ev.TopicPartition.Topic, ev.TopicPartition.Partition, ev.TopicPartition.Offset)
 				}
+				if onDelivery, ok := ev.Opaque.(DeliveryCallback); ok {
+					onDelivery(ev.TopicPartition.Error)
+				}
 			case kafka.Error:
 				log.Errorf("Kafka error: %v\n", ev)
 			default:
@@ -101,23 +125,19 @@ func NewKafkaProducer(brokers, schemaRegistryUrl, topic string, tls ProducerTLSC
 		registryConfig.SslCaLocation = tls.PathToCA
 	}
 
-	client, err := schemaregistry.NewClient(registryConfig)
+	k.registry, err = schemaregistry.NewClient(registryConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create schema registry client: %w", err)
 	}
 
-	serializer, err := protobuf.NewSerializer(client, serde.ValueSerde, protobuf.NewSerializerConfig())
+	k.serializer, err = protobuf.NewSerializer(k.registry, serde.ValueSerde, protobuf.NewSerializerConfig())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create serializer: %w", err)
 	}
 
 	log.Infof("Created Kafka producer with brokers: %s, schema registry URL: %s, and topic: %s", brokers, schemaRegistryUrl, topic)
 
-	return &Producer{
-		p:          p,
-		serializer: serializer,
-		topic:      topic,
-	}, nil
+	return k, nil
 }
 
 // validateAndDetermineProtocol validates the provided TLS assets and returns the
@@ -193,16 +213,39 @@ func (k *Producer) Serialize(value *pb.SensorEvent) ([]byte, error) {
 	return k.serializer.Serialize(k.topic, value)
 }
 
+// SetSerializeObserver sets the function called with the serialization time of every message.
+// It must be called before the first message is produced.
+func (k *Producer) SetSerializeObserver(o SerializeObserver) {
+	k.onSerialize = o
+}
+
+// message serializes the event into a Kafka message.
+func (k *Producer) message(value *pb.SensorEvent) (*kafka.Message, error) {
+	started := time.Now()
+	msg, err := createKafkaMessages(k.serializer, k.topic, value)
+	if err != nil {
+		k.produceErrors.Add(1)
+		return nil, err
+	}
+
+	if k.onSerialize != nil {
+		k.onSerialize(time.Since(started))
+	}
+
+	return msg, nil
+}
+
 func (k *Producer) Produce(value *pb.SensorEvent) error {
 	log.Tracef("Producing message: %v\n", value.EventHashSha256)
 
 	// Serialize message
-	payload, err := createKafkaMessages(k.serializer, k.topic, value)
+	payload, err := k.message(value)
 	if err != nil {
 		return err
 	}
 
 	if err := k.p.Produce(payload, nil); err != nil {
+		k.produceErrors.Add(1)
 		log.Errorf("Failed to produce message with size %d: %v\n", value.EventMetricsCount, err)
 		return err
 	}
@@ -211,6 +254,60 @@ func (k *Producer) Produce(value *pb.SensorEvent) error {
 	return nil
 }
 
+// ProduceWithAck produces the message and calls onDelivery with the result of its delivery report.
+// onDelivery is not called when an error is returned.
+func (k *Producer) ProduceWithAck(value *pb.SensorEvent, onDelivery DeliveryCallback) error {
+	log.Tracef("Producing message: %v\n", value.EventHashSha256)
+
+	payload, err := k.message(value)
+	if err != nil {
+		return err
+	}
+	payload.Opaque = onDelivery
+
+	if err := k.p.Produce(payload, nil); err != nil {
+		k.produceErrors.Add(1)
+		log.Errorf("Failed to produce message with size %d: %v\n", value.EventMetricsCount, err)
+		return err
+	}
+
+	log.Tracef("Produced message: %v\n", value.EventHashSha256)
+	return nil
+}
+
+// GetProduceErrors returns the number of messages that could not be serialized or queued since the last call.
+func (k *Producer) GetProduceErrors() int64 {
+	return k.produceErrors.Swap(0)
+}
+
+// GetDeliveryFailures returns the number of messages Kafka failed to deliver since the last call.
+func (k *Producer) GetDeliveryFailures() int64 {
+	return k.deliveryFailures.Swap(0)
+}
+
+// Len returns the number of messages and requests waiting in the librdkafka queue.
+func (k *Producer) Len() int {
+	return k.p.Len()
+}
+
+// CheckBrokers asks the brokers for their metadata to check that Kafka can be reached.
+func (k *Producer) CheckBrokers(timeout time.Duration) error {
+	if _, err := k.p.GetMetadata(nil, false, int(timeout.Milliseconds())); err != nil {
+		return fmt.Errorf("kafka brokers are unreachable: %w", err)
+	}
+
+	return nil
+}
+
+// CheckSchemaRegistry lists the subjects of the schema registry to check that it can be reached.
+func (k *Producer) CheckSchemaRegistry() error {
+	if _, err := k.registry.GetAllSubjects(); err != nil {
+		return fmt.Errorf("schema registry is unreachable: %w", err)
+	}
+
+	return nil
+}
+
 func (k *Producer) Flush(timeoutMs int) int {
 	return k.p.Flush(timeoutMs)
 }
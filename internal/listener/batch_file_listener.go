@@ -0,0 +1,389 @@
+package listener
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/util"
+)
+
+// errBatchStopped is returned while reading a file when the listener is stopped.
+var errBatchStopped = errors.New("batch file listener stopped")
+
+// IsCompressedFile reports whether the alert file is compressed and therefore cannot be followed.
+func IsCompressedFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".zst":
+		return true
+	}
+	return false
+}
+
+// openAlertFile opens an alert file, decompressing .gz and .zst files transparently.
+func openAlertFile(name string) (io.ReadCloser, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz":
+		r, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &decompressedFile{Reader: r, closers: []func() error{r.Close, file.Close}}, nil
+	case ".zst":
+		r, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &decompressedFile{Reader: r, closers: []func() error{func() error { r.Close(); return nil }, file.Close}}, nil
+	}
+
+	return file, nil
+}
+
+type decompressedFile struct {
+	io.Reader
+	closers []func() error
+}
+
+func (d *decompressedFile) Close() error {
+	var errs []error
+	for _, c := range d.closers {
+		errs = append(errs, c())
+	}
+	return errors.Join(errs...)
+}
+
+// BatchFileSummary counts what a BatchFileListener has read.
+type BatchFileSummary struct {
+	Files       int
+	FailedFiles int
+	Lines       int64
+	Events      int64
+	Duration    time.Duration
+
+	// FirstSeconds and LastSeconds are the earliest and latest alert times read.
+	FirstSeconds int64
+	LastSeconds  int64
+}
+
+// replayPacer delays alerts so they are sent with the same spacing as they were raised, scaled by speed.
+type replayPacer struct {
+	speed     float64
+	first     int64
+	startedAt time.Time
+}
+
+// wait blocks until the alert raised at seconds is due. Alerts older than the ones before them are not delayed.
+func (r *replayPacer) wait(ctx context.Context, stop <-chan struct{}, seconds int64) error {
+	if r.speed <= 0 || seconds == 0 {
+		return nil
+	}
+	if r.startedAt.IsZero() {
+		r.first = seconds
+		r.startedAt = time.Now()
+		return nil
+	}
+
+	offset := time.Duration(float64(seconds-r.first) * float64(time.Second) / r.speed)
+	delay := time.Until(r.startedAt.Add(offset))
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return errBatchStopped
+	case <-stop:
+		return errBatchStopped
+	case <-timer.C:
+		return nil
+	}
+}
+
+// BatchFileListener reads alert files, plain or compressed, to the end once instead of
+// following them, e.g. to backfill rotated logs. Start returns when every file has been read.
+type BatchFileListener struct {
+	inputState
+
+	pattern string
+	parser  parser.Parser
+	pacer   *replayPacer
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	linesPerSec  atomic.Int64
+	linesThisSec atomic.Int64
+
+	mu      sync.Mutex
+	summary BatchFileSummary
+}
+
+// NewBatchFileListener creates a listener for a single file, a glob pattern or a directory.
+func NewBatchFileListener(pattern string, p parser.Parser) (*BatchFileListener, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	return &BatchFileListener{
+		pattern: pattern,
+		parser:  p,
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// NewReplayListener creates a BatchFileListener that sends the alerts with the spacing of their
+// original timestamps, speed times faster. A speed of 0 or less sends them as fast as possible.
+func NewReplayListener(pattern string, speed float64, p parser.Parser) (*BatchFileListener, error) {
+	b, err := NewBatchFileListener(pattern, p)
+	if err != nil {
+		return nil, err
+	}
+	b.pacer = &replayPacer{speed: speed}
+
+	return b, nil
+}
+
+func (b *BatchFileListener) Start(ctx context.Context, q *queue.EventBatchQueue) error {
+	files, err := b.files()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no alert files match %q", b.pattern)
+	}
+
+	b.setOpen(true)
+	defer b.setOpen(false)
+
+	ticker := time.NewTicker(time.Second)
+	tickerStop := make(chan struct{})
+	defer func() {
+		ticker.Stop()
+		close(tickerStop)
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-tickerStop:
+				return
+			case <-ticker.C:
+				util.UpdateAndReset(&b.linesPerSec, &b.linesThisSec)
+			}
+		}
+	}()
+
+	started := time.Now()
+	defer func() {
+		b.mu.Lock()
+		b.summary.Duration = time.Since(started)
+		summary := b.summary
+		b.mu.Unlock()
+
+		log.WithFields(logger.Fields{
+			"package":      "batch_file_listener",
+			"files":        summary.Files,
+			"failed_files": summary.FailedFiles,
+			"lines":        summary.Lines,
+			"events":       summary.Events,
+			"duration":     summary.Duration.Round(time.Millisecond).String(),
+		}).Infoln("Finished reading alert files")
+	}()
+
+	for _, name := range files {
+		lines, events, err := b.readFile(ctx, name, q)
+
+		b.mu.Lock()
+		b.summary.Files++
+		b.summary.Lines += lines
+		b.summary.Events += events
+		if err != nil && !errors.Is(err, errBatchStopped) {
+			b.summary.FailedFiles++
+		}
+		b.mu.Unlock()
+
+		if errors.Is(err, errBatchStopped) {
+			log.WithField("package", "batch_file_listener").Infoln("Listener is stopped, skipping the remaining files.")
+			return nil
+		}
+		if err != nil {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "batch_file_listener",
+				"file":    name,
+			}).Errorln("failed to read alert file")
+			continue
+		}
+
+		log.WithFields(logger.Fields{
+			"package": "batch_file_listener",
+			"file":    name,
+			"lines":   lines,
+			"events":  events,
+		}).Infoln("Read alert file")
+	}
+
+	return nil
+}
+
+// readFile sends every alert in the file to the queue.
+func (b *BatchFileListener) readFile(ctx context.Context, name string, q *queue.EventBatchQueue) (int64, int64, error) {
+	r, err := openAlertFile(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+
+	// Multi-line formats must not carry a partial record over to the next file.
+	p := parser.ForStream(b.parser)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), DefaultMaxRecordSize)
+
+	var lines, events int64
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return lines, events, errBatchStopped
+		case <-b.stop:
+			return lines, events, errBatchStopped
+		default:
+		}
+
+		lines++
+		ok, err := b.handleRecord(ctx, p, scanner.Bytes(), q)
+		if err != nil {
+			return lines, events, err
+		}
+		if ok {
+			events++
+		}
+	}
+
+	// alert_full records are completed by an empty line, which may be missing at the end of the file.
+	if _, stateful := p.(parser.Stateful); stateful {
+		ok, err := b.handleRecord(ctx, p, nil, q)
+		if err != nil {
+			return lines, events, err
+		}
+		if ok {
+			events++
+		}
+	}
+
+	return lines, events, scanner.Err()
+}
+
+// handleRecord parses a record and, once it is due, sends it to the queue.
+// It returns false when the record was skipped.
+func (b *BatchFileListener) handleRecord(ctx context.Context, p parser.Parser, record []byte, q *queue.EventBatchQueue) (bool, error) {
+	payload := parseRecord(p, record, "batch_file_listener")
+	if payload == nil {
+		return false, nil
+	}
+
+	if b.pacer != nil {
+		if err := b.pacer.wait(ctx, b.stop, payload.Seconds); err != nil {
+			return false, err
+		}
+	}
+
+	seconds := payload.Seconds
+	if !enqueueAlert(payload, q, "batch_file_listener") {
+		return false, nil
+	}
+	b.linesThisSec.Add(1)
+
+	if seconds != 0 {
+		b.mu.Lock()
+		if b.summary.FirstSeconds == 0 || seconds < b.summary.FirstSeconds {
+			b.summary.FirstSeconds = seconds
+		}
+		if seconds > b.summary.LastSeconds {
+			b.summary.LastSeconds = seconds
+		}
+		b.mu.Unlock()
+	}
+
+	return true, nil
+}
+
+// files returns the files to read, oldest first so rotated logs are read in order.
+func (b *BatchFileListener) files() ([]string, error) {
+	pattern := b.pattern
+	if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+		pattern = filepath.Join(pattern, "*")
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	modTimes := make(map[string]time.Time, len(matches))
+	files := make([]string, 0, len(matches))
+	for _, name := range matches {
+		fi, err := os.Stat(name)
+		if err != nil || !fi.Mode().IsRegular() || strings.HasPrefix(filepath.Base(name), ".") {
+			continue
+		}
+		modTimes[name] = fi.ModTime()
+		files = append(files, name)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if !modTimes[files[i]].Equal(modTimes[files[j]]) {
+			return modTimes[files[i]].Before(modTimes[files[j]])
+		}
+		return files[i] < files[j]
+	})
+
+	return files, nil
+}
+
+// GetSummary returns what has been read so far.
+func (b *BatchFileListener) GetSummary() BatchFileSummary {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.summary
+}
+
+// GetEventReadPerSecond returns the number of events read per second.
+func (b *BatchFileListener) GetEventReadPerSecond() int64 {
+	return b.linesPerSec.Load()
+}
+
+// Stop stops reading after the current line.
+func (b *BatchFileListener) Stop() error {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+	return nil
+}
@@ -0,0 +1,126 @@
+package listener
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+)
+
+func Test_BatchFileListenerReadsCompressedFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	alerts := func(sids ...int) []byte {
+		var buf bytes.Buffer
+		for _, sid := range sids {
+			buf.WriteString(snortJSONLine(sid))
+		}
+		return buf.Bytes()
+	}
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	if _, err := gw.Write(alerts(2000, 2001)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := zw.EncodeAll(alerts(3000, 3001, 3002), nil)
+	_ = zw.Close()
+
+	files := map[string][]byte{
+		"alert_json.txt":       alerts(1000),
+		"alert_json.txt.1.gz":  gz.Bytes(),
+		"alert_json.txt.2.zst": zst,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := NewBatchFileListener(dir, &parser.SnortJSONParser{})
+	if err != nil {
+		t.Fatalf("NewBatchFileListener() error = %v", err)
+	}
+
+	q := queue.NewEventBatchQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := b.Start(ctx, q); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if got := q.GetEventQueueSize(); got != 6 {
+		t.Errorf("Expected 6 events from all alert files, got %d", got)
+	}
+
+	summary := b.GetSummary()
+	if summary.Files != 3 || summary.FailedFiles != 0 || summary.Lines != 6 || summary.Events != 6 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+}
+
+func Test_ReplayListenerPacing(t *testing.T) {
+	// Alerts one and two seconds after the first one.
+	lines := ""
+	for i, seconds := range []int64{1728513131, 1728513132, 1728513133} {
+		lines += strings.Replace(snortJSONLine(1000+i), `"seconds":1728513131`, fmt.Sprintf(`"seconds":%d`, seconds), 1)
+	}
+	name := filepath.Join(t.TempDir(), "alert_json.txt")
+	if err := os.WriteFile(name, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		speed      float64
+		minElapsed time.Duration
+		maxElapsed time.Duration
+	}{
+		{name: "Must keep the spacing scaled by speed", speed: 10, minElapsed: 200 * time.Millisecond, maxElapsed: 2 * time.Second},
+		{name: "Must not wait as fast as possible", speed: 0, minElapsed: 0, maxElapsed: 150 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReplayListener(name, tt.speed, &parser.SnortJSONParser{})
+			if err != nil {
+				t.Fatalf("NewReplayListener() error = %v", err)
+			}
+
+			q := queue.NewEventBatchQueue()
+			started := time.Now()
+			if err := r.Start(context.Background(), q); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			elapsed := time.Since(started)
+
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Errorf("Expected the replay to take between %s and %s, took %s", tt.minElapsed, tt.maxElapsed, elapsed)
+			}
+
+			summary := r.GetSummary()
+			if summary.Events != 3 || summary.FirstSeconds != 1728513131 || summary.LastSeconds != 1728513133 {
+				t.Errorf("Unexpected summary %+v", summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package listener
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// maxFirstLineSize is the maximum number of bytes read to fingerprint a file.
+const maxFirstLineSize = 64 * 1024
+
+// maxPrefixSize is the number of leading bytes read to fingerprint a binary file.
+const maxPrefixSize = 64
+
+// Bookmark records how far a file has been read, so reading can resume after a restart.
+// The inode and the checksum of the first line identify the file, so a rotated
+// or rewritten file is read from the beginning instead of from a stale offset.
+// Binary files have no lines, they are identified by the checksum of their first PrefixLength bytes,
+// which do not change while the file grows.
+type Bookmark struct {
+	File              string `json:"file,omitempty"`
+	Inode             uint64 `json:"inode"`
+	Offset            int64  `json:"offset"`
+	FirstLineChecksum string `json:"first_line_sha256"`
+	PrefixLength      int64  `json:"prefix_length,omitempty"`
+	PrefixChecksum    string `json:"prefix_sha256,omitempty"`
+}
+
+// LoadBookmark reads the bookmark file. It returns nil without an error if the file does not exist.
+func LoadBookmark(path string) (*Bookmark, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var b Bookmark
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse bookmark file: %w", err)
+	}
+
+	return &b, nil
+}
+
+// Save atomically writes the bookmark file.
+func (b *Bookmark) Save(path string) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// NewBookmark fingerprints the file and records the given offset.
+func NewBookmark(filename string, offset int64) (*Bookmark, error) {
+	inode, checksum, _, err := fileIdentity(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bookmark{
+		File:              filename,
+		Inode:             inode,
+		Offset:            offset,
+		FirstLineChecksum: checksum,
+	}, nil
+}
+
+// NewBinaryBookmark fingerprints a binary file by its first bytes and records the given offset.
+func NewBinaryBookmark(filename string, offset int64) (*Bookmark, error) {
+	inode, checksum, length, _, err := prefixIdentity(filename, maxPrefixSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bookmark{
+		File:           filename,
+		Inode:          inode,
+		Offset:         offset,
+		PrefixLength:   length,
+		PrefixChecksum: checksum,
+	}, nil
+}
+
+// ResumeOffset returns the offset to continue reading the file from.
+// It returns 0 with a reason when the bookmark does not belong to the file.
+func (b *Bookmark) ResumeOffset(filename string) (int64, string) {
+	if b.PrefixChecksum != "" {
+		return b.resumeBinaryOffset(filename)
+	}
+
+	inode, checksum, size, err := fileIdentity(filename)
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	switch {
+	case b.Inode != inode:
+		return 0, "inode changed"
+	case b.FirstLineChecksum != checksum:
+		return 0, "first line changed"
+	case b.Offset > size:
+		return 0, "file is smaller than the bookmark offset"
+	}
+
+	return b.Offset, ""
+}
+
+func (b *Bookmark) resumeBinaryOffset(filename string) (int64, string) {
+	inode, checksum, length, size, err := prefixIdentity(filename, b.PrefixLength)
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	switch {
+	case b.Inode != inode:
+		return 0, "inode changed"
+	case length != b.PrefixLength || b.PrefixChecksum != checksum:
+		return 0, "file header changed"
+	case b.Offset > size:
+		return 0, "file is smaller than the bookmark offset"
+	}
+
+	return b.Offset, ""
+}
+
+// fileIdentity returns the inode, the checksum of the first complete line and the size of the file.
+func fileIdentity(filename string) (uint64, string, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	line, err := bufio.NewReaderSize(io.LimitReader(file, maxFirstLineSize), maxFirstLineSize).ReadBytes('\n')
+	checksum := ""
+	if err == nil {
+		sum := sha256.Sum256(line)
+		checksum = hex.EncodeToString(sum[:])
+	}
+
+	return fileInode(fi), checksum, fi.Size(), nil
+}
+
+// prefixIdentity returns the inode, the checksum and the length of the first bytes of the file,
+// at most n, and the size of the file.
+func prefixIdentity(filename string, n int64) (uint64, string, int64, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, "", 0, 0, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return 0, "", 0, 0, err
+	}
+
+	h := sha256.New()
+	length, err := io.Copy(h, io.LimitReader(file, n))
+	if err != nil {
+		return 0, "", 0, 0, err
+	}
+
+	return fileInode(fi), hex.EncodeToString(h.Sum(nil)), length, fi.Size(), nil
+}
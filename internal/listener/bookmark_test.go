@@ -0,0 +1,105 @@
+package listener
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_BookmarkResume(t *testing.T) {
+	dir := t.TempDir()
+	alertFile := filepath.Join(dir, "alert_json.txt")
+	bookmarkFile := filepath.Join(dir, "alert_json.bookmark")
+
+	content := "{\"sid\":1}\n{\"sid\":2}\n"
+	if err := os.WriteFile(alertFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bookmark, err := NewBookmark(alertFile, 10)
+	if err != nil {
+		t.Fatalf("NewBookmark() error = %v", err)
+	}
+	if err := bookmark.Save(bookmarkFile); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := LoadBookmark(bookmarkFile)
+	if err != nil {
+		t.Fatalf("LoadBookmark() error = %v", err)
+	}
+	if *loaded != *bookmark {
+		t.Errorf("LoadBookmark() = %+v, want %+v", loaded, bookmark)
+	}
+
+	if offset, reason := loaded.ResumeOffset(alertFile); offset != 10 || reason != "" {
+		t.Errorf("ResumeOffset() = %d (%s), want 10", offset, reason)
+	}
+
+	// Replacing the first line means the bookmark belongs to another file.
+	if err := os.WriteFile(alertFile, []byte("{\"sid\":3}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if offset, reason := loaded.ResumeOffset(alertFile); offset != 0 || reason == "" {
+		t.Errorf("ResumeOffset() = %d, want 0 with a reason", offset)
+	}
+}
+
+func Test_LoadMissingBookmark(t *testing.T) {
+	bookmark, err := LoadBookmark(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || bookmark != nil {
+		t.Errorf("LoadBookmark() = %v, %v, want nil, nil", bookmark, err)
+	}
+}
+
+func Test_BookmarkTruncatedFile(t *testing.T) {
+	alertFile := filepath.Join(t.TempDir(), "alert_json.txt")
+	if err := os.WriteFile(alertFile, []byte("{\"sid\":1}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bookmark, err := NewBookmark(alertFile, 1024)
+	if err != nil {
+		t.Fatalf("NewBookmark() error = %v", err)
+	}
+
+	if offset, reason := bookmark.ResumeOffset(alertFile); offset != 0 || reason == "" {
+		t.Errorf("ResumeOffset() = %d, want 0 with a reason", offset)
+	}
+}
+
+func Test_BinaryBookmarkGrowingFile(t *testing.T) {
+	spoolFile := filepath.Join(t.TempDir(), "snort.u2.1700000000")
+
+	// The first line of a binary file keeps changing while it grows, until a 0x0A byte is written.
+	header := []byte{0, 0, 0, 7, 0, 0, 0, 3, 1, 2, 3}
+	if err := os.WriteFile(spoolFile, header, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bookmark, err := NewBinaryBookmark(spoolFile, int64(len(header)))
+	if err != nil {
+		t.Fatalf("NewBinaryBookmark() error = %v", err)
+	}
+
+	file, err := os.OpenFile(spoolFile, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte{0, 0, 0, 2, 0x0a, 0, 0, 0, 4, 5, 6, 7}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if offset, reason := bookmark.ResumeOffset(spoolFile); offset != int64(len(header)) || reason != "" {
+		t.Errorf("ResumeOffset() = %d (%s), want %d", offset, reason, len(header))
+	}
+
+	// Rewriting the header means the bookmark belongs to another file.
+	if err := os.WriteFile(spoolFile, []byte{0, 0, 0, 2, 0, 0, 0, 3, 1, 2, 3, 4}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if offset, reason := bookmark.ResumeOffset(spoolFile); offset != 0 || reason == "" {
+		t.Errorf("ResumeOffset() = %d, want 0 with a reason", offset)
+	}
+}
@@ -0,0 +1,337 @@
+package listener
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+)
+
+// filePollInterval is how often a glob pattern or directory is checked for new alert files.
+const filePollInterval = time.Second
+
+// rotatedSuffix matches the suffix logrotate adds to a rotated file, e.g. .1 or -20240101.
+// A file with such a suffix is only taken as rotated when the file it was rotated from is known.
+var rotatedSuffix = regexp.MustCompile(`[.-][0-9]+$`)
+
+// IsFilePattern reports whether path is a glob pattern or a directory rather than a single alert file.
+func IsFilePattern(path string) bool {
+	if strings.ContainsAny(path, "*?[") {
+		return true
+	}
+
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// GlobFileListener tails every alert file matching a glob pattern, or every file in a
+// directory, with its own FileListener. Files that appear later are picked up as well,
+// except rotated copies of tailed files, and files that are deleted are no longer tailed.
+type GlobFileListener struct {
+	inputState
+
+	pattern      string
+	bookmarkPath string
+	follow       bool
+	parser       parser.Parser
+
+	mu      sync.Mutex
+	files   map[string]*FileListener
+	inodes  map[string]uint64
+	missing map[string]bool
+	failed  map[string]bool
+	wg      sync.WaitGroup
+
+	// tailed are the files tailed since the start, their rotated copies are not read again.
+	tailed map[string]bool
+
+	// rotated are the matching files skipped as rotated copies, so that they are only logged once.
+	rotated map[string]bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewGlobFileListener creates a listener for the files matching pattern. A directory matches the files in it.
+func NewGlobFileListener(pattern string, p parser.Parser) (*GlobFileListener, error) {
+	conf := config.GetConfig()
+
+	if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+		pattern = filepath.Join(pattern, "*")
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	return &GlobFileListener{
+		pattern:      pattern,
+		bookmarkPath: conf.ClientConfig.BookmarkFile,
+		follow:       !conf.ClientConfig.TestingMode,
+		parser:       p,
+		files:        make(map[string]*FileListener),
+		inodes:       make(map[string]uint64),
+		missing:      make(map[string]bool),
+		failed:       make(map[string]bool),
+		tailed:       make(map[string]bool),
+		rotated:      make(map[string]bool),
+		stop:         make(chan struct{}),
+	}, nil
+}
+
+func (g *GlobFileListener) Start(ctx context.Context, q *queue.EventBatchQueue) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	defer func() {
+		cancel()
+		g.stopFiles()
+		g.wg.Wait()
+
+		log.WithField("package", "file_listener").Infoln("Shutting down GlobFileListener process.")
+	}()
+
+	log.WithFields(logger.Fields{
+		"package": "file_listener",
+		"pattern": g.pattern,
+	}).Infoln("Watching for alert files")
+
+	g.setOpen(true)
+	defer g.setOpen(false)
+
+	for {
+		g.discover(ctx, q)
+
+		// Without follow mode the files found at start are read once.
+		if !g.follow {
+			g.wg.Wait()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-g.stop:
+			return nil
+		case <-time.After(filePollInterval):
+		}
+	}
+}
+
+// discover starts a FileListener for every matching file that is not tailed yet,
+// and stops the ones of files that have been gone for a full poll interval.
+func (g *GlobFileListener) discover(ctx context.Context, q *queue.EventBatchQueue) {
+	matches, err := filepath.Glob(g.pattern)
+	if err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "file_listener",
+		}).Errorln("failed to match alert files")
+		return
+	}
+	sort.Strings(matches)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.prune(matches)
+
+	for _, name := range matches {
+		if _, ok := g.files[name]; ok || g.skip(name) {
+			continue
+		}
+		if g.isRotated(name, matches) {
+			if !g.rotated[name] {
+				log.WithFields(logger.Fields{
+					"package": "file_listener",
+					"file":    name,
+				}).Debugln("Skipping rotated alert file")
+			}
+			g.rotated[name] = true
+			continue
+		}
+		delete(g.rotated, name)
+
+		f, err := newFileListener(name, g.fileBookmarkPath(name), parser.ForStream(g.parser))
+		if err != nil {
+			if !g.failed[name] {
+				log.WithFields(logger.Fields{
+					"error":   err,
+					"package": "file_listener",
+					"file":    name,
+				}).Warnln("failed to tail alert file, retrying")
+			}
+			g.failed[name] = true
+			continue
+		}
+		delete(g.failed, name)
+		g.files[name] = f
+		g.tailed[name] = true
+		if fi, err := os.Stat(name); err == nil {
+			g.inodes[name] = fileInode(fi)
+		}
+
+		log.WithFields(logger.Fields{
+			"package": "file_listener",
+			"file":    name,
+		}).Infoln("Tailing alert file")
+
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			if err := f.Start(ctx, q); err != nil {
+				log.WithFields(logger.Fields{
+					"error":   err,
+					"package": "file_listener",
+					"file":    name,
+				}).Errorln("failed to read alert file")
+			}
+		}()
+	}
+}
+
+// skip reports whether a matching path is not an alert file that can be tailed, such as a directory,
+// our own bookmark or a compressed rotated log, which is read with --no-follow instead.
+func (g *GlobFileListener) skip(name string) bool {
+	if strings.HasPrefix(filepath.Base(name), ".") || IsCompressedFile(name) {
+		return true
+	}
+	if g.bookmarkPath != "" && strings.HasPrefix(name, g.bookmarkPath) {
+		return true
+	}
+
+	fi, err := os.Stat(name)
+	return err != nil || !fi.Mode().IsRegular()
+}
+
+// isRotated reports whether a matching path is a rotated alert file, such as alert_json.txt.1 from
+// logrotate next to alert_json.txt, or a tailed file renamed under another name.
+// Its lines have been read while it was the current file. A file with a rotation suffix whose
+// original is neither matching nor tailed, e.g. snort.1700000000, is a live file.
+func (g *GlobFileListener) isRotated(name string, matches []string) bool {
+	if rotatedSuffix.MatchString(filepath.Base(name)) {
+		original := rotatedSuffix.ReplaceAllString(name, "")
+		if g.tailed[original] || slices.Contains(matches, original) {
+			return true
+		}
+	}
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	inode := fileInode(fi)
+	for tailed, tailedInode := range g.inodes {
+		if tailed != name && inode != 0 && inode == tailedInode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prune stops tailing the files that no longer match, once they are missing on two polls in a row,
+// so that a file logrotate is about to recreate keeps its listener. It must be called with g.mu held.
+func (g *GlobFileListener) prune(matches []string) {
+	for name, f := range g.files {
+		if slices.Contains(matches, name) {
+			delete(g.missing, name)
+			continue
+		}
+		if !g.missing[name] {
+			g.missing[name] = true
+			continue
+		}
+
+		log.WithFields(logger.Fields{
+			"package": "file_listener",
+			"file":    name,
+		}).Infoln("Alert file is gone, no longer tailing it")
+
+		_ = f.Stop()
+		delete(g.files, name)
+		delete(g.inodes, name)
+		delete(g.missing, name)
+	}
+
+	for name := range g.failed {
+		if !slices.Contains(matches, name) {
+			delete(g.failed, name)
+		}
+	}
+	for name := range g.rotated {
+		if !slices.Contains(matches, name) {
+			delete(g.rotated, name)
+		}
+	}
+}
+
+// fileBookmarkPath returns the bookmark file of a single alert file, derived from the configured bookmark file.
+func (g *GlobFileListener) fileBookmarkPath(name string) string {
+	if g.bookmarkPath == "" {
+		return ""
+	}
+
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = name
+	}
+	sum := sha256.Sum256([]byte(abs))
+
+	return g.bookmarkPath + "." + hex.EncodeToString(sum[:8])
+}
+
+func (g *GlobFileListener) stopFiles() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, f := range g.files {
+		_ = f.Stop()
+	}
+}
+
+// GetEventReadPerSecond returns the number of events read per second from all files.
+func (g *GlobFileListener) GetEventReadPerSecond() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var total int64
+	for _, f := range g.files {
+		total += f.GetEventReadPerSecond()
+	}
+
+	return total
+}
+
+// GetEventReadPerSecondByFile returns the number of events read per second from every file.
+func (g *GlobFileListener) GetEventReadPerSecondByFile() map[string]int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	rates := make(map[string]int64, len(g.files))
+	for name, f := range g.files {
+		rates[name] = f.GetEventReadPerSecond()
+	}
+
+	return rates
+}
+
+// Stop stops the listener and all the files it tails.
+func (g *GlobFileListener) Stop() error {
+	g.stopOnce.Do(func() {
+		close(g.stop)
+	})
+	g.stopFiles()
+
+	return nil
+}
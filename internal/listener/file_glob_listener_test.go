@@ -0,0 +1,166 @@
+package listener
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+)
+
+func Test_GlobFileListenerPicksUpNewFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	writeAlert := func(iface string, sid int) string {
+		name := filepath.Join(dir, iface, "alert_json.txt")
+		if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(snortJSONLine(sid)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	eth0 := writeAlert("eth0", 1000)
+	writeAlert("eth1", 2000)
+
+	pattern := filepath.Join(dir, "*", "alert_json.txt")
+	if !IsFilePattern(pattern) || !IsFilePattern(dir) || IsFilePattern(eth0) {
+		t.Fatal("IsFilePattern() must only match globs and directories")
+	}
+
+	g, err := NewGlobFileListener(pattern, &parser.SnortJSONParser{})
+	if err != nil {
+		t.Fatalf("NewGlobFileListener() error = %v", err)
+	}
+
+	q := queue.NewEventBatchQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Start(ctx, q)
+	}()
+
+	for q.GetEventQueueSize() < 2 && ctx.Err() == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// A Snort instance started later must be picked up without a restart.
+	eth2 := writeAlert("eth2", 3000)
+
+	for q.GetEventQueueSize() < 3 && ctx.Err() == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	rates := g.GetEventReadPerSecondByFile()
+
+	_ = g.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if got := q.GetEventQueueSize(); got != 3 {
+		t.Fatalf("Expected 3 events from all alert files, got %d", got)
+	}
+	if _, ok := rates[eth2]; !ok || len(rates) != 3 {
+		t.Errorf("Expected read rates for all 3 files, got %v", rates)
+	}
+}
+
+func Test_GlobFileListenerSkipsRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	current := filepath.Join(dir, "alert_json.txt")
+	if err := os.WriteFile(current, []byte(snortJSONLine(1000)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(current+".1", []byte(snortJSONLine(2000)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGlobFileListener(dir, &parser.SnortJSONParser{})
+	if err != nil {
+		t.Fatalf("NewGlobFileListener() error = %v", err)
+	}
+
+	q := queue.NewEventBatchQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Start(ctx, q)
+	}()
+
+	for q.GetEventQueueSize() < 1 && ctx.Err() == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// logrotate renames the tailed file, the renamed file must not be read again.
+	if err := os.Rename(current, current+".2"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2*filePollInterval + 500*time.Millisecond)
+
+	if got := q.GetEventQueueSize(); got != 1 {
+		t.Errorf("Expected only the alert of the current file, got %d events", got)
+	}
+
+	// The file is gone for more than a poll interval, it is no longer tailed.
+	for len(g.GetEventReadPerSecondByFile()) > 0 && ctx.Err() == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	_ = g.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if ctx.Err() != nil {
+		t.Errorf("Expected the deleted file to be pruned, got %v", g.GetEventReadPerSecondByFile())
+	}
+}
+
+func Test_GlobFileListenerReadsLiveFilesWithNumericSuffix(t *testing.T) {
+	dir := t.TempDir()
+
+	// Neither file has an original it could have been rotated from.
+	for i, name := range []string{"snort.1700000000", "alert-2"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(snortJSONLine(1000+i)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := NewGlobFileListener(dir, &parser.SnortJSONParser{})
+	if err != nil {
+		t.Fatalf("NewGlobFileListener() error = %v", err)
+	}
+
+	q := queue.NewEventBatchQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Start(ctx, q)
+	}()
+
+	for q.GetEventQueueSize() < 2 && ctx.Err() == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	_ = g.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if got := q.GetEventQueueSize(); got != 2 {
+		t.Errorf("Expected the alerts of both files, got %d events", got)
+	}
+}
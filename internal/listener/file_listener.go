@@ -2,39 +2,123 @@ package listener
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"io"
 	"os"
 	"sync/atomic"
 	"time"
 
 	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
-	"github.com/mata-elang-stable/sensor-snort-service/internal/processor"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
 
 	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
-	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/util"
 	"github.com/nxadm/tail"
 )
 
 type FileListener struct {
-	filename     string
-	tail         *tail.Tail
-	linesPerSec  atomic.Int64
-	linesThisSec atomic.Int64
+	inputState
+
+	filename       string
+	bookmarkPath   string
+	truncateOnExit bool
+	parser         parser.Parser
+	tail           *tail.Tail
+	offset         atomic.Int64
+	savedOffset    int64
+	linesPerSec    atomic.Int64
+	linesThisSec   atomic.Int64
 }
 
-func NewFileListener(filename string) (*FileListener, error) {
-	t, err := tail.TailFile(filename, config.GetConfig().GetTailConfig())
+func NewFileListener(filename string, p parser.Parser) (*FileListener, error) {
+	return newFileListener(filename, config.GetConfig().ClientConfig.BookmarkFile, p)
+}
+
+func newFileListener(filename, bookmarkPath string, p parser.Parser) (*FileListener, error) {
+	conf := config.GetConfig()
+
+	f := &FileListener{
+		filename:       filename,
+		parser:         p,
+		bookmarkPath:   bookmarkPath,
+		truncateOnExit: conf.ClientConfig.TruncateOnExit,
+	}
+
+	tailConfig := conf.GetTailConfig()
+	offset := f.resumeOffset()
+	tailConfig.Location = &tail.SeekInfo{Offset: offset, Whence: io.SeekStart}
+	f.offset.Store(offset)
+	f.savedOffset = offset
+
+	t, err := tail.TailFile(filename, tailConfig)
 	if err != nil {
 		return nil, err
 	}
+	f.tail = t
 
-	return &FileListener{
-		filename: filename,
-		tail:     t,
-	}, nil
+	return f, nil
+}
+
+// resumeOffset returns the offset stored in the bookmark file if it still matches the alert file.
+func (f *FileListener) resumeOffset() int64 {
+	if f.bookmarkPath == "" {
+		return 0
+	}
+
+	bookmark, err := LoadBookmark(f.bookmarkPath)
+	if err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "file_listener",
+		}).Warnln("failed to load bookmark, reading from the beginning")
+		return 0
+	}
+	if bookmark == nil {
+		return 0
+	}
+
+	offset, reason := bookmark.ResumeOffset(f.filename)
+	if reason != "" {
+		log.WithFields(logger.Fields{
+			"package": "file_listener",
+			"reason":  reason,
+		}).Infoln("Bookmark does not match the alert file, reading from the beginning")
+		return 0
+	}
+
+	log.WithFields(logger.Fields{
+		"package": "file_listener",
+		"offset":  offset,
+	}).Infoln("Resuming from bookmark")
+
+	return offset
+}
+
+// saveBookmark persists the offset of the last processed line.
+func (f *FileListener) saveBookmark() {
+	if f.bookmarkPath == "" {
+		return
+	}
+
+	offset := f.offset.Load()
+	if offset == f.savedOffset {
+		return
+	}
+
+	bookmark, err := NewBookmark(f.filename, offset)
+	if err == nil {
+		err = bookmark.Save(f.bookmarkPath)
+	}
+	if err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "file_listener",
+		}).Warnln("failed to save bookmark")
+		return
+	}
+
+	f.savedOffset = offset
 }
 
 func (f *FileListener) clearFileContent() {
@@ -56,25 +140,38 @@ func (f *FileListener) Start(ctx context.Context, q *queue.EventBatchQueue) erro
 		return errors.New("listener is not initialized properly")
 	}
 
+	f.setOpen(true)
+	defer f.setOpen(false)
+
 	ticker := time.NewTicker(time.Second)
+	tickerStop := make(chan struct{})
+	tickerDone := make(chan struct{})
 
 	go func() {
+		defer close(tickerDone)
 		for {
 			select {
 			case <-ctx.Done():
 				return
+			case <-tickerStop:
+				return
 			case <-ticker.C:
 				util.UpdateAndReset(&f.linesPerSec, &f.linesThisSec)
+				f.saveBookmark()
 			}
 		}
 	}()
 
-	conf := config.GetConfig()
-
 	defer func() {
 		ticker.Stop()
+		close(tickerStop)
+		<-tickerDone
 		f.tail.Cleanup()
-		f.clearFileContent()
+		if f.truncateOnExit {
+			f.clearFileContent()
+		} else {
+			f.saveBookmark()
+		}
 		f.linesThisSec.Store(0)
 		f.linesPerSec.Store(0)
 
@@ -82,37 +179,17 @@ func (f *FileListener) Start(ctx context.Context, q *queue.EventBatchQueue) erro
 		log.WithField("package", "file_listener").Infoln("Shutting down ListenFile process.")
 	}()
 
-MainLoop:
 	for line := range f.tail.Lines {
 		select {
 		case <-ctx.Done():
 			log.WithField("package", "file_listener").Infoln("Context is done, stopping the listener.")
 			return nil
 		default:
-			var payload types.SnortAlert
-			if err := json.Unmarshal([]byte(line.Text), &payload); err != nil {
-				log.WithFields(logger.Fields{
-					"error":   err,
-					"package": "file_listener",
-				}).Debugln("failed to parse log line")
-
-				continue MainLoop
-			}
-
-			payload.Metadata.SensorID = conf.ClientConfig.SensorID
-			payload.Metadata.ReadAt = time.Now().UnixMicro()
-			pbRecord, metric := processor.ConvertSnortAlertToSensorEvent(&payload)
+			f.offset.Store(line.SeekInfo.Offset)
 
-			if pbRecord == nil || metric == nil {
-				log.WithFields(logger.Fields{
-					"package": "file_listener",
-					"reason":  "nil return from ConvertSnortAlertToSensorEvent",
-				}).Debugln("skipping event")
-				continue MainLoop
+			if processRecord(f.parser, []byte(line.Text), q, "file_listener") {
+				f.linesThisSec.Add(1)
 			}
-
-			q.AddRecordToQueue(pbRecord, metric)
-			f.linesThisSec.Add(1)
 		}
 	}
 
@@ -124,6 +201,11 @@ func (f *FileListener) GetEventReadPerSecond() int64 {
 	return f.linesPerSec.Load()
 }
 
+// GetEventReadPerSecondByFile returns the number of events read per second from the alert file.
+func (f *FileListener) GetEventReadPerSecondByFile() map[string]int64 {
+	return map[string]int64{f.filename: f.linesPerSec.Load()}
+}
+
 // Stop stops the file listener.
 func (f *FileListener) Stop() error {
 	return f.tail.Stop()
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package listener
+
+import "os"
+
+// fileInode is not supported on this platform, files are identified by their first line only.
+func fileInode(_ os.FileInfo) uint64 {
+	return 0
+}
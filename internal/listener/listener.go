@@ -2,6 +2,7 @@ package listener
 
 import (
 	"context"
+	"sync/atomic"
 
 	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
@@ -13,4 +14,36 @@ type Listener interface {
 	GetEventReadPerSecond() int64
 	Start(ctx context.Context, q *queue.EventBatchQueue) error
 	Stop() error
+
+	// Ready reports whether the listener has opened its input and is reading alerts.
+	Ready() bool
+}
+
+// FileRateReporter is implemented by listeners reading alert files, to report the read rate of every file.
+type FileRateReporter interface {
+	GetEventReadPerSecondByFile() map[string]int64
+}
+
+// ConnectionReporter is implemented by listeners accepting Snort connections, to report them.
+type ConnectionReporter interface {
+	GetConnectionCount() int
+	GetLinesReadPerConnection() map[uint64]int64
+}
+
+// TruncationReporter is implemented by listeners that drop records larger than their read buffer.
+type TruncationReporter interface {
+	GetTruncatedRecords() int64
+}
+
+// inputState tracks whether a listener has its input open, it implements Listener.Ready.
+type inputState struct {
+	open atomic.Bool
+}
+
+func (s *inputState) Ready() bool {
+	return s.open.Load()
+}
+
+func (s *inputState) setOpen(open bool) {
+	s.open.Store(open)
 }
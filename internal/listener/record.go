@@ -0,0 +1,60 @@
+package listener
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/processor"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
+)
+
+// processRecord parses a raw alert record and adds it to the queue.
+// It returns false when the record was skipped.
+func processRecord(p parser.Parser, record []byte, q *queue.EventBatchQueue, pkg string) bool {
+	payload := parseRecord(p, record, pkg)
+	if payload == nil {
+		return false
+	}
+
+	return enqueueAlert(payload, q, pkg)
+}
+
+// parseRecord parses a raw alert record. It returns nil when the record was skipped.
+func parseRecord(p parser.Parser, record []byte, pkg string) *types.SnortAlert {
+	payload, err := p.Parse(record)
+	if errors.Is(err, parser.ErrSkipRecord) || errors.Is(err, parser.ErrIncompleteRecord) {
+		return nil
+	}
+	if err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": pkg,
+		}).Debugln("failed to parse log line")
+		return nil
+	}
+
+	return payload
+}
+
+// enqueueAlert stamps the alert with the sensor metadata and adds it to the queue.
+// It returns false when the alert could not be converted.
+func enqueueAlert(payload *types.SnortAlert, q *queue.EventBatchQueue, pkg string) bool {
+	payload.Metadata.SensorID = config.GetConfig().ClientConfig.SensorID
+	payload.Metadata.ReadAt = time.Now().UnixMicro()
+	pbRecord, metric := processor.ConvertSnortAlertToSensorEvent(payload)
+
+	if pbRecord == nil || metric == nil {
+		log.WithFields(logger.Fields{
+			"package": pkg,
+			"reason":  "nil return from ConvertSnortAlertToSensorEvent",
+		}).Debugln("skipping event")
+		return false
+	}
+
+	q.AddRecordToQueue(pbRecord, metric)
+	return true
+}
@@ -0,0 +1,382 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/syslog"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/util"
+)
+
+const (
+	SyslogProtocolUDP = "udp"
+	SyslogProtocolTCP = "tcp"
+	SyslogProtocolTLS = "tls"
+
+	// maxSyslogUDPSize is the largest payload of a UDP datagram.
+	maxSyslogUDPSize = 65535
+)
+
+// SyslogListener receives Snort alerts forwarded over syslog (RFC 5424 or RFC 3164)
+// on UDP, TCP or TCP with TLS. The syslog hostname is kept as the source hostname of the event.
+type SyslogListener struct {
+	inputState
+
+	protocol  string
+	address   string
+	tlsConfig *tls.Config
+	parser    parser.Parser
+
+	mu         sync.Mutex
+	packetConn net.PacketConn
+	listener   net.Listener
+	conns      map[net.Conn]struct{}
+	wg         sync.WaitGroup
+	ready      chan struct{}
+	readyOnce  sync.Once
+
+	eventsPerSec    atomic.Int64
+	eventsThisSec   atomic.Int64
+	invalidMessages atomic.Int64
+}
+
+// NewSyslogListener creates a syslog listener on address. The certificate and key are only used with the tls protocol.
+func NewSyslogListener(protocol, address, certFile, keyFile string, p parser.Parser) (*SyslogListener, error) {
+	s := &SyslogListener{
+		protocol: protocol,
+		address:  address,
+		parser:   p,
+		conns:    make(map[net.Conn]struct{}),
+		ready:    make(chan struct{}),
+	}
+
+	switch protocol {
+	case SyslogProtocolUDP, SyslogProtocolTCP:
+	case SyslogProtocolTLS:
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("syslog over tls requires a certificate and a key")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load syslog tls certificate: %w", err)
+		}
+		s.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported syslog protocol %q, must be udp, tcp or tls", protocol)
+	}
+
+	return s, nil
+}
+
+func (s *SyslogListener) Start(ctx context.Context, q *queue.EventBatchQueue) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				// Unblock the pending read or accept when the context is cancelled.
+				_ = s.Stop()
+				return
+			case <-ticker.C:
+				util.UpdateAndReset(&s.eventsPerSec, &s.eventsThisSec)
+			}
+		}
+	}()
+
+	// Do not leave Addr waiting when the listener could not be created.
+	defer s.markReady()
+
+	defer func() {
+		s.setOpen(false)
+		_ = s.Stop()
+		s.wg.Wait()
+		s.eventsThisSec.Store(0)
+		s.eventsPerSec.Store(0)
+
+		log.WithField("package", "syslog_listener").Infoln("Shutting down SyslogListener process.")
+	}()
+
+	if s.protocol == SyslogProtocolUDP {
+		return s.serveUDP(q)
+	}
+	return s.serveTCP(ctx, q)
+}
+
+func (s *SyslogListener) serveUDP(q *queue.EventBatchQueue) error {
+	conn, err := net.ListenPacket("udp", s.address)
+	if err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "syslog_listener",
+		}).Errorln("failed to create syslog udp listener")
+		return err
+	}
+
+	s.mu.Lock()
+	s.packetConn = conn
+	s.mu.Unlock()
+	s.markReady()
+	s.setOpen(true)
+
+	log.WithFields(logger.Fields{
+		"package": "syslog_listener",
+		"address": conn.LocalAddr().String(),
+	}).Infoln("Syslog udp listener created, waiting for messages...")
+
+	p := parser.ForStream(s.parser)
+
+	buf := make([]byte, maxSyslogUDPSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			log.WithField("package", "syslog_listener").Infoln("Listener is closed, stopping the listener.")
+			return nil
+		}
+		if err != nil {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "syslog_listener",
+			}).Errorln("failed to read syslog datagram")
+			return err
+		}
+
+		s.handleMessage(buf[:n], p, q)
+	}
+}
+
+func (s *SyslogListener) serveTCP(ctx context.Context, q *queue.EventBatchQueue) error {
+	var (
+		listener net.Listener
+		err      error
+	)
+	if s.tlsConfig != nil {
+		listener, err = tls.Listen("tcp", s.address, s.tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", s.address)
+	}
+	if err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "syslog_listener",
+		}).Errorln("failed to create syslog tcp listener")
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+	s.markReady()
+	s.setOpen(true)
+
+	log.WithFields(logger.Fields{
+		"package":  "syslog_listener",
+		"address":  listener.Addr().String(),
+		"protocol": s.protocol,
+	}).Infoln("Syslog tcp listener created, waiting for connections...")
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			log.WithField("package", "syslog_listener").Infoln("Listener is closed, stopping the listener.")
+			return nil
+		}
+		if err != nil {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "syslog_listener",
+			}).Errorln("failed to accept connection")
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConnection(conn, q)
+		}()
+	}
+}
+
+// handleConnection reads syslog messages framed by octet counting or by newlines (RFC 6587).
+func (s *SyslogListener) handleConnection(conn net.Conn, q *queue.EventBatchQueue) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	log.WithFields(logger.Fields{
+		"package": "syslog_listener",
+		"remote":  conn.RemoteAddr().String(),
+	}).Debugln("syslog client connected")
+
+	// Multi-line formats must not mix up the messages of concurrent connections.
+	p := parser.ForStream(s.parser)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), DefaultMaxRecordSize)
+	scanner.Split(splitSyslogFrame)
+
+	for scanner.Scan() {
+		s.handleMessage(scanner.Bytes(), p, q)
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "syslog_listener",
+			"remote":  conn.RemoteAddr().String(),
+		}).Warnln("failed to read syslog stream, closing the connection")
+	}
+}
+
+// splitSyslogFrame is a bufio.SplitFunc for octet counted ("<length> <message>")
+// and newline delimited syslog frames.
+func splitSyslogFrame(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if data[0] >= '1' && data[0] <= '9' {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			if atEOF || len(data) > 10 {
+				return 0, nil, errors.New("invalid syslog octet count")
+			}
+			return 0, nil, nil
+		}
+
+		length, err := strconv.Atoi(string(data[:space]))
+		if err != nil || length > DefaultMaxRecordSize {
+			return 0, nil, fmt.Errorf("invalid syslog octet count %q", data[:space])
+		}
+
+		end := space + 1 + length
+		if len(data) < end {
+			if atEOF {
+				return 0, nil, errors.New("truncated syslog frame")
+			}
+			return 0, nil, nil
+		}
+		return end, data[space+1 : end], nil
+	}
+
+	return bufio.ScanLines(data, atEOF)
+}
+
+// handleMessage extracts the alert from a syslog message and adds it to the queue.
+func (s *SyslogListener) handleMessage(data []byte, p parser.Parser, q *queue.EventBatchQueue) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return
+	}
+
+	msg, err := syslog.Parse(data)
+	if err != nil {
+		s.invalidMessages.Add(1)
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "syslog_listener",
+		}).Debugln("failed to parse syslog message")
+		return
+	}
+
+	payload := parseRecord(p, msg.Body, "syslog_listener")
+	if payload == nil {
+		return
+	}
+
+	if msg.Hostname != "" {
+		payload.Metadata.SourceHostname = &msg.Hostname
+	}
+
+	// Snort's alert_syslog output leaves the timestamp to the syslog header.
+	if payload.Seconds == 0 && !msg.Timestamp.IsZero() {
+		payload.Seconds = msg.Timestamp.Unix()
+		payload.Timestamp = msg.Timestamp.Local().Format(parser.SnortTimeLayout)
+	}
+
+	if enqueueAlert(payload, q, "syslog_listener") {
+		s.eventsThisSec.Add(1)
+	}
+}
+
+func (s *SyslogListener) markReady() {
+	s.readyOnce.Do(func() {
+		close(s.ready)
+	})
+}
+
+// Addr returns the address the listener is bound to, waiting until it has been created.
+func (s *SyslogListener) Addr() net.Addr {
+	<-s.ready
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.packetConn != nil {
+		return s.packetConn.LocalAddr()
+	}
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
+	return nil
+}
+
+// GetEventReadPerSecond returns the number of events read per second.
+func (s *SyslogListener) GetEventReadPerSecond() int64 {
+	return s.eventsPerSec.Load()
+}
+
+// GetInvalidMessages returns the number of messages that were not valid syslog messages since the last call.
+func (s *SyslogListener) GetInvalidMessages() int64 {
+	return s.invalidMessages.Swap(0)
+}
+
+// Stop stops the syslog listener and closes the open connections.
+func (s *SyslogListener) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+
+	var err error
+	if s.packetConn != nil {
+		err = s.packetConn.Close()
+	}
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+)
+
+func Test_SyslogListener(t *testing.T) {
+	body := func(sid int) string {
+		return snortJSONLine(sid)[:len(snortJSONLine(sid))-1]
+	}
+
+	tests := []struct {
+		protocol string
+		messages []string
+	}{
+		{
+			protocol: SyslogProtocolUDP,
+			messages: []string{
+				"<134>1 2024-10-09T22:32:11Z sensor-01 snort 1234 - - " + body(1000),
+				"<14>Oct  9 22:32:11 sensor-01 snort[1234]: " + body(2000) + "\n",
+			},
+		},
+		{
+			protocol: SyslogProtocolTCP,
+			messages: []string{
+				// Newline framing followed by octet counting.
+				"<134>1 2024-10-09T22:32:11Z sensor-01 snort 1234 - - " + body(1000) + "\n",
+				func() string {
+					msg := "<14>Oct  9 22:32:11 sensor-01 snort[1234]: " + body(2000)
+					return fmt.Sprintf("%d %s", len(msg), msg)
+				}(),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			s, err := NewSyslogListener(tt.protocol, "127.0.0.1:0", "", "", &parser.SnortJSONParser{})
+			if err != nil {
+				t.Fatalf("NewSyslogListener() error = %v", err)
+			}
+
+			q := queue.NewEventBatchQueue()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- s.Start(ctx, q)
+			}()
+
+			conn, err := net.Dial(tt.protocol, s.Addr().String())
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer conn.Close()
+
+			for _, msg := range append(tt.messages, "not a syslog message\n") {
+				if _, err := conn.Write([]byte(msg)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			invalid := int64(0)
+			for (q.GetEventQueueSize() < len(tt.messages) || invalid < 1) && ctx.Err() == nil {
+				time.Sleep(20 * time.Millisecond)
+				invalid += s.GetInvalidMessages()
+			}
+
+			_ = s.Stop()
+			if err := <-done; err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			if got := q.GetEventQueueSize(); got != len(tt.messages) {
+				t.Fatalf("Expected %d events, got %d", len(tt.messages), got)
+			}
+			if got := invalid + s.GetInvalidMessages(); got != 1 {
+				t.Errorf("Expected 1 invalid message, got %d", got)
+			}
+			if got := s.GetInvalidMessages(); got != 0 {
+				t.Errorf("Expected the invalid messages to be reset, got %d", got)
+			}
+		})
+	}
+}
+
+func Test_SyslogListenerUnsupportedProtocol(t *testing.T) {
+	if _, err := NewSyslogListener("sctp", ":514", "", "", &parser.SnortJSONParser{}); err == nil {
+		t.Error("Expected an error for an unsupported protocol")
+	}
+	if _, err := NewSyslogListener(SyslogProtocolTLS, ":6514", "", "", &parser.SnortJSONParser{}); err == nil {
+		t.Error("Expected an error for tls without a certificate")
+	}
+}
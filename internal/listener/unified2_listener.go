@@ -0,0 +1,429 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/unified2"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/util"
+)
+
+// unified2PollInterval is how often the spool directory is checked for new data.
+const unified2PollInterval = time.Second
+
+// Unified2Listener reads Snort unified2 spool files, following rotation across
+// <prefix>.<timestamp> files in the spool directory, like barnyard2 does.
+type Unified2Listener struct {
+	inputState
+
+	dir          string
+	prefix       string
+	bookmarkPath string
+	messages     *unified2.SidMap
+	classes      *unified2.ClassificationMap
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	eventsPerSec  atomic.Int64
+	eventsThisSec atomic.Int64
+
+	// current is the spool file being read and offset the position of the next record in it.
+	current string
+	offset  int64
+
+	// finished is the timestamp of the last spool file given up on while no newer one existed,
+	// so that only newer files are read until Snort rotates.
+	finished    uint64
+	hasFinished bool
+
+	// pending is the last event read; it is sent once its packet record has been read,
+	// when another event follows, or when the file stays idle.
+	pending        *types.SnortAlert
+	pendingEventID uint32
+	pendingOffset  int64
+	pendingIdle    bool
+
+	lastSaved time.Time
+}
+
+// NewUnified2Listener creates a listener for the unified2 files named <prefix>.<timestamp> in dir.
+func NewUnified2Listener(dir, prefix string) (*Unified2Listener, error) {
+	conf := config.GetConfig().ClientConfig
+
+	u := &Unified2Listener{
+		dir:          dir,
+		prefix:       prefix,
+		bookmarkPath: conf.BookmarkFile,
+		stop:         make(chan struct{}),
+	}
+
+	if conf.SidMsgMap != "" {
+		messages, err := unified2.LoadSidMap(conf.SidMsgMap)
+		if err != nil {
+			return nil, err
+		}
+		u.messages = messages
+	}
+
+	if conf.ClassificationConfig != "" {
+		classes, err := unified2.LoadClassificationMap(conf.ClassificationConfig)
+		if err != nil {
+			return nil, err
+		}
+		u.classes = classes
+	}
+
+	return u, nil
+}
+
+func (u *Unified2Listener) Start(ctx context.Context, q *queue.EventBatchQueue) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-u.stop:
+				return
+			case <-ticker.C:
+				util.UpdateAndReset(&u.eventsPerSec, &u.eventsThisSec)
+			}
+		}
+	}()
+
+	defer func() {
+		u.flushPending(q)
+		u.saveBookmark(true)
+		u.eventsThisSec.Store(0)
+		u.eventsPerSec.Store(0)
+
+		log.WithField("package", "unified2_listener").Infoln("Shutting down Unified2Listener process.")
+	}()
+
+	u.resume()
+	u.setOpen(true)
+	defer u.setOpen(false)
+
+	for {
+		if ctx.Err() != nil {
+			log.WithField("package", "unified2_listener").Infoln("Context is done, stopping the listener.")
+			return nil
+		}
+
+		if u.current == "" {
+			if next := u.nextFile(); next != "" {
+				u.switchTo(next)
+				continue
+			}
+		} else if err := u.readAvailable(ctx, q); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-u.stop:
+			return nil
+		case <-time.After(unified2PollInterval):
+		}
+	}
+}
+
+// readAvailable reads the records available in the current file and moves on to
+// the next spool file once the current one is complete.
+func (u *Unified2Listener) readAvailable(ctx context.Context, q *queue.EventBatchQueue) error {
+	file, err := os.Open(u.current)
+	if errors.Is(err, os.ErrNotExist) {
+		log.WithFields(logger.Fields{
+			"package": "unified2_listener",
+			"file":    u.current,
+		}).Warnln("Spool file disappeared, moving on to the next one")
+		u.flushPending(q)
+		u.advance()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Look for a newer file before reading, so that records appended to the current one
+	// until Snort rotated are read before switching.
+	next := u.nextFile()
+
+	readAny := false
+	for ctx.Err() == nil {
+		record, n, err := unified2.ReadRecordAt(file, u.offset)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if errors.Is(err, unified2.ErrCorruptRecord) {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "unified2_listener",
+				"file":    u.current,
+				"offset":  u.offset,
+			}).Errorln("Corrupt record, skipping the rest of the file")
+			u.flushPending(q)
+			u.advance()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		readAny = true
+		u.handleRecord(record, q)
+		u.offset += n
+	}
+
+	// A file is complete once Snort has started writing a newer one.
+	if next != "" && ctx.Err() == nil {
+		u.flushPending(q)
+		u.switchTo(next)
+		return nil
+	}
+
+	// Send an event without a packet record once the file has been idle for a full poll interval.
+	if !readAny && u.pending != nil {
+		if u.pendingIdle {
+			u.flushPending(q)
+		} else {
+			u.pendingIdle = true
+		}
+	}
+
+	u.saveBookmark(false)
+
+	return nil
+}
+
+func (u *Unified2Listener) handleRecord(record any, q *queue.EventBatchQueue) {
+	switch r := record.(type) {
+	case *unified2.Event:
+		u.flushPending(q)
+		u.pending = r.ToSnortAlert(u.messages, u.classes)
+		u.pendingEventID = r.EventID
+		u.pendingOffset = u.offset
+		u.pendingIdle = false
+	case *unified2.Packet:
+		if u.pending == nil || u.pendingEventID != r.EventID {
+			log.WithFields(logger.Fields{
+				"package":  "unified2_listener",
+				"event_id": r.EventID,
+			}).Debugln("skipping packet without a matching event")
+			return
+		}
+		r.ApplyToAlert(u.pending)
+		u.flushPending(q)
+	case *unified2.ExtraData:
+		log.WithFields(logger.Fields{
+			"package":  "unified2_listener",
+			"event_id": r.EventID,
+			"type":     r.Type,
+		}).Traceln("read extra data record")
+	case *unified2.Unknown:
+		log.WithFields(logger.Fields{
+			"package": "unified2_listener",
+			"type":    r.Type,
+		}).Debugln("skipping unknown record type")
+	}
+}
+
+// flushPending sends the pending event to the queue.
+func (u *Unified2Listener) flushPending(q *queue.EventBatchQueue) {
+	if u.pending == nil {
+		return
+	}
+
+	if enqueueAlert(u.pending, q, "unified2_listener") {
+		u.eventsThisSec.Add(1)
+	}
+	u.pending = nil
+	u.pendingIdle = false
+}
+
+// resume restores the position from the bookmark file.
+func (u *Unified2Listener) resume() {
+	if u.bookmarkPath == "" {
+		return
+	}
+
+	bookmark, err := LoadBookmark(u.bookmarkPath)
+	if err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "unified2_listener",
+		}).Warnln("failed to load bookmark, reading from the oldest spool file")
+		return
+	}
+	if bookmark == nil || bookmark.File == "" {
+		return
+	}
+
+	if _, err := os.Stat(bookmark.File); err != nil {
+		// The bookmarked file is gone, continue with the next newer one.
+		u.current = bookmark.File
+		u.advance()
+		return
+	}
+
+	offset, reason := bookmark.ResumeOffset(bookmark.File)
+	if reason != "" {
+		log.WithFields(logger.Fields{
+			"package": "unified2_listener",
+			"reason":  reason,
+		}).Infoln("Bookmark does not match the spool file, reading it from the beginning")
+	}
+
+	u.current = bookmark.File
+	u.offset = offset
+
+	log.WithFields(logger.Fields{
+		"package": "unified2_listener",
+		"file":    u.current,
+		"offset":  u.offset,
+	}).Infoln("Resuming from bookmark")
+}
+
+// saveBookmark persists the position of the first record that has not been sent yet.
+func (u *Unified2Listener) saveBookmark(force bool) {
+	if u.bookmarkPath == "" || u.current == "" {
+		return
+	}
+	if !force && time.Since(u.lastSaved) < time.Second {
+		return
+	}
+
+	offset := u.offset
+	if u.pending != nil {
+		offset = u.pendingOffset
+	}
+
+	bookmark, err := NewBinaryBookmark(u.current, offset)
+	if err == nil {
+		err = bookmark.Save(u.bookmarkPath)
+	}
+	if err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "unified2_listener",
+		}).Warnln("failed to save bookmark")
+		return
+	}
+
+	u.lastSaved = time.Now()
+}
+
+func (u *Unified2Listener) switchTo(name string) {
+	log.WithFields(logger.Fields{
+		"package": "unified2_listener",
+		"file":    name,
+	}).Infoln("Reading unified2 spool file")
+
+	u.current = name
+	u.offset = 0
+	u.saveBookmark(true)
+}
+
+// advance moves on to the spool file after the current one, or waits for a newer one to appear.
+func (u *Unified2Listener) advance() {
+	if next := u.nextFile(); next != "" {
+		u.switchTo(next)
+		return
+	}
+
+	u.finished, _ = u.fileTimestamp(u.current)
+	u.hasFinished = true
+	u.current = ""
+	u.offset = 0
+}
+
+// nextFile returns the oldest spool file newer than the current one. While no file is read,
+// it is the oldest file newer than the last finished one, or the oldest file at all.
+func (u *Unified2Listener) nextFile() string {
+	files, err := u.spoolFiles()
+	if err != nil || len(files) == 0 {
+		return ""
+	}
+
+	current, _ := u.fileTimestamp(u.current)
+	if u.current == "" {
+		if !u.hasFinished {
+			return files[0]
+		}
+		current = u.finished
+	}
+	for _, name := range files {
+		if ts, _ := u.fileTimestamp(name); ts > current {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// spoolFiles returns the spool files ordered by their timestamp suffix.
+func (u *Unified2Listener) spoolFiles() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(u.dir, u.prefix+".*"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(matches))
+	for _, name := range matches {
+		if _, ok := u.fileTimestamp(name); ok {
+			files = append(files, name)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		a, _ := u.fileTimestamp(files[i])
+		b, _ := u.fileTimestamp(files[j])
+		return a < b
+	})
+
+	return files, nil
+}
+
+func (u *Unified2Listener) fileTimestamp(name string) (uint64, bool) {
+	suffix, found := strings.CutPrefix(filepath.Base(name), u.prefix+".")
+	if !found {
+		return 0, false
+	}
+
+	ts, err := strconv.ParseUint(suffix, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return ts, true
+}
+
+// GetEventReadPerSecond returns the number of events read per second.
+func (u *Unified2Listener) GetEventReadPerSecond() int64 {
+	return u.eventsPerSec.Load()
+}
+
+// Stop stops the unified2 listener.
+func (u *Unified2Listener) Stop() error {
+	u.stopOnce.Do(func() {
+		close(u.stop)
+	})
+	return nil
+}
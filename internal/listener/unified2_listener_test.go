@@ -0,0 +1,124 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/unified2"
+)
+
+func unified2Event(eventID, sid uint32) []byte {
+	var body bytes.Buffer
+	for _, v := range []uint32{0, eventID, 1728513131, 0, sid, 1, 1, 0, 2} {
+		_ = binary.Write(&body, binary.BigEndian, v)
+	}
+	body.Write([]byte{10, 0, 0, 1, 10, 0, 0, 2})
+	_ = binary.Write(&body, binary.BigEndian, uint16(1234))
+	_ = binary.Write(&body, binary.BigEndian, uint16(80))
+	body.Write([]byte{6, 0, 0, 0})
+
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, unified2.RecordTypeEvent)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func Test_Unified2ListenerFollowsRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "merged.log.1000"), unified2Event(1, 2000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "merged.log.2000"), unified2Event(1, 3000), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewUnified2Listener(dir, "merged.log")
+	if err != nil {
+		t.Fatalf("NewUnified2Listener() error = %v", err)
+	}
+	u.bookmarkPath = filepath.Join(dir, "waldo")
+
+	q := queue.NewEventBatchQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- u.Start(ctx, q)
+	}()
+
+	for q.GetEventQueueSize() < 2 && ctx.Err() == nil {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	_ = u.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if q.GetEventQueueSize() != 2 {
+		t.Fatalf("Expected 2 events from both spool files, got %d", q.GetEventQueueSize())
+	}
+
+	bookmark, err := LoadBookmark(u.bookmarkPath)
+	if err != nil || bookmark == nil {
+		t.Fatalf("LoadBookmark() = %v, %v", bookmark, err)
+	}
+	if bookmark.File != filepath.Join(dir, "merged.log.2000") || bookmark.Offset != int64(len(unified2Event(1, 3000))) {
+		t.Errorf("Unexpected bookmark: %+v", bookmark)
+	}
+}
+
+func Test_Unified2ListenerCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+
+	// A valid event followed by a record header with an impossible length.
+	data := unified2Event(1, 2000)
+	data = binary.BigEndian.AppendUint32(data, unified2.RecordTypeEvent)
+	data = binary.BigEndian.AppendUint32(data, 0xFFFFFFFF)
+	if err := os.WriteFile(filepath.Join(dir, "merged.log.1000"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewUnified2Listener(dir, "merged.log")
+	if err != nil {
+		t.Fatalf("NewUnified2Listener() error = %v", err)
+	}
+
+	q := queue.NewEventBatchQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- u.Start(ctx, q)
+	}()
+
+	// Give the listener several poll intervals to read the file again.
+	time.Sleep(3*unified2PollInterval + 500*time.Millisecond)
+
+	// A newer file is still picked up.
+	if err := os.WriteFile(filepath.Join(dir, "merged.log.2000"), unified2Event(1, 3000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for q.GetEventQueueSize() < 2 && ctx.Err() == nil {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	_ = u.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if got := q.GetEventQueueSize(); got != 2 {
+		t.Errorf("Expected every alert to be read once, got %d alerts", got)
+	}
+}
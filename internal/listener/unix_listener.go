@@ -3,34 +3,68 @@ package listener
 import (
 	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
 	"net"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/mata-elang-stable/sensor-snort-service/internal/config"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
-	"github.com/mata-elang-stable/sensor-snort-service/internal/processor"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
-	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/util"
 )
 
+const (
+	// SocketTypeStream reads newline delimited records from SOCK_STREAM connections.
+	SocketTypeStream = "stream"
+	// SocketTypeDatagram reads one record per SOCK_DGRAM datagram.
+	SocketTypeDatagram = "datagram"
+
+	// DefaultMaxRecordSize is the default maximum size of a single record read from a unix socket.
+	DefaultMaxRecordSize = 1024 * 1024
+)
+
+// unixConn is a single Snort connection to the unix socket.
+type unixConn struct {
+	id          uint64
+	conn        net.Conn
+	connectedAt time.Time
+	linesRead   atomic.Int64
+}
+
 type UnixListener struct {
-	listener     net.Listener
-	conn         net.Conn
-	linesPerSec  atomic.Int64
-	linesThisSec atomic.Int64
-	socketPath   string
+	inputState
+
+	mu            sync.Mutex
+	listener      net.Listener
+	conns         map[uint64]*unixConn
+	nextConnID    uint64
+	wg            sync.WaitGroup
+	linesPerSec   atomic.Int64
+	linesThisSec  atomic.Int64
+	socketPath    string
+	maxRecordSize int
+	parser        parser.Parser
 }
 
-func NewUnixListener(socketPath string) (*UnixListener, error) {
+func NewUnixListener(socketPath string, maxRecordSize int, p parser.Parser) (*UnixListener, error) {
+	if maxRecordSize <= 0 {
+		maxRecordSize = DefaultMaxRecordSize
+	}
+
 	return &UnixListener{
-		socketPath: socketPath,
+		socketPath:    socketPath,
+		maxRecordSize: maxRecordSize,
+		parser:        p,
+		conns:         make(map[uint64]*unixConn),
 	}, nil
 }
 
+// Start accepts Snort connections until the listener is stopped.
+// Every connection is read by its own goroutine, so a Snort restart or several
+// packet threads with their own connection do not stop the listener.
 func (u *UnixListener) Start(ctx context.Context, q *queue.EventBatchQueue) error {
 	// Remove existing socket file if it exists
 	if err := os.RemoveAll(u.socketPath); err != nil {
@@ -50,7 +84,13 @@ func (u *UnixListener) Start(ctx context.Context, q *queue.EventBatchQueue) erro
 		}).Errorln("failed to create unix socket listener")
 		return err
 	}
+
+	u.mu.Lock()
 	u.listener = listener
+	u.mu.Unlock()
+
+	u.setOpen(true)
+	defer u.setOpen(false)
 
 	log.WithFields(logger.Fields{
 		"package": "unix_listener",
@@ -72,6 +112,8 @@ func (u *UnixListener) Start(ctx context.Context, q *queue.EventBatchQueue) erro
 		for {
 			select {
 			case <-ctx.Done():
+				// Unblock Accept when the context is cancelled.
+				_ = u.Stop()
 				return
 			case <-ticker.C:
 				util.UpdateAndReset(&u.linesPerSec, &u.linesThisSec)
@@ -79,15 +121,10 @@ func (u *UnixListener) Start(ctx context.Context, q *queue.EventBatchQueue) erro
 		}
 	}()
 
-	conf := config.GetConfig()
-
 	defer func() {
-		if u.conn != nil {
-			u.conn.Close()
-		}
-		if u.listener != nil {
-			u.listener.Close()
-		}
+		_ = u.Stop()
+		u.wg.Wait()
+
 		// Clean up socket file
 		os.RemoveAll(u.socketPath)
 		u.linesThisSec.Store(0)
@@ -96,73 +133,124 @@ func (u *UnixListener) Start(ctx context.Context, q *queue.EventBatchQueue) erro
 		log.WithField("package", "unix_listener").Infoln("Shutting down UnixListener process.")
 	}()
 
-	conn, err := u.listener.Accept()
-	if err != nil {
-		log.WithFields(logger.Fields{
-			"error":   err,
-			"package": "unix_listener",
-		}).Errorln("failed to accept connection")
-		return err
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			log.WithField("package", "unix_listener").Infoln("Listener is closed, stopping the listener.")
+			return nil
+		}
+		if err != nil {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "unix_listener",
+			}).Errorln("failed to accept connection")
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+
+		c := u.track(conn)
+
+		u.wg.Add(1)
+		go func() {
+			defer u.wg.Done()
+			u.handleConnection(ctx, c, q)
+		}()
 	}
-	u.conn = conn
+}
+
+// track registers a new connection.
+func (u *UnixListener) track(conn net.Conn) *unixConn {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.nextConnID++
+	c := &unixConn{
+		id:          u.nextConnID,
+		conn:        conn,
+		connectedAt: time.Now(),
+	}
+	u.conns[c.id] = c
 
 	log.WithFields(logger.Fields{
-		"package": "unix_listener",
+		"package":     "unix_listener",
+		"connection":  c.id,
+		"connections": len(u.conns),
 	}).Infoln("Snort connected to unix socket")
 
-	scanner := bufio.NewScanner(u.conn)
+	return c
+}
+
+// untrack removes a connection once it has been closed.
+func (u *UnixListener) untrack(c *unixConn) {
+	u.mu.Lock()
+	delete(u.conns, c.id)
+	remaining := len(u.conns)
+	u.mu.Unlock()
+
+	log.WithFields(logger.Fields{
+		"package":     "unix_listener",
+		"connection":  c.id,
+		"lines_read":  c.linesRead.Load(),
+		"duration":    time.Since(c.connectedAt).Round(time.Second).String(),
+		"connections": remaining,
+	}).Infoln("Snort disconnected from unix socket")
+}
+
+// handleConnection reads alert lines from a single connection until it is closed.
+func (u *UnixListener) handleConnection(ctx context.Context, c *unixConn, q *queue.EventBatchQueue) {
+	defer func() {
+		c.conn.Close()
+		u.untrack(c)
+	}()
+
+	// Multi-line formats must not mix up the lines of concurrent connections.
+	p := parser.ForStream(u.parser)
+
+	scanner := bufio.NewScanner(c.conn)
 
 	// Increase buffer size for large JSON payloads
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	buf := make([]byte, 0, min(64*1024, u.maxRecordSize))
+	scanner.Buffer(buf, u.maxRecordSize)
 
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
-			log.WithField("package", "unix_listener").Infoln("Context is done, stopping the listener.")
-			return nil
+			log.WithField("package", "unix_listener").Infoln("Context is done, closing the connection.")
+			return
 		default:
-			line := scanner.Text()
-
 			log.WithFields(logger.Fields{
-				"package": "unix_listener",
+				"package":    "unix_listener",
+				"connection": c.id,
 			}).Debugln("read log line")
 
-			var payload types.SnortAlert
-			if err := json.Unmarshal([]byte(line), &payload); err != nil {
-				log.WithFields(logger.Fields{
-					"error":   err,
-					"package": "unix_listener",
-				}).Debugln("failed to parse log line")
-				continue
+			if processRecord(p, scanner.Bytes(), q, "unix_listener") {
+				c.linesRead.Add(1)
+				u.linesThisSec.Add(1)
 			}
-
-			payload.Metadata.SensorID = conf.ClientConfig.SensorID
-			payload.Metadata.ReadAt = time.Now().UnixMicro()
-			pbRecord, metric := processor.ConvertSnortAlertToSensorEvent(&payload)
-
-			if pbRecord == nil || metric == nil {
-				log.WithFields(logger.Fields{
-					"package": "unix_listener",
-					"reason":  "nil return from ConvertSnortAlertToSensorEvent",
-				}).Debugln("skipping event")
-				continue
-			}
-
-			q.AddRecordToQueue(pbRecord, metric)
-			u.linesThisSec.Add(1)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	err := scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
 		log.WithFields(logger.Fields{
-			"error":   err,
-			"package": "unix_listener",
-		}).Errorln("scanner error")
-		return err
+			"package":         "unix_listener",
+			"connection":      c.id,
+			"max_record_size": u.maxRecordSize,
+		}).Errorln("record is larger than the maximum record size, closing the connection; increase --socket-max-record-size")
+		return
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.WithFields(logger.Fields{
+			"error":      err,
+			"package":    "unix_listener",
+			"connection": c.id,
+		}).Warnln("scanner error, closing the connection")
 	}
-
-	return nil
 }
 
 // GetEventReadPerSecond returns the number of events read per second.
@@ -170,15 +258,44 @@ func (u *UnixListener) GetEventReadPerSecond() int64 {
 	return u.linesPerSec.Load()
 }
 
+// GetConnectionCount returns the number of Snort connections currently open.
+func (u *UnixListener) GetConnectionCount() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return len(u.conns)
+}
+
+// GetLinesReadPerConnection returns the number of lines read so far by every open connection.
+func (u *UnixListener) GetLinesReadPerConnection() map[uint64]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	lines := make(map[uint64]int64, len(u.conns))
+	for id, c := range u.conns {
+		lines[id] = c.linesRead.Load()
+	}
+
+	return lines
+}
+
 // Stop stops the unix listener.
-// Closing u.conn ensures the scanner.Scan() loop in Start() unblocks,
-// allowing the Start() defer to clean up resources properly.
+// Closing the listener unblocks Accept and closing the connections unblocks
+// their scanners, allowing the Start() defer to clean up resources properly.
 func (u *UnixListener) Stop() error {
-	if u.conn != nil {
-		u.conn.Close()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, c := range u.conns {
+		c.conn.Close()
 	}
+
 	if u.listener != nil {
-		return u.listener.Close()
+		err := u.listener.Close()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		return err
 	}
 	return nil
 }
@@ -0,0 +1,151 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+)
+
+func snortJSONLine(sid int) string {
+	return fmt.Sprintf(`{"seconds":1728513131,"action":"allow","dst_addr":"206.54.163.50","dst_port":80,"gid":1,"msg":"test rule","priority":1,"proto":"TCP","rev":1,"rule":"1:%d:1","sid":%d,"src_addr":"192.168.10.15","src_port":55922,"timestamp":"24/10/10-05:32:11.000107"}`+"\n", sid, sid)
+}
+
+func Test_UnixListenerMultipleConnections(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "snort_alert")
+
+	u, err := NewUnixListener(socketPath, 0, &parser.SnortJSONParser{})
+	if err != nil {
+		t.Fatalf("NewUnixListener() error = %v", err)
+	}
+
+	q := queue.NewEventBatchQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- u.Start(ctx, q)
+	}()
+
+	dial := func() net.Conn {
+		for ctx.Err() == nil {
+			conn, err := net.Dial("unix", socketPath)
+			if err == nil {
+				return conn
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatal("failed to connect to the unix listener")
+		return nil
+	}
+
+	first := dial()
+	second := dial()
+
+	if !u.Ready() {
+		t.Errorf("Expected the listener to be ready once the socket is open")
+	}
+
+	if _, err := first.Write([]byte(snortJSONLine(1000))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Write([]byte(snortJSONLine(2000))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The listener must keep serving the other connections after a client disconnects.
+	first.Close()
+	third := dial()
+	if _, err := third.Write([]byte(snortJSONLine(3000))); err != nil {
+		t.Fatal(err)
+	}
+
+	for q.GetEventQueueSize() < 3 && ctx.Err() == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if q.GetEventQueueSize() != 3 {
+		t.Fatalf("Expected 3 events from all connections, got %d", q.GetEventQueueSize())
+	}
+
+	for u.GetConnectionCount() != 2 && ctx.Err() == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := u.GetConnectionCount(); got != 2 {
+		t.Errorf("Expected 2 open connections, got %d", got)
+	}
+
+	_ = u.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	second.Close()
+	third.Close()
+
+	if got := u.GetConnectionCount(); got != 0 {
+		t.Errorf("Expected all connections to be closed, got %d", got)
+	}
+	if u.Ready() {
+		t.Errorf("Expected the listener not to be ready once it is stopped")
+	}
+}
+
+func Test_UnixDatagramListener(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "snort_alert")
+	record := snortJSONLine(1000)
+
+	u, err := NewUnixDatagramListener(socketPath, len(record), &parser.SnortJSONParser{})
+	if err != nil {
+		t.Fatalf("NewUnixDatagramListener() error = %v", err)
+	}
+
+	q := queue.NewEventBatchQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- u.Start(ctx, q)
+	}()
+
+	var conn net.Conn
+	for ctx.Err() == nil {
+		if conn, err = net.Dial("unixgram", socketPath); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if conn == nil {
+		t.Fatal("failed to connect to the unix datagram listener")
+	}
+	defer conn.Close()
+
+	// A record larger than the maximum record size must be dropped, not parsed partially.
+	if _, err := conn.Write([]byte(snortJSONLine(1000000))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte(record)); err != nil {
+		t.Fatal(err)
+	}
+
+	for q.GetEventQueueSize() < 1 && ctx.Err() == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	_ = u.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if got := q.GetEventQueueSize(); got != 1 {
+		t.Errorf("Expected 1 event, got %d", got)
+	}
+	if got := u.GetTruncatedRecords(); got != 1 {
+		t.Errorf("Expected 1 truncated record, got %d", got)
+	}
+}
@@ -0,0 +1,183 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/util"
+)
+
+// UnixDatagramListener reads alerts from a SOCK_DGRAM unix socket, where every
+// datagram carries exactly one alert record.
+type UnixDatagramListener struct {
+	inputState
+
+	mu            sync.Mutex
+	conn          *net.UnixConn
+	eventsPerSec  atomic.Int64
+	eventsThisSec atomic.Int64
+	truncated     atomic.Int64
+	socketPath    string
+	maxRecordSize int
+	parser        parser.Parser
+}
+
+func NewUnixDatagramListener(socketPath string, maxRecordSize int, p parser.Parser) (*UnixDatagramListener, error) {
+	if maxRecordSize <= 0 {
+		maxRecordSize = DefaultMaxRecordSize
+	}
+
+	return &UnixDatagramListener{
+		socketPath:    socketPath,
+		maxRecordSize: maxRecordSize,
+		parser:        p,
+	}, nil
+}
+
+func (u *UnixDatagramListener) Start(ctx context.Context, q *queue.EventBatchQueue) error {
+	// Remove existing socket file if it exists
+	if err := os.RemoveAll(u.socketPath); err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "unixgram_listener",
+		}).Errorln("failed to remove existing socket file")
+		return err
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: u.socketPath, Net: "unixgram"})
+	if err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "unixgram_listener",
+		}).Errorln("failed to create unix datagram socket")
+		return err
+	}
+
+	u.mu.Lock()
+	u.conn = conn
+	u.mu.Unlock()
+
+	u.setOpen(true)
+	defer u.setOpen(false)
+
+	// Ask for a receive buffer large enough to queue a burst of full size records.
+	if err := conn.SetReadBuffer(u.maxRecordSize * 4); err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "unixgram_listener",
+		}).Warnln("failed to set socket receive buffer size")
+	}
+
+	// Set socket permissions so Snort can write to it
+	if err := os.Chmod(u.socketPath, 0666); err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "unixgram_listener",
+		}).Warnln("failed to set socket permissions")
+	}
+
+	log.WithFields(logger.Fields{
+		"package": "unixgram_listener",
+		"socket":  u.socketPath,
+	}).Infoln("Unix datagram socket created, waiting for Snort alerts...")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				// Unblock ReadFrom when the context is cancelled.
+				_ = u.Stop()
+				return
+			case <-ticker.C:
+				util.UpdateAndReset(&u.eventsPerSec, &u.eventsThisSec)
+			}
+		}
+	}()
+
+	defer func() {
+		_ = u.Stop()
+
+		// Clean up socket file
+		os.RemoveAll(u.socketPath)
+		u.eventsThisSec.Store(0)
+		u.eventsPerSec.Store(0)
+
+		log.WithField("package", "unixgram_listener").Infoln("Shutting down UnixDatagramListener process.")
+	}()
+
+	// One spare byte tells a datagram of exactly maxRecordSize bytes apart from a
+	// longer one that the kernel has cut to fit the buffer.
+	buf := make([]byte, u.maxRecordSize+1)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			log.WithField("package", "unixgram_listener").Infoln("Socket is closed, stopping the listener.")
+			return nil
+		}
+		if err != nil {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "unixgram_listener",
+			}).Errorln("failed to read datagram")
+			return err
+		}
+
+		if n > u.maxRecordSize {
+			u.truncated.Add(1)
+			log.WithFields(logger.Fields{
+				"package":         "unixgram_listener",
+				"max_record_size": u.maxRecordSize,
+			}).Errorln("dropping truncated record: the datagram is larger than the maximum record size; increase --socket-max-record-size")
+			continue
+		}
+
+		// Some writers terminate the record with a newline, others do not.
+		record := bytes.TrimRight(buf[:n], "\r\n")
+		if len(record) == 0 {
+			continue
+		}
+
+		if processRecord(u.parser, record, q, "unixgram_listener") {
+			u.eventsThisSec.Add(1)
+		}
+	}
+}
+
+// GetEventReadPerSecond returns the number of events read per second.
+func (u *UnixDatagramListener) GetEventReadPerSecond() int64 {
+	return u.eventsPerSec.Load()
+}
+
+// GetTruncatedRecords returns the number of records dropped because they did not fit the read buffer since the last call.
+func (u *UnixDatagramListener) GetTruncatedRecords() int64 {
+	return u.truncated.Swap(0)
+}
+
+// Stop stops the unix datagram listener.
+// Closing the socket unblocks ReadFrom, allowing the Start() defer to clean up resources properly.
+func (u *UnixDatagramListener) Stop() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.conn != nil {
+		err := u.conn.Close()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
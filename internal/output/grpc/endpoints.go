@@ -0,0 +1,264 @@
+package grpc
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// BalancePolicy selects the collector a new stream is opened to.
+type BalancePolicy string
+
+const (
+	// BalanceRoundRobin sends every batch to the next healthy collector.
+	BalanceRoundRobin BalancePolicy = "round-robin"
+
+	// BalancePriority sends to the first healthy collector in the list, the others are only used on failover.
+	BalancePriority BalancePolicy = "priority"
+)
+
+// ParseBalancePolicy parses the name of a balance policy.
+func ParseBalancePolicy(name string) (BalancePolicy, error) {
+	switch policy := BalancePolicy(strings.ToLower(name)); policy {
+	case BalanceRoundRobin, BalancePriority:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown balance policy %q, expected %q or %q", name, BalanceRoundRobin, BalancePriority)
+}
+
+// EjectionPolicy controls when an unhealthy collector is taken out of rotation.
+type EjectionPolicy struct {
+	// Failures is the number of consecutive failures after which a collector is ejected. Zero never ejects.
+	Failures int
+
+	// Duration is how long an ejected collector is skipped.
+	Duration time.Duration
+}
+
+// DefaultEjectionPolicy returns the ejection policy of a new StreamManager.
+func DefaultEjectionPolicy() EjectionPolicy {
+	return EjectionPolicy{
+		Failures: 3,
+		Duration: 30 * time.Second,
+	}
+}
+
+// Endpoint is the address of a collector. A host that resolves to several addresses
+// is balanced round-robin by gRPC, skipping the addresses it cannot connect to.
+type Endpoint struct {
+	Host string
+	Port int
+}
+
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// ParseEndpoints parses collector addresses given as host or host:port.
+// Entries may also be separated by commas. Hosts without a port use defaultPort.
+func ParseEndpoints(servers []string, defaultPort int) ([]Endpoint, error) {
+	endpoints := make([]Endpoint, 0, len(servers))
+	seen := make(map[Endpoint]bool)
+
+	for _, entry := range servers {
+		for _, server := range strings.Split(entry, ",") {
+			server = strings.TrimSpace(server)
+			if server == "" {
+				continue
+			}
+
+			e := Endpoint{Host: server, Port: defaultPort}
+
+			// Bare IPv6 addresses contain colons but no port.
+			if strings.HasPrefix(server, "[") || strings.Count(server, ":") == 1 {
+				host, port, err := net.SplitHostPort(server)
+				if err != nil {
+					return nil, fmt.Errorf("invalid server %q: %w", server, err)
+				}
+				e.Host = host
+				if e.Port, err = strconv.Atoi(port); err != nil || e.Port <= 0 || e.Port > 65535 {
+					return nil, fmt.Errorf("invalid port in server %q", server)
+				}
+			}
+			if e.Host == "" {
+				return nil, fmt.Errorf("invalid server %q: missing host", server)
+			}
+
+			if !seen[e] {
+				seen[e] = true
+				endpoints = append(endpoints, e)
+			}
+		}
+	}
+
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no server configured")
+	}
+
+	return endpoints, nil
+}
+
+// endpoint is a collector together with its connection and health.
+type endpoint struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client pb.SensorServiceClient
+
+	// health is asked whether the collector is serving before a stream is opened, when set.
+	health healthpb.HealthClient
+
+	// failures counts the failures since the last acknowledgement.
+	failures     int
+	ejectedUntil time.Time
+}
+
+// available reports whether the collector may be used for a new stream.
+func (e *endpoint) available(now time.Time) bool {
+	if now.Before(e.ejectedUntil) {
+		return false
+	}
+
+	// A connection that failed to connect is skipped until gRPC reconnects it.
+	return e.conn == nil || e.conn.GetState() != connectivity.TransientFailure
+}
+
+// endpointPool picks the collectors new streams are opened to and ejects the ones that keep failing.
+type endpointPool struct {
+	mu        sync.Mutex
+	endpoints []*endpoint
+	policy    BalancePolicy
+	ejection  EjectionPolicy
+	next      int
+}
+
+func newEndpointPool(endpoints ...*endpoint) *endpointPool {
+	return &endpointPool{
+		endpoints: endpoints,
+		policy:    BalanceRoundRobin,
+		ejection:  DefaultEjectionPolicy(),
+	}
+}
+
+func (p *endpointPool) setPolicy(policy BalancePolicy, ejection EjectionPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.policy = policy
+	p.ejection = ejection
+}
+
+// rotates reports whether every batch goes to the next collector.
+func (p *endpointPool) rotates() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.policy == BalanceRoundRobin && len(p.endpoints) > 1
+}
+
+// candidates returns the collectors to try for a new stream, in order. When every collector
+// is unavailable, all of them are returned, the one ejected first at the front.
+func (p *endpointPool) candidates() []*endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start := 0
+	if p.policy == BalanceRoundRobin {
+		start = p.next % len(p.endpoints)
+		p.next++
+	}
+
+	now := time.Now()
+	ordered := make([]*endpoint, 0, len(p.endpoints))
+	for i := range p.endpoints {
+		e := p.endpoints[(start+i)%len(p.endpoints)]
+		if e.available(now) {
+			ordered = append(ordered, e)
+		}
+	}
+	if len(ordered) > 0 {
+		return ordered
+	}
+
+	ordered = append(ordered, p.endpoints...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].ejectedUntil.Before(ordered[j].ejectedUntil)
+	})
+
+	return ordered
+}
+
+// healthy reports whether a collector that is not ejected has a ready connection.
+// Idle connections are asked to connect, so a sensor without alerts still becomes healthy.
+func (p *endpointPool) healthy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	healthy := false
+	for _, e := range p.endpoints {
+		if e.conn == nil || now.Before(e.ejectedUntil) {
+			continue
+		}
+
+		switch e.conn.GetState() {
+		case connectivity.Ready:
+			healthy = true
+		case connectivity.Idle:
+			e.conn.Connect()
+		}
+	}
+
+	return healthy
+}
+
+// failed records a failure of the collector and ejects it once the ejection policy is reached.
+func (p *endpointPool) failed(e *endpoint, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	e.failures++
+	if p.ejection.Failures <= 0 || now.Before(e.ejectedUntil) {
+		return
+	}
+
+	// A collector that fails again after its ejection, without acknowledging anything, is ejected right away.
+	if e.ejectedUntil.IsZero() && e.failures < p.ejection.Failures {
+		return
+	}
+
+	e.ejectedUntil = now.Add(p.ejection.Duration)
+
+	log.WithFields(logger.Fields{
+		"error":    err,
+		"package":  "grpc",
+		"server":   e.addr,
+		"duration": p.ejection.Duration.String(),
+	}).Warnln("Ejecting unhealthy server")
+}
+
+// succeeded records that the collector acknowledged an event.
+func (p *endpointPool) succeeded(e *endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !e.ejectedUntil.IsZero() {
+		log.WithFields(logger.Fields{
+			"package": "grpc",
+			"server":  e.addr,
+		}).Infoln("Server is healthy again")
+	}
+	e.failures = 0
+	e.ejectedUntil = time.Time{}
+}
@@ -0,0 +1,219 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+func Test_ParseEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers []string
+		want    []Endpoint
+		wantErr bool
+	}{
+		{
+			name:    "Must use the default port for hosts without one",
+			servers: []string{"collector-a", "collector-b:50052"},
+			want:    []Endpoint{{Host: "collector-a", Port: 50051}, {Host: "collector-b", Port: 50052}},
+		},
+		{
+			name:    "Must split comma separated servers and skip duplicates",
+			servers: []string{"collector-a, collector-b", "collector-a:50051"},
+			want:    []Endpoint{{Host: "collector-a", Port: 50051}, {Host: "collector-b", Port: 50051}},
+		},
+		{
+			name:    "Must parse IPv6 addresses",
+			servers: []string{"::1", "[fd00::2]:50052"},
+			want:    []Endpoint{{Host: "::1", Port: 50051}, {Host: "fd00::2", Port: 50052}},
+		},
+		{
+			name:    "Must reject an invalid port",
+			servers: []string{"collector-a:grpc"},
+			wantErr: true,
+		},
+		{
+			name:    "Must reject an empty list",
+			servers: []string{" , "},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEndpoints(tt.servers, 50051)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEndpoints() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseEndpoints() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// fakeCollector opens streams that acknowledge every event, or fails to open them when err is set.
+// When dropAfter is set, the first stream ends after receiving that many events, without acknowledging them.
+// When failAfter is set, sending on the first stream fails after that many events while it stays open.
+type fakeCollector struct {
+	pb.SensorServiceClient
+	mu        sync.Mutex
+	err       error
+	received  []*pb.SensorEvent
+	dropAfter int
+	failAfter int
+	streams   int
+	opened    []*fakeCollectorStream
+}
+
+func (f *fakeCollector) StreamDataWithAck(ctx context.Context, _ ...grpc.CallOption) (pb.SensorService_StreamDataWithAckClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	f.streams++
+	stream := &fakeCollectorStream{ctx: ctx, collector: f, acks: make(chan *pb.EventAck, 16)}
+	if f.streams == 1 {
+		stream.dropAfter = f.dropAfter
+		stream.failAfter = f.failAfter
+	}
+	f.opened = append(f.opened, stream)
+
+	return stream, nil
+}
+
+func (f *fakeCollector) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+func (f *fakeCollector) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.received)
+}
+
+type fakeCollectorStream struct {
+	pb.SensorService_StreamDataWithAckClient
+	ctx       context.Context
+	collector *fakeCollector
+	acks      chan *pb.EventAck
+	closeOnce sync.Once
+	dropAfter int
+	failAfter int
+	sent      int
+}
+
+func (f *fakeCollectorStream) Send(event *pb.SensorEvent) error {
+	if f.failAfter > 0 && f.sent >= f.failAfter {
+		return errors.New("send failed")
+	}
+	if f.dropAfter > 0 && f.sent >= f.dropAfter {
+		return io.EOF
+	}
+
+	f.collector.mu.Lock()
+	f.collector.received = append(f.collector.received, event)
+	f.collector.mu.Unlock()
+
+	f.sent++
+	if f.dropAfter > 0 {
+		if f.sent == f.dropAfter {
+			_ = f.CloseSend()
+		}
+		return nil
+	}
+
+	f.acks <- &pb.EventAck{EventHashSha256: event.EventHashSha256, Success: true}
+	return nil
+}
+
+func (f *fakeCollectorStream) Recv() (*pb.EventAck, error) {
+	select {
+	case ack, ok := <-f.acks:
+		if !ok {
+			return nil, io.EOF
+		}
+		return ack, nil
+	case <-f.ctx.Done():
+		return nil, status.FromContextError(f.ctx.Err()).Err()
+	}
+}
+
+func (f *fakeCollectorStream) CloseSend() error {
+	f.closeOnce.Do(func() { close(f.acks) })
+	return nil
+}
+
+func Test_StreamManagerBalancing(t *testing.T) {
+	newManager := func(policy BalancePolicy, collectors ...*fakeCollector) *StreamManager {
+		endpoints := make([]*endpoint, 0, len(collectors))
+		for i, c := range collectors {
+			endpoints = append(endpoints, &endpoint{addr: string(rune('a' + i)), client: c})
+		}
+		sm := &StreamManager{
+			endpoints: newEndpointPool(endpoints...),
+			streams:   make(map[*ackStream]struct{}),
+			timeout:   time.Minute,
+			retry:     RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 2, MaxAttempts: 3},
+		}
+		sm.SetBalancePolicy(policy, EjectionPolicy{Failures: 1, Duration: time.Hour})
+		return sm
+	}
+	send := func(t *testing.T, sm *StreamManager, batches int) {
+		for i := 0; i < batches; i++ {
+			if _, err := sm.SendBulkEvent(context.Background(), []*pb.SensorEvent{{EventHashSha256: "hash", EventMetricsCount: 1}}); err != nil {
+				t.Fatalf("SendBulkEvent() error = %v", err)
+			}
+		}
+	}
+
+	t.Run("Must spread batches over the servers with round-robin", func(t *testing.T) {
+		a, b := &fakeCollector{}, &fakeCollector{}
+		sm := newManager(BalanceRoundRobin, a, b)
+		send(t, sm, 4)
+
+		if a.count() != 2 || b.count() != 2 {
+			t.Errorf("Expected 2 events on every server, got %d and %d", a.count(), b.count())
+		}
+	})
+
+	t.Run("Must stay on the first server with priority", func(t *testing.T) {
+		a, b := &fakeCollector{}, &fakeCollector{}
+		sm := newManager(BalancePriority, a, b)
+		send(t, sm, 4)
+
+		if a.count() != 4 || b.count() != 0 {
+			t.Errorf("Expected every event on the first server, got %d and %d", a.count(), b.count())
+		}
+	})
+
+	t.Run("Must fail over and eject a server that cannot be reached", func(t *testing.T) {
+		a, b := &fakeCollector{err: errors.New("connection refused")}, &fakeCollector{}
+		sm := newManager(BalancePriority, a, b)
+		send(t, sm, 1)
+		sm.Close()
+
+		// The ejected server is not tried again while it is ejected.
+		a.setErr(nil)
+		send(t, sm, 1)
+
+		if a.count() != 0 || b.count() != 2 {
+			t.Errorf("Expected every event on the second server, got %d and %d", a.count(), b.count())
+		}
+	})
+}
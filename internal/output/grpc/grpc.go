@@ -2,10 +2,12 @@ package grpc
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/mata-elang-stable/sensor-snort-service/internal/auth"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
 	"google.golang.org/grpc"
@@ -34,6 +36,44 @@ type CertOpts struct {
 	CertFile   string
 	ServerName string
 	Insecure   bool
+
+	// ClientCertFile and ClientKeyFile authenticate the sensor to the server (mutual TLS).
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// PerRPCCredentials, if set, are attached to every call, e.g. the token of the sensor.
+	PerRPCCredentials credentials.PerRPCCredentials
+}
+
+// TransportCredentials returns the credentials to connect to the server with.
+// Without a CA file the server certificate is verified against the system roots.
+func (c CertOpts) TransportCredentials() (credentials.TransportCredentials, error) {
+	if c.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.CertFile != "" {
+		pool, err := auth.LoadCertPool(c.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCertFile != "" || c.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
 }
 
 // NewGRPCStreamClient creates a new gRPC client that streams data to the server
@@ -50,17 +90,13 @@ func NewGRPCStreamClient(mainCtx context.Context, server string, port int, certO
 		maxMessageSize: maxMessageSize,
 	}
 
-	if certOpts.Insecure {
-		m.creds = insecure.NewCredentials()
-	} else {
-		creds, err := credentials.NewClientTLSFromFile(certOpts.CertFile, certOpts.ServerName)
-		if err != nil {
-			return nil, err
-		}
-		m.creds = creds
+	creds, err := certOpts.TransportCredentials()
+	if err != nil {
+		return nil, err
 	}
+	m.creds = creds
 
-	err := m.connect(ctx)
+	err = m.connect(ctx)
 	if err != nil {
 		return nil, err
 	}
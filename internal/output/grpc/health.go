@@ -0,0 +1,74 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNotServing is returned when the health service of a server reports that it cannot take events.
+var ErrNotServing = errors.New("server is not serving")
+
+// healthCheckTimeout bounds the health check made before a stream is opened.
+const healthCheckTimeout = 5 * time.Second
+
+// SensorServiceName is the service the health of a server is reported for.
+var SensorServiceName = pb.SensorService_ServiceDesc.ServiceName
+
+// CheckHealth asks the health service of a server whether the sensor service is serving.
+// Servers that do not implement the health service are taken as serving.
+func CheckHealth(ctx context.Context, client healthpb.HealthClient) error {
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: SensorServiceName})
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%w: %s", ErrNotServing, resp.GetStatus())
+	}
+
+	return nil
+}
+
+// ServerHealth is the result of the health check of a server. Err is nil when it is serving.
+type ServerHealth struct {
+	Server Endpoint
+	Err    error
+}
+
+// CheckServers checks the health of every server, in order.
+func CheckServers(ctx context.Context, servers []Endpoint, certOpts CertOpts) ([]ServerHealth, error) {
+	creds, err := certOpts.TransportCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if certOpts.PerRPCCredentials != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(certOpts.PerRPCCredentials))
+	}
+
+	results := make([]ServerHealth, 0, len(servers))
+	for _, server := range servers {
+		conn, err := grpc.NewClient(server.String(), opts...)
+		if err != nil {
+			results = append(results, ServerHealth{Server: server, Err: err})
+			continue
+		}
+
+		results = append(results, ServerHealth{Server: server, Err: CheckHealth(ctx, healthpb.NewHealthClient(conn))})
+		_ = conn.Close()
+	}
+
+	return results, nil
+}
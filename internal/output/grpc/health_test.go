@@ -0,0 +1,92 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startHealthServer serves the health service with the given status of the sensor service and returns its endpoint.
+func startHealthServer(t *testing.T, serving healthpb.HealthCheckResponse_ServingStatus) Endpoint {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(SensorServiceName, serving)
+
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+
+	addr := lis.Addr().(*net.TCPAddr)
+	return Endpoint{Host: addr.IP.String(), Port: addr.Port}
+}
+
+func Test_CheckServers(t *testing.T) {
+	serving := startHealthServer(t, healthpb.HealthCheckResponse_SERVING)
+	notServing := startHealthServer(t, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := CheckServers(ctx, []Endpoint{serving, notServing}, CertOpts{Insecure: true})
+	if err != nil {
+		t.Fatalf("CheckServers() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Server != serving || results[1].Server != notServing {
+		t.Fatalf("Expected a result per server in order, got %v", results)
+	}
+	if results[0].Err != nil {
+		t.Errorf("Expected a serving server to be healthy, got %v", results[0].Err)
+	}
+	if !errors.Is(results[1].Err, ErrNotServing) {
+		t.Errorf("Expected ErrNotServing, got %v", results[1].Err)
+	}
+}
+
+// fakeHealth reports a fixed health status.
+type fakeHealth struct {
+	healthpb.HealthClient
+	status healthpb.HealthCheckResponse_ServingStatus
+}
+
+func (f *fakeHealth) Check(_ context.Context, _ *healthpb.HealthCheckRequest, _ ...grpc.CallOption) (*healthpb.HealthCheckResponse, error) {
+	return &healthpb.HealthCheckResponse{Status: f.status}, nil
+}
+
+func Test_StreamManagerSkipsServersNotServing(t *testing.T) {
+	a, b := &fakeCollector{}, &fakeCollector{}
+	sm := &StreamManager{
+		endpoints: newEndpointPool(
+			&endpoint{addr: "a", client: a, health: &fakeHealth{status: healthpb.HealthCheckResponse_NOT_SERVING}},
+			&endpoint{addr: "b", client: b, health: &fakeHealth{status: healthpb.HealthCheckResponse_SERVING}},
+		),
+		streams: make(map[*ackStream]struct{}),
+		timeout: time.Minute,
+		retry:   DefaultRetryPolicy(),
+	}
+	sm.SetBalancePolicy(BalancePriority, DefaultEjectionPolicy())
+	defer sm.Close()
+
+	if _, err := sm.SendBulkEvent(context.Background(), []*pb.SensorEvent{{EventHashSha256: "hash", EventMetricsCount: 1}}); err != nil {
+		t.Fatalf("SendBulkEvent() error = %v", err)
+	}
+
+	if a.count() != 0 || b.count() != 1 {
+		t.Errorf("Expected the event on the serving server, got %d and %d", a.count(), b.count())
+	}
+}
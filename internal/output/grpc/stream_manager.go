@@ -2,76 +2,488 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"sync"
 	"time"
 
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/output"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
-// StreamManager wraps your gRPC stream and auto-closes it after a timeout.
-type StreamManager struct {
-	client  pb.SensorServiceClient
-	mu      sync.Mutex
-	stream  pb.SensorService_StreamDataClient
-	timer   *time.Timer
-	timeout time.Duration
+// ackStream is a single StreamDataWithAck call together with the events that
+// were sent on it and have not been acknowledged yet.
+type ackStream struct {
+	stream   pb.SensorService_StreamDataWithAckClient
+	cancel   context.CancelFunc
+	endpoint *endpoint
+	sendMu   sync.Mutex
+	mu       sync.Mutex
+	pending  map[string][]*pb.SensorEvent
+
+	// ended is set once the pending events have been drained, later events are not sent on the stream.
+	ended bool
 }
 
-// NewStreamManager creates a new StreamManager.
-func NewStreamManager(server string, port int, certOpts CertOpts, maxMessageSize int, timeout time.Duration) (*StreamManager, error) {
-	var creds credentials.TransportCredentials
-	var err error
+// errStreamEnded is returned when an event is sent on a stream whose pending events have been drained.
+var errStreamEnded = errors.New("stream has ended")
+
+// send registers the event as pending and sends it over the stream, stamping the time it is sent.
+func (s *ackStream) send(event *pb.SensorEvent) error {
+	event.EventSentAt = time.Now().UnixMicro()
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return errStreamEnded
+	}
+	s.pending[event.EventHashSha256] = append(s.pending[event.EventHashSha256], event)
+	s.mu.Unlock()
+
+	s.sendMu.Lock()
+	err := s.stream.Send(event)
+	s.sendMu.Unlock()
+
+	if err != nil {
+		// The caller keeps ownership of the event when sending fails.
+		s.take(event.EventHashSha256)
+	}
+
+	return err
+}
+
+// take removes and returns the oldest pending event with the given hash.
+func (s *ackStream) take(hash string) *pb.SensorEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := s.pending[hash]
+	if len(events) == 0 {
+		return nil
+	}
 
-	if certOpts.Insecure {
-		creds = insecure.NewCredentials()
+	event := events[0]
+	if len(events) == 1 {
+		delete(s.pending, hash)
 	} else {
-		creds, err = credentials.NewClientTLSFromFile(certOpts.CertFile, certOpts.ServerName)
-		if err != nil {
-			return nil, err
+		s.pending[hash] = events[1:]
+	}
+
+	return event
+}
+
+// drain removes and returns all pending events and ends the stream.
+func (s *ackStream) drain() []*pb.SensorEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ended = true
+
+	events := make([]*pb.SensorEvent, 0)
+	for _, pending := range s.pending {
+		events = append(events, pending...)
+	}
+	s.pending = make(map[string][]*pb.SensorEvent)
+
+	return events
+}
+
+// hasPending reports whether one of the events is pending.
+func (s *ackStream) hasPending(events map[*pb.SensorEvent]struct{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, pending := range s.pending {
+		for _, event := range pending {
+			if _, ok := events[event]; ok {
+				return true
+			}
 		}
 	}
 
-	conn, err := grpc.NewClient(
-		fmt.Sprintf("%s:%d", server, port),
+	return false
+}
+
+func (s *ackStream) pendingCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, pending := range s.pending {
+		count += len(pending)
+	}
+
+	return count
+}
+
+// ErrRetryBudgetExhausted is returned when a batch failed more often than the retry policy allows.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryPolicy controls how SendBulkEvent retries events that could not be sent.
+type RetryPolicy struct {
+	// InitialBackoff is the wait after the first failure, it grows by Multiplier up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter is the fraction of every wait that is randomized, so clients do not retry in lockstep.
+	Jitter float64
+
+	// MaxAttempts is the number of failures allowed per batch before giving up. Zero retries until the context is done.
+	MaxAttempts int
+}
+
+// DefaultRetryPolicy returns the retry policy of a new StreamManager.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxAttempts:    10,
+	}
+}
+
+// backoff returns the wait after the given number of consecutive failures.
+func (p RetryPolicy) backoff(failures int) time.Duration {
+	wait := float64(p.InitialBackoff)
+	for i := 1; i < failures && wait < float64(p.MaxBackoff); i++ {
+		wait *= p.Multiplier
+	}
+	wait = min(wait, float64(p.MaxBackoff))
+
+	// Spread the wait over [wait*(1-jitter), wait*(1+jitter)).
+	if p.Jitter > 0 {
+		wait *= 1 - p.Jitter + 2*p.Jitter*rand.Float64()
+	}
+
+	return time.Duration(wait)
+}
+
+// StreamManager wraps your gRPC stream and auto-closes it after a timeout.
+// Events stay in memory until the server acknowledges them, and events that were
+// not acknowledged when a stream ends are resent on a new stream, possibly to another server.
+type StreamManager struct {
+	endpoints *endpointPool
+	mu        sync.Mutex
+	connectMu sync.Mutex
+	stream    *ackStream
+	streams   map[*ackStream]struct{}
+	unacked   []*pb.SensorEvent
+	timer     *time.Timer
+	timeout   time.Duration
+	retry     RetryPolicy
+	onSent    SendObserver
+	onAcked   func(event *pb.SensorEvent)
+
+	// resendTimer resends the events of a stream that ended without waiting for the next batch.
+	resendTimer *time.Timer
+	closed      bool
+}
+
+// SendObserver is called with every event that was sent to a server.
+type SendObserver func(event *pb.SensorEvent)
+
+// roundRobinServiceConfig balances a stream over every address a server name resolves to.
+const roundRobinServiceConfig = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// NewStreamManager creates a new StreamManager sending to the given servers.
+func NewStreamManager(servers []Endpoint, certOpts CertOpts, maxMessageSize int, timeout time.Duration) (*StreamManager, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("no server configured")
+	}
+
+	creds, err := certOpts.TransportCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultServiceConfig(roundRobinServiceConfig),
 		grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(maxMessageSize*1024*1024),
 			grpc.MaxCallSendMsgSize(maxMessageSize*1024*1024),
 		),
-	)
-	if err != nil {
-		return nil, err
+	}
+	if certOpts.PerRPCCredentials != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(certOpts.PerRPCCredentials))
+	}
+
+	endpoints := make([]*endpoint, 0, len(servers))
+	for _, server := range servers {
+		conn, err := grpc.NewClient(server.String(), opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints = append(endpoints, &endpoint{
+			addr:   server.String(),
+			conn:   conn,
+			client: pb.NewSensorServiceClient(conn),
+			health: healthpb.NewHealthClient(conn),
+		})
 	}
 
 	return &StreamManager{
-		client:  pb.NewSensorServiceClient(conn),
-		timeout: timeout,
+		endpoints: newEndpointPool(endpoints...),
+		streams:   make(map[*ackStream]struct{}),
+		timeout:   timeout,
+		retry:     DefaultRetryPolicy(),
 	}, nil
 }
 
-// getStream returns an active stream. If none exists, it creates one.
-func (sm *StreamManager) getStream() (pb.SensorService_StreamDataClient, error) {
+// SetSendObserver sets the function called with every event that was sent.
+// It must be called before the first event is sent.
+func (sm *StreamManager) SetSendObserver(o SendObserver) {
+	sm.onSent = o
+}
+
+// SetAckObserver sets the function called with every event that the server acknowledged.
+func (sm *StreamManager) SetAckObserver(o func(event *pb.SensorEvent)) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if sm.stream != nil {
-		sm.resetTimer() // Reset the timeout on activity.
-		return sm.stream, nil
+	sm.onAcked = o
+}
+
+// checkHealth asks the health service of the collector whether it is serving.
+func (sm *StreamManager) checkHealth(e *endpoint) error {
+	if e.health == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	return CheckHealth(ctx, e.health)
+}
+
+// Healthy reports whether a server that is not ejected is connected.
+func (sm *StreamManager) Healthy() bool {
+	return sm.endpoints.healthy()
+}
+
+// SetBalancePolicy sets how streams are spread over the servers and when a failing server is ejected.
+func (sm *StreamManager) SetBalancePolicy(policy BalancePolicy, ejection EjectionPolicy) {
+	sm.endpoints.setPolicy(policy, ejection)
+}
+
+// SetRetryPolicy sets how SendBulkEvent retries events that could not be sent.
+func (sm *StreamManager) SetRetryPolicy(policy RetryPolicy) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.retry = policy
+}
+
+// getStream returns an active stream. If none exists, it creates one and
+// resends the events that were not acknowledged on previous streams.
+// The servers are contacted without holding sm.mu, so that sending and acknowledgements are not blocked.
+func (sm *StreamManager) getStream() (*ackStream, error) {
+	if s := sm.activeStream(); s != nil {
+		return s, nil
 	}
 
-	log.Infoln("Reconnecting to stream")
+	// Only one stream is opened at a time, and the unacknowledged events are resent before new ones.
+	sm.connectMu.Lock()
+	defer sm.connectMu.Unlock()
 
-	stream, err := sm.client.StreamData(context.Background())
+	if s := sm.activeStream(); s != nil {
+		return s, nil
+	}
+
+	s, err := sm.openStream()
 	if err != nil {
 		return nil, err
 	}
-	sm.stream = stream
+
+	sm.mu.Lock()
+	sm.streams[s] = struct{}{}
+	unacked := sm.unacked
+	sm.unacked = nil
+	sm.mu.Unlock()
+
+	go sm.receiveAcks(s)
+
+	if len(unacked) > 0 {
+		log.WithField("package", "grpc").Infof("Resending %d unacknowledged events", len(unacked))
+	}
+
+	for i, event := range unacked {
+		if err := s.send(event); err != nil {
+			sm.endpoints.failed(s.endpoint, err)
+			sm.abandonStream(s, unacked[i:])
+			return nil, err
+		}
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.stream = s
 	sm.resetTimer()
-	return sm.stream, nil
+	return s, nil
+}
+
+// activeStream returns the active stream and resets its timeout, or nil when there is none.
+func (sm *StreamManager) activeStream() *ackStream {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.stream != nil {
+		sm.resetTimer() // Reset the timeout on activity.
+	}
+
+	return sm.stream
+}
+
+// openStream opens a stream to the first candidate server that is healthy.
+func (sm *StreamManager) openStream() (*ackStream, error) {
+	var err error
+	for _, e := range sm.endpoints.candidates() {
+		log.WithField("server", e.addr).Infoln("Reconnecting to stream")
+
+		// A server that reports it cannot write to Kafka is skipped like one that cannot be reached.
+		if err = sm.checkHealth(e); err != nil {
+			sm.endpoints.failed(e, err)
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var stream pb.SensorService_StreamDataWithAckClient
+		if stream, err = e.client.StreamDataWithAck(ctx); err != nil {
+			cancel()
+			sm.endpoints.failed(e, err)
+			continue
+		}
+
+		return &ackStream{
+			stream:   stream,
+			cancel:   cancel,
+			endpoint: e,
+			pending:  make(map[string][]*pb.SensorEvent),
+		}, nil
+	}
+
+	return nil, err
+}
+
+// abandonStream closes a stream that failed while the unacknowledged events were resent,
+// and keeps its pending events and the ones that were not resent for the next stream.
+func (sm *StreamManager) abandonStream(s *ackStream, unsent []*pb.SensorEvent) {
+	if err := s.stream.CloseSend(); err != nil {
+		log.Errorln("Failed to close stream:", err)
+	}
+	s.cancel()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	delete(sm.streams, s)
+	unacked := append(s.drain(), unsent...)
+	sm.unacked = append(unacked, sm.unacked...)
+}
+
+// receiveAcks reads acknowledgements until the stream ends.
+// Negatively acknowledged events cause the stream to be dropped so they are
+// resent on a new stream, together with anything still pending when it ends.
+func (sm *StreamManager) receiveAcks(s *ackStream) {
+	for {
+		ack, err := s.stream.Recv()
+		if err != nil {
+			// A stream that was cancelled was closed on purpose.
+			if err != io.EOF && status.Code(err) != codes.Canceled {
+				log.WithFields(logger.Fields{
+					"package": "grpc",
+					"server":  s.endpoint.addr,
+				}).Warnf("Ack stream closed: %v", err)
+				sm.endpoints.failed(s.endpoint, err)
+			}
+			break
+		}
+
+		if ack.Success {
+			if event := s.take(ack.EventHashSha256); event != nil {
+				sm.mu.Lock()
+				onAcked := sm.onAcked
+				sm.mu.Unlock()
+
+				if onAcked != nil {
+					onAcked(event)
+				}
+			}
+			sm.endpoints.succeeded(s.endpoint)
+			continue
+		}
+
+		log.WithFields(logger.Fields{
+			"package": "grpc",
+			"hash":    ack.EventHashSha256,
+			"error":   ack.Error,
+		}).Warnln("Server failed to deliver event, it will be resent")
+
+		sm.endpoints.failed(s.endpoint, errors.New(ack.Error))
+		sm.dropStream(s)
+	}
+
+	s.cancel()
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.stream == s {
+		sm.stream = nil
+	}
+	delete(sm.streams, s)
+	sm.unacked = append(sm.unacked, s.drain()...)
+	sm.scheduleResend(1)
+}
+
+// scheduleResend resends the unacknowledged events on a new stream after the backoff of the retry
+// policy, and again after every failed attempt, until they are resent or the manager is closed.
+// It must be called with sm.mu held.
+func (sm *StreamManager) scheduleResend(attempt int) {
+	if sm.closed || sm.resendTimer != nil || len(sm.unacked) == 0 {
+		return
+	}
+
+	sm.resendTimer = time.AfterFunc(sm.retry.backoff(attempt), func() {
+		sm.mu.Lock()
+		sm.resendTimer = nil
+		sm.mu.Unlock()
+
+		sm.resendUnacked()
+
+		sm.mu.Lock()
+		defer sm.mu.Unlock()
+		if sm.stream == nil {
+			sm.scheduleResend(attempt + 1)
+		}
+	})
+}
+
+// dropStream closes the sending side of the stream if it is still the active one.
+func (sm *StreamManager) dropStream(s *ackStream) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.stream != s {
+		return
+	}
+
+	if err := s.stream.CloseSend(); err != nil {
+		log.Errorln("Failed to close stream:", err)
+	}
+	sm.stream = nil
 }
 
 // resetTimer resets the inactivity timer.
@@ -84,7 +496,7 @@ func (sm *StreamManager) resetTimer() {
 		defer sm.mu.Unlock()
 		log.Println("Timeout reached; closing stream")
 		if sm.stream != nil {
-			if err := sm.stream.CloseSend(); err != nil {
+			if err := sm.stream.stream.CloseSend(); err != nil {
 				log.Errorln("Failed to close stream on timeout:", err)
 			}
 			sm.stream = nil
@@ -98,43 +510,203 @@ func (sm *StreamManager) SendEvent(event *pb.SensorEvent) error {
 	if err != nil {
 		return err
 	}
-	if err := stream.Send(event); err != nil {
+	if err := stream.send(event); err != nil {
+		sm.endpoints.failed(stream.endpoint, err)
+
 		// If sending fails, close the stream so that it will be reestablished next time.
 		sm.mu.Lock()
-		sm.stream = nil
+		if sm.stream == stream {
+			sm.stream = nil
+		}
 		sm.mu.Unlock()
 		return err
 	}
+
+	if sm.onSent != nil {
+		sm.onSent(event)
+	}
 	return nil
 }
 
+// SendBulkEvent sends the events in order, backing off between failed attempts.
+// It returns the number of metrics sent. When the retry budget is exhausted or the context
+// is done, the error is an *output.UnsentError holding the events that were not sent.
 func (sm *StreamManager) SendBulkEvent(ctx context.Context, events []*pb.SensorEvent) (int64, error) {
+	sm.mu.Lock()
+	policy := sm.retry
+	sm.mu.Unlock()
+
 	totalEvents := int64(0)
+	failures, consecutive := 0, 0
 
 	for i := 0; i < len(events); {
-		select {
-		case <-ctx.Done():
-			return 0, ctx.Err()
-		default:
-			if err := sm.SendEvent(events[i]); err != nil {
-				continue
+		if ctx.Err() != nil {
+			return totalEvents, &output.UnsentError{Unsent: events[i:], Err: ctx.Err()}
+		}
+
+		if err := sm.SendEvent(events[i]); err != nil {
+			failures++
+			consecutive++
+			if policy.MaxAttempts > 0 && failures >= policy.MaxAttempts {
+				return totalEvents, &output.UnsentError{
+					Unsent: events[i:],
+					Err:    fmt.Errorf("%w after %d failures: %w", ErrRetryBudgetExhausted, failures, err),
+				}
+			}
+
+			wait := policy.backoff(consecutive)
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "grpc",
+				"attempt": failures,
+				"backoff": wait.String(),
+			}).Warnln("Failed to send event, retrying")
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
 			}
+			continue
+		}
+
+		consecutive = 0
+		totalEvents += events[i].EventMetricsCount
+		i++
+	}
 
-			totalEvents += events[i].EventMetricsCount
-			i++
+	// Round-robin opens the stream of the next batch to the next server.
+	if sm.endpoints.rotates() {
+		sm.mu.Lock()
+		s := sm.stream
+		sm.mu.Unlock()
+
+		if s != nil {
+			sm.dropStream(s)
 		}
 	}
 
 	return totalEvents, nil
 }
 
+// Flush resends the events that were not acknowledged and waits until the server has acknowledged
+// every event or the context is done. It returns the number of events still unacknowledged.
+func (sm *StreamManager) Flush(ctx context.Context) int {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		unacked := sm.GetUnackedEvents()
+		if unacked == 0 {
+			return 0
+		}
+
+		sm.resendUnacked()
+
+		select {
+		case <-ctx.Done():
+			return unacked
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitAcked resends the events that were not acknowledged and waits until the server has
+// acknowledged every given event. It returns the error of the context when it is done first.
+func (sm *StreamManager) WaitAcked(ctx context.Context, events []*pb.SensorEvent) error {
+	waiting := make(map[*pb.SensorEvent]struct{}, len(events))
+	for _, event := range events {
+		waiting[event] = struct{}{}
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if !sm.anyUnacked(waiting) {
+			return nil
+		}
+
+		sm.resendUnacked()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// anyUnacked reports whether one of the events waits for an acknowledgement or a resend.
+func (sm *StreamManager) anyUnacked(events map[*pb.SensorEvent]struct{}) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for _, event := range sm.unacked {
+		if _, ok := events[event]; ok {
+			return true
+		}
+	}
+	for s := range sm.streams {
+		if s.hasPending(events) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resendUnacked opens a new stream when the events of a stream that ended wait to be resent,
+// as they are only resent on a new stream.
+func (sm *StreamManager) resendUnacked() {
+	sm.mu.Lock()
+	resend := sm.stream == nil && len(sm.unacked) > 0
+	sm.mu.Unlock()
+	if !resend {
+		return
+	}
+
+	if _, err := sm.getStream(); err != nil {
+		log.WithField("package", "grpc").Debugf("Failed to resend unacknowledged events: %v", err)
+	}
+}
+
+// GetUnackedEvents retrieves the number of events waiting for an acknowledgement or a resend.
+func (sm *StreamManager) GetUnackedEvents() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	count := len(sm.unacked)
+	for s := range sm.streams {
+		count += s.pendingCount()
+	}
+
+	return count
+}
+
 func (sm *StreamManager) Close() {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+
+	sm.closed = true
+	if sm.resendTimer != nil {
+		sm.resendTimer.Stop()
+		sm.resendTimer = nil
+	}
 	if sm.stream != nil {
-		if err := sm.stream.CloseSend(); err != nil {
+		if err := sm.stream.stream.CloseSend(); err != nil {
 			log.Errorln("Failed to close stream:", err)
 		}
 		sm.stream = nil
 	}
+
+	unacked := len(sm.unacked)
+	for s := range sm.streams {
+		unacked += s.pendingCount()
+		s.cancel()
+	}
+	if unacked > 0 {
+		log.WithField("package", "grpc").Warnf("Closing with %d unacknowledged events", unacked)
+	}
 }
@@ -0,0 +1,263 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/output"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"google.golang.org/grpc"
+)
+
+type fakeAckClient struct {
+	pb.SensorService_StreamDataWithAckClient
+	sendErr error
+	sent    []*pb.SensorEvent
+}
+
+func (f *fakeAckClient) Send(event *pb.SensorEvent) error {
+	if f.sendErr != nil {
+		return f.sendErr
+	}
+	f.sent = append(f.sent, event)
+	return nil
+}
+
+func Test_AckStreamPending(t *testing.T) {
+	s := &ackStream{
+		stream:  &fakeAckClient{},
+		pending: make(map[string][]*pb.SensorEvent),
+	}
+
+	first := &pb.SensorEvent{EventHashSha256: "a", EventMetricsCount: 1}
+	second := &pb.SensorEvent{EventHashSha256: "a", EventMetricsCount: 2}
+	other := &pb.SensorEvent{EventHashSha256: "b", EventMetricsCount: 3}
+
+	for _, event := range []*pb.SensorEvent{first, second, other} {
+		if err := s.send(event); err != nil {
+			t.Fatalf("send() error = %v", err)
+		}
+	}
+
+	if s.pendingCount() != 3 {
+		t.Fatalf("Expected 3 pending events, got %d", s.pendingCount())
+	}
+
+	// Acks for the same hash are matched in the order the events were sent.
+	if got := s.take("a"); got != first {
+		t.Errorf("Expected first event to be acknowledged first, got %v", got)
+	}
+	if got := s.take("unknown"); got != nil {
+		t.Errorf("Expected nil for unknown hash, got %v", got)
+	}
+
+	remaining := s.drain()
+	if len(remaining) != 2 {
+		t.Errorf("Expected 2 unacknowledged events, got %d", len(remaining))
+	}
+	if s.pendingCount() != 0 {
+		t.Errorf("Expected no pending events after drain, got %d", s.pendingCount())
+	}
+}
+
+func Test_AckStreamSendFailure(t *testing.T) {
+	s := &ackStream{
+		stream:  &fakeAckClient{sendErr: errors.New("broken pipe")},
+		pending: make(map[string][]*pb.SensorEvent),
+	}
+
+	if err := s.send(&pb.SensorEvent{EventHashSha256: "a"}); err == nil {
+		t.Fatal("Expected send to fail")
+	}
+
+	if s.pendingCount() != 0 {
+		t.Errorf("Expected failed event not to be pending, got %d", s.pendingCount())
+	}
+}
+
+func Test_RetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.2}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: 100 * time.Millisecond},
+		{failures: 2, want: 200 * time.Millisecond},
+		{failures: 4, want: 800 * time.Millisecond},
+		{failures: 10, want: time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			got := policy.backoff(tt.failures)
+			if got < time.Duration(float64(tt.want)*0.8) || got > time.Duration(float64(tt.want)*1.2) {
+				t.Errorf("backoff(%d) = %s, want %s +/- 20%%", tt.failures, got, tt.want)
+			}
+		}
+	}
+}
+
+func Test_SendBulkEventRetryBudget(t *testing.T) {
+	client := &fakeSensorClient{err: errors.New("connection refused")}
+	sm := &StreamManager{
+		endpoints: newEndpointPool(&endpoint{addr: "collector:50051", client: client}),
+		streams:   make(map[*ackStream]struct{}),
+		timeout:   time.Minute,
+		retry:     RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 2, MaxAttempts: 3},
+	}
+
+	events := []*pb.SensorEvent{
+		{EventHashSha256: "a", EventMetricsCount: 1},
+		{EventHashSha256: "b", EventMetricsCount: 2},
+	}
+
+	total, err := sm.SendBulkEvent(context.Background(), events)
+	if total != 0 {
+		t.Errorf("Expected no sent events, got %d", total)
+	}
+
+	var unsent *output.UnsentError
+	if !errors.As(err, &unsent) || !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("Expected an UnsentError with an exhausted budget, got %v", err)
+	}
+	if len(unsent.Unsent) != 2 {
+		t.Errorf("Expected 2 unsent events, got %d", len(unsent.Unsent))
+	}
+	if calls := client.calls; calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+// fakeSensorClient fails to open streams.
+type fakeSensorClient struct {
+	pb.SensorServiceClient
+	err   error
+	calls int
+}
+
+func (f *fakeSensorClient) StreamDataWithAck(_ context.Context, _ ...grpc.CallOption) (pb.SensorService_StreamDataWithAckClient, error) {
+	f.calls++
+	return nil, f.err
+}
+
+func Test_SendEventObserver(t *testing.T) {
+	sm := &StreamManager{
+		endpoints: newEndpointPool(&endpoint{addr: "collector:50051", client: &fakeCollector{}}),
+		streams:   make(map[*ackStream]struct{}),
+		timeout:   time.Minute,
+		retry:     DefaultRetryPolicy(),
+	}
+	defer sm.Close()
+
+	var observed []*pb.SensorEvent
+	sm.SetSendObserver(func(event *pb.SensorEvent) {
+		observed = append(observed, event)
+	})
+
+	before := time.Now().UnixMicro()
+	event := &pb.SensorEvent{EventHashSha256: "a", EventMetricsCount: 1, EventReadAt: before}
+	if err := sm.SendEvent(event); err != nil {
+		t.Fatalf("SendEvent() error = %v", err)
+	}
+
+	if len(observed) != 1 || observed[0] != event {
+		t.Fatalf("Expected the sent event to be observed once, got %v", observed)
+	}
+	if event.EventSentAt < before {
+		t.Errorf("Expected the event to be stamped with the time it was sent, got %d before %d", event.EventSentAt, before)
+	}
+}
+
+func Test_WaitAcked(t *testing.T) {
+	sm := &StreamManager{
+		endpoints: newEndpointPool(&endpoint{addr: "collector:50051", client: &fakeCollector{}}),
+		streams:   make(map[*ackStream]struct{}),
+		timeout:   time.Minute,
+		retry:     DefaultRetryPolicy(),
+	}
+	defer sm.Close()
+
+	// An event that waits for a resend is not acknowledged yet.
+	waiting := &pb.SensorEvent{EventHashSha256: "a", EventMetricsCount: 1}
+	sm.unacked = []*pb.SensorEvent{waiting}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sm.WaitAcked(ctx, []*pb.SensorEvent{waiting}); err != nil {
+		t.Fatalf("WaitAcked() error = %v", err)
+	}
+	if got := sm.GetUnackedEvents(); got != 0 {
+		t.Errorf("Expected no unacknowledged events, got %d", got)
+	}
+}
+
+func Test_StreamManagerResendsAfterStreamDrop(t *testing.T) {
+	collector := &fakeCollector{dropAfter: 2}
+	sm := &StreamManager{
+		endpoints: newEndpointPool(&endpoint{addr: "collector:50051", client: collector}),
+		streams:   make(map[*ackStream]struct{}),
+		timeout:   time.Minute,
+		retry:     RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond, Multiplier: 2},
+	}
+	defer sm.Close()
+
+	// The stream ends after the second event of the batch, before any of them is acknowledged.
+	events := []*pb.SensorEvent{
+		{EventHashSha256: "a", EventMetricsCount: 1},
+		{EventHashSha256: "b", EventMetricsCount: 1},
+	}
+	if _, err := sm.SendBulkEvent(context.Background(), events); err != nil {
+		t.Fatalf("SendBulkEvent() error = %v", err)
+	}
+
+	// No other batch is sent, the events are resent on a new stream on their own.
+	deadline := time.Now().Add(5 * time.Second)
+	for sm.GetUnackedEvents() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := sm.GetUnackedEvents(); got != 0 {
+		t.Fatalf("Expected every event to be redelivered and acknowledged, got %d unacknowledged", got)
+	}
+	if got := collector.count(); got != 4 {
+		t.Errorf("Expected both events to be received twice, got %d events", got)
+	}
+}
+
+func Test_StreamManagerAbandonsStreamWhenResendFails(t *testing.T) {
+	collector := &fakeCollector{failAfter: 1}
+	sm := &StreamManager{
+		endpoints: newEndpointPool(&endpoint{addr: "collector:50051", client: collector}),
+		streams:   make(map[*ackStream]struct{}),
+		timeout:   time.Minute,
+		retry:     DefaultRetryPolicy(),
+	}
+	defer sm.Close()
+
+	// The first event is resent, sending the second one fails.
+	unsent := &pb.SensorEvent{EventHashSha256: "b", EventMetricsCount: 1}
+	sm.unacked = []*pb.SensorEvent{{EventHashSha256: "a", EventMetricsCount: 1}, unsent}
+	if _, err := sm.getStream(); err == nil {
+		t.Fatal("Expected getStream() to fail when the resend fails")
+	}
+
+	stream := collector.opened[0]
+	select {
+	case <-stream.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stream to be cancelled")
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if len(sm.streams) != 0 || sm.stream != nil {
+		t.Errorf("Expected the stream to be removed, got %d streams", len(sm.streams))
+	}
+	if !slices.Contains(sm.unacked, unsent) {
+		t.Errorf("Expected the event that was not resent to wait for the next stream, got %v", sm.unacked)
+	}
+}
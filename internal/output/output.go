@@ -2,6 +2,7 @@ package output
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
 )
@@ -10,3 +11,18 @@ type MessengerInterface interface {
 	StreamData(ctx context.Context, payload *pb.SensorEvent) error
 	Disconnect()
 }
+
+// UnsentError is returned by a batch sender that gave up before the whole batch was sent.
+// Unsent holds the events that were not sent, in the order of the batch, so they can be queued again.
+type UnsentError struct {
+	Unsent []*pb.SensorEvent
+	Err    error
+}
+
+func (e *UnsentError) Error() string {
+	return fmt.Sprintf("%d events not sent: %v", len(e.Unsent), e.Err)
+}
+
+func (e *UnsentError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
+)
+
+// Supported alert formats.
+const (
+	// FormatSnortJSON is the Snort 3 alert_json format.
+	FormatSnortJSON = "snort"
+
+	// FormatSuricataEVE is the Suricata EVE JSON format.
+	FormatSuricataEVE = "suricata"
+
+	// FormatSnortFast is the Snort 2 and Snort 3 alert_fast format.
+	FormatSnortFast = "fast"
+
+	// FormatSnortFull is the multi-line Snort 2 and Snort 3 alert_full format.
+	FormatSnortFull = "full"
+)
+
+// ErrSkipRecord is returned for well-formed records that do not carry an alert.
+var ErrSkipRecord = errors.New("record does not contain an alert")
+
+// ErrIncompleteRecord is returned by multi-line parsers while the alert is not complete yet.
+var ErrIncompleteRecord = errors.New("record is incomplete")
+
+// Parser converts a single raw alert record into a SnortAlert.
+type Parser interface {
+	Parse(record []byte) (*types.SnortAlert, error)
+}
+
+// Stateful is implemented by parsers that assemble an alert from several records.
+type Stateful interface {
+	Parser
+
+	// Fork returns a new instance of the parser for another input stream.
+	Fork() Parser
+}
+
+// ForStream returns the parser to use for a single input stream, so that the
+// lines of concurrent streams are not mixed up by stateful parsers.
+func ForStream(p Parser) Parser {
+	if s, ok := p.(Stateful); ok {
+		return s.Fork()
+	}
+
+	return p
+}
+
+// New returns the parser for the given format.
+func New(format string) (Parser, error) {
+	switch format {
+	case FormatSnortJSON, "":
+		return &SnortJSONParser{}, nil
+	case FormatSuricataEVE:
+		return &SuricataEVEParser{}, nil
+	case FormatSnortFast:
+		return &SnortFastParser{}, nil
+	case FormatSnortFull:
+		return &SnortFullParser{}, nil
+	default:
+		return nil, fmt.Errorf("unknown alert format: %q (valid values: %s, %s, %s, %s)", format,
+			FormatSnortJSON, FormatSuricataEVE, FormatSnortFast, FormatSnortFull)
+	}
+}
@@ -0,0 +1,436 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
+)
+
+func toPtr[T any](d T) *T {
+	return &d
+}
+
+func Test_New(t *testing.T) {
+	tests := []struct {
+		format  string
+		want    Parser
+		wantErr bool
+	}{
+		{format: "", want: &SnortJSONParser{}},
+		{format: FormatSnortJSON, want: &SnortJSONParser{}},
+		{format: FormatSuricataEVE, want: &SuricataEVEParser{}},
+		{format: FormatSnortFast, want: &SnortFastParser{}},
+		{format: "unified2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := New(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !cmp.Equal(got, tt.want) {
+				t.Errorf("New() = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_SnortJSONParser(t *testing.T) {
+	record := `{"seconds":1728513131,"action":"allow","class":"A Network Trojan was detected","dir":"C2S","dst_addr":"206.54.163.50","dst_port":80,"gid":1,"iface":"eth0","msg":"PUA-ADWARE Js.Adware.Agent variant redirect attempt","priority":1,"proto":"TCP","rev":1,"rule":"1:54307:1","sid":54307,"src_addr":"192.168.10.15","src_port":55922,"timestamp":"24/10/10-05:32:11.000107"}`
+
+	got, err := (&SnortJSONParser{}).Parse([]byte(record))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := &types.SnortAlert{
+		Action:         toPtr("allow"),
+		Classification: toPtr("A Network Trojan was detected"),
+		Direction:      toPtr("C2S"),
+		DstAddr:        toPtr("206.54.163.50"),
+		DstPort:        toPtr(int64(80)),
+		GID:            1,
+		Interface:      "eth0",
+		Message:        "PUA-ADWARE Js.Adware.Agent variant redirect attempt",
+		Priority:       1,
+		Protocol:       "TCP",
+		Revision:       1,
+		RuleID:         "1:54307:1",
+		Seconds:        1728513131,
+		SID:            54307,
+		SrcAddr:        toPtr("192.168.10.15"),
+		SrcPort:        toPtr(int64(55922)),
+		Timestamp:      "24/10/10-05:32:11.000107",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Parse() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := (&SnortJSONParser{}).Parse([]byte("not json")); err == nil {
+		t.Error("Expected an error for an invalid record")
+	}
+}
+
+func Test_SuricataEVEParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  string
+		want    *types.SnortAlert
+		wantErr error
+	}{
+		{
+			name:   "Must map an alert record onto a SnortAlert",
+			record: `{"timestamp":"2024-10-10T05:32:11.000107+0000","flow_id":1447185581458224,"in_iface":"eth0","event_type":"alert","vlan":[110],"src_ip":"192.168.10.15","src_port":55922,"dest_ip":"206.54.163.50","dest_port":80,"proto":"TCP","pkt_src":"wire/pcap","ether":{"src_mac":"70:f3:5a:42:73:e8","dest_mac":"90:b1:1c:a2:c0:d3"},"tx_id":0,"alert":{"action":"allowed","gid":1,"signature_id":2027865,"rev":3,"signature":"ET INFO Observed DNS Query to .cloud TLD","category":"Potentially Bad Traffic","severity":2},"app_proto":"http","direction":"to_server","flow":{"pkts_toserver":3,"pkts_toclient":2,"bytes_toserver":455,"bytes_toclient":120,"start":"2024-10-10T05:32:10.998000+0000"},"payload":"R0VUIC8gSFRUUC8xLjENCg=="}`,
+			want: &types.SnortAlert{
+				Action:         toPtr("allow"),
+				Base64Data:     toPtr("R0VUIC8gSFRUUC8xLjENCg=="),
+				Classification: toPtr("Potentially Bad Traffic"),
+				ClientBytes:    toPtr(int64(455)),
+				ClientPkts:     toPtr(int64(3)),
+				Direction:      toPtr("C2S"),
+				DstAddr:        toPtr("206.54.163.50"),
+				DstAp:          toPtr("206.54.163.50:80"),
+				DstPort:        toPtr(int64(80)),
+				EthDst:         toPtr("90:B1:1C:A2:C0:D3"),
+				EthSrc:         toPtr("70:F3:5A:42:73:E8"),
+				FlowStartTime:  toPtr(int64(1728538330)),
+				GID:            1,
+				Interface:      "eth0",
+				Message:        "ET INFO Observed DNS Query to .cloud TLD",
+				PktGen:         toPtr("wire/pcap"),
+				Priority:       2,
+				Protocol:       "TCP",
+				Revision:       3,
+				RuleID:         "1:2027865:3",
+				Seconds:        1728538331,
+				ServerBytes:    toPtr(int64(120)),
+				ServerPkts:     toPtr(int64(2)),
+				Service:        toPtr("http"),
+				SID:            2027865,
+				SrcAddr:        toPtr("192.168.10.15"),
+				SrcAp:          toPtr("192.168.10.15:55922"),
+				SrcPort:        toPtr(int64(55922)),
+				Timestamp:      "24/10/10-05:32:11.000107",
+				VLAN:           toPtr(int64(110)),
+			},
+		},
+		{
+			name:    "Must skip non-alert records",
+			record:  `{"timestamp":"2024-10-10T05:32:11.000107+0000","event_type":"flow","src_ip":"192.168.10.15","dest_ip":"206.54.163.50","proto":"TCP"}`,
+			wantErr: ErrSkipRecord,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&SuricataEVEParser{}).Parse([]byte(tt.record))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Parse() error = %v, want %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Parse() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// localSeconds returns the epoch seconds of a wall clock time in the local time zone, as Snort writes it.
+func localSeconds(year int, month time.Month, day, hour, minute, sec int) int64 {
+	return time.Date(year, month, day, hour, minute, sec, 0, time.Local).Unix()
+}
+
+func Test_SnortFastParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  string
+		want    *types.SnortAlert
+		wantErr bool
+	}{
+		{
+			name:   "Snort 2 alert_fast with year",
+			record: "10/09/24-22:32:11.000107  [**] [1:2000419:18] ET POLICY PE EXE or DLL Windows file download [**] [Classification: Potential Corporate Privacy Violation] [Priority: 1] {TCP} 206.54.163.50:80 -> 192.168.10.15:55922",
+			want: &types.SnortAlert{
+				Classification: toPtr("Potential Corporate Privacy Violation"),
+				DstAddr:        toPtr("192.168.10.15"),
+				DstAp:          toPtr("192.168.10.15:55922"),
+				DstPort:        toPtr(int64(55922)),
+				GID:            1,
+				Message:        "ET POLICY PE EXE or DLL Windows file download",
+				Priority:       1,
+				Protocol:       "TCP",
+				Revision:       18,
+				RuleID:         "1:2000419:18",
+				Seconds:        localSeconds(2024, time.October, 9, 22, 32, 11),
+				SID:            2000419,
+				SrcAddr:        toPtr("206.54.163.50"),
+				SrcAp:          toPtr("206.54.163.50:80"),
+				SrcPort:        toPtr(int64(80)),
+				Timestamp:      "24/10/09-22:32:11.000107",
+			},
+		},
+		{
+			name:   "Snort 3 alert_fast with AppID",
+			record: `24/10/09-22:32:11.000107 [**] [1:54307:1] "PUA-ADWARE Js.Adware.Agent variant redirect attempt" [**] [Classification: A Network Trojan was detected] [Priority: 1] [AppID: HTTP] {TCP} 192.168.10.15:55922 -> 206.54.163.50:80`,
+			want: &types.SnortAlert{
+				Classification: toPtr("A Network Trojan was detected"),
+				DstAddr:        toPtr("206.54.163.50"),
+				DstAp:          toPtr("206.54.163.50:80"),
+				DstPort:        toPtr(int64(80)),
+				GID:            1,
+				Message:        "PUA-ADWARE Js.Adware.Agent variant redirect attempt",
+				Priority:       1,
+				Protocol:       "TCP",
+				Revision:       1,
+				RuleID:         "1:54307:1",
+				Seconds:        localSeconds(2024, time.October, 9, 22, 32, 11),
+				Service:        toPtr("http"),
+				SID:            54307,
+				SrcAddr:        toPtr("192.168.10.15"),
+				SrcAp:          toPtr("192.168.10.15:55922"),
+				SrcPort:        toPtr(int64(55922)),
+				Timestamp:      "24/10/09-22:32:11.000107",
+			},
+		},
+		{
+			name:   "Snort 3 alert_fast with action and without classification",
+			record: `24/10/09-22:32:11.000107 [drop] [**] [116:414:1] "(ipv4) IPv4 option set" [**] [Priority: 3] {ICMP} 10.0.0.1 -> 10.0.0.2`,
+			want: &types.SnortAlert{
+				Action:    toPtr("drop"),
+				DstAddr:   toPtr("10.0.0.2"),
+				DstAp:     toPtr("10.0.0.2"),
+				GID:       116,
+				Message:   "(ipv4) IPv4 option set",
+				Priority:  3,
+				Protocol:  "ICMP",
+				Revision:  1,
+				RuleID:    "116:414:1",
+				Seconds:   localSeconds(2024, time.October, 9, 22, 32, 11),
+				SID:       414,
+				SrcAddr:   toPtr("10.0.0.1"),
+				SrcAp:     toPtr("10.0.0.1"),
+				Timestamp: "24/10/09-22:32:11.000107",
+			},
+		},
+		{
+			name:   "Snort 2 alert_fast with IPv6 endpoints",
+			record: "10/09/24-22:32:11.000107  [**] [1:2000:1] IPv6 test [**] [Priority: 0] {IPV6-ICMP} fe80::1 -> ff02::1",
+			want: &types.SnortAlert{
+				DstAddr:   toPtr("ff02::1"),
+				DstAp:     toPtr("ff02::1"),
+				GID:       1,
+				Message:   "IPv6 test",
+				Protocol:  "IPV6-ICMP",
+				Revision:  1,
+				RuleID:    "1:2000:1",
+				Seconds:   localSeconds(2024, time.October, 9, 22, 32, 11),
+				SID:       2000,
+				SrcAddr:   toPtr("fe80::1"),
+				SrcAp:     toPtr("fe80::1"),
+				Timestamp: "24/10/09-22:32:11.000107",
+			},
+		},
+		{
+			name:   "Snort alert_syslog body without timestamp",
+			record: "[1:2000:3] ET POLICY test [Classification: Misc activity] [Priority: 3] {UDP} 10.0.0.1:53 -> 10.0.0.2:5353",
+			want: &types.SnortAlert{
+				Classification: toPtr("Misc activity"),
+				DstAddr:        toPtr("10.0.0.2"),
+				DstAp:          toPtr("10.0.0.2:5353"),
+				DstPort:        toPtr(int64(5353)),
+				GID:            1,
+				Message:        "ET POLICY test",
+				Priority:       3,
+				Protocol:       "UDP",
+				Revision:       3,
+				RuleID:         "1:2000:3",
+				SID:            2000,
+				SrcAddr:        toPtr("10.0.0.1"),
+				SrcAp:          toPtr("10.0.0.1:53"),
+				SrcPort:        toPtr(int64(53)),
+			},
+		},
+		{
+			name:    "Must reject a line that is not an alert",
+			record:  "Commencing packet processing",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&SnortFastParser{}).Parse([]byte(tt.record))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Parse() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_SnortFastParserWithoutYear(t *testing.T) {
+	got, err := (&SnortFastParser{}).Parse([]byte("01/02-03:04:05.000006  [**] [1:2000:1] test [**] [Priority: 0] {TCP} 10.0.0.1:1 -> 10.0.0.2:2"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	ts := time.Unix(got.Seconds, 0)
+	if ts.Month() != time.January || ts.Day() != 2 || ts.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("Unexpected timestamp %s", ts)
+	}
+	if !strings.HasSuffix(got.Timestamp, "/01/02-03:04:05.000006") {
+		t.Errorf("Unexpected timestamp %q", got.Timestamp)
+	}
+}
+
+func Test_SnortFullParser(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines string
+		want  []*types.SnortAlert
+	}{
+		{
+			name: "Snort 2 alert_full",
+			lines: `[**] [1:2000419:18] ET POLICY PE EXE or DLL Windows file download [**]
+[Classification: Potential Corporate Privacy Violation] [Priority: 1]
+10/09/24-22:32:11.000107 206.54.163.50:80 -> 192.168.10.15:55922
+TCP TTL:64 TOS:0x0 ID:4660 IpLen:20 DgmLen:45 DF
+***AP*** Seq: 0x3E8  Ack: 0x7D0  Win: 0x1F6  TcpLen: 20
+[Xref => http://doc.emergingthreats.net/bin/view/Main/2000419]
+
+[**] [1:2100366:8] GPL ICMP_INFO PING *NIX [**]
+[Classification: Misc activity] [Priority: 3]
+10/09/24-22:32:12.000000 10.0.0.1 -> 10.0.0.2
+ICMP TTL:64 TOS:0x0 ID:0 IpLen:20 DgmLen:84 DF
+Type:8  Code:0  ID:1   Seq:1  ECHO
+
+`,
+			want: []*types.SnortAlert{
+				{
+					Classification: toPtr("Potential Corporate Privacy Violation"),
+					DstAddr:        toPtr("192.168.10.15"),
+					DstAp:          toPtr("192.168.10.15:55922"),
+					DstPort:        toPtr(int64(55922)),
+					GID:            1,
+					IPID:           toPtr(int64(4660)),
+					IPLen:          toPtr(int64(45)),
+					Message:        "ET POLICY PE EXE or DLL Windows file download",
+					Priority:       1,
+					Protocol:       "TCP",
+					Revision:       18,
+					RuleID:         "1:2000419:18",
+					Seconds:        localSeconds(2024, time.October, 9, 22, 32, 11),
+					SID:            2000419,
+					SrcAddr:        toPtr("206.54.163.50"),
+					SrcAp:          toPtr("206.54.163.50:80"),
+					SrcPort:        toPtr(int64(80)),
+					TCPAck:         toPtr(int64(2000)),
+					TCPFlags:       toPtr("***AP***"),
+					TCPLen:         toPtr(int64(20)),
+					TCPSeq:         toPtr(int64(1000)),
+					TCPWin:         toPtr(int64(502)),
+					Timestamp:      "24/10/09-22:32:11.000107",
+					TOS:            toPtr(int64(0)),
+					TTL:            toPtr(int64(64)),
+				},
+				{
+					Classification: toPtr("Misc activity"),
+					DstAddr:        toPtr("10.0.0.2"),
+					DstAp:          toPtr("10.0.0.2"),
+					GID:            1,
+					ICMPCode:       toPtr(int64(0)),
+					ICMPID:         toPtr(int64(1)),
+					ICMPSeq:        toPtr(int64(1)),
+					ICMPType:       toPtr(int64(8)),
+					IPID:           toPtr(int64(0)),
+					IPLen:          toPtr(int64(84)),
+					Message:        "GPL ICMP_INFO PING *NIX",
+					Priority:       3,
+					Protocol:       "ICMP",
+					Revision:       8,
+					RuleID:         "1:2100366:8",
+					Seconds:        localSeconds(2024, time.October, 9, 22, 32, 12),
+					SID:            2100366,
+					SrcAddr:        toPtr("10.0.0.1"),
+					SrcAp:          toPtr("10.0.0.1"),
+					Timestamp:      "24/10/09-22:32:12.000000",
+					TOS:            toPtr(int64(0)),
+					TTL:            toPtr(int64(64)),
+				},
+			},
+		},
+		{
+			name: "Snort 3 alert_full with Ethernet header",
+			lines: `[**] [1:54307:1] "PUA-ADWARE Js.Adware.Agent variant redirect attempt" [**]
+[Classification: A Network Trojan was detected] [Priority: 1]
+[AppID: HTTP]
+24/10/09-22:32:11.000107 70:F3:5A:42:73:E8 -> 90:B1:1C:A2:C0:D3 type:0x800 len:0x5EA
+192.168.10.15:55922 -> 206.54.163.50:80 TCP TTL:128 TOS:0x0 ID:24094 IpLen:20 DgmLen:1500 DF
+***A**** Seq:0x9D7C6D5A  Ack:0x4AF1E8A3  Win:0x201  TcpLen:20
+
+`,
+			want: []*types.SnortAlert{
+				{
+					Classification: toPtr("A Network Trojan was detected"),
+					DstAddr:        toPtr("206.54.163.50"),
+					DstAp:          toPtr("206.54.163.50:80"),
+					DstPort:        toPtr(int64(80)),
+					EthDst:         toPtr("90:B1:1C:A2:C0:D3"),
+					EthLen:         toPtr(int64(1514)),
+					EthSrc:         toPtr("70:F3:5A:42:73:E8"),
+					EthType:        toPtr("0x800"),
+					GID:            1,
+					IPID:           toPtr(int64(24094)),
+					IPLen:          toPtr(int64(1500)),
+					Message:        "PUA-ADWARE Js.Adware.Agent variant redirect attempt",
+					Priority:       1,
+					Protocol:       "TCP",
+					Revision:       1,
+					RuleID:         "1:54307:1",
+					Seconds:        localSeconds(2024, time.October, 9, 22, 32, 11),
+					Service:        toPtr("http"),
+					SID:            54307,
+					SrcAddr:        toPtr("192.168.10.15"),
+					SrcAp:          toPtr("192.168.10.15:55922"),
+					SrcPort:        toPtr(int64(55922)),
+					TCPAck:         toPtr(int64(0x4AF1E8A3)),
+					TCPFlags:       toPtr("***A****"),
+					TCPLen:         toPtr(int64(20)),
+					TCPSeq:         toPtr(int64(0x9D7C6D5A)),
+					TCPWin:         toPtr(int64(0x201)),
+					Timestamp:      "24/10/09-22:32:11.000107",
+					TOS:            toPtr(int64(0)),
+					TTL:            toPtr(int64(128)),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := ForStream(&SnortFullParser{}).(*SnortFullParser)
+			if !ok {
+				t.Fatal("ForStream() must return a SnortFullParser")
+			}
+
+			var got []*types.SnortAlert
+			for _, line := range strings.Split(tt.lines, "\n") {
+				alert, err := p.Parse([]byte(line))
+				if errors.Is(err, ErrIncompleteRecord) || errors.Is(err, ErrSkipRecord) {
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Parse(%q) error = %v", line, err)
+				}
+				got = append(got, alert)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Parse() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
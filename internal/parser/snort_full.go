@@ -0,0 +1,193 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
+)
+
+var (
+	// fullTimestampPattern matches the line starting with the timestamp, followed either by the
+	// MAC addresses ("70:F3:... -> 90:B1:... type:0x800 len:0x3B") or by the endpoints.
+	fullTimestampPattern = regexp.MustCompile(`^(\d{2}/\d{2}(?:/\d{2})?-\d{2}:\d{2}:\d{2}(?:\.\d+)?)\s+(.*)$`)
+
+	fullEthPattern = regexp.MustCompile(`^([0-9A-Fa-f:]{17})\s+->\s+([0-9A-Fa-f:]{17})\s+type:(0x[0-9A-Fa-f]+)\s+len:(0x[0-9A-Fa-f]+)`)
+
+	// fullEndpointsPattern matches "a:p -> b:p", optionally followed by the IP header line.
+	fullEndpointsPattern = regexp.MustCompile(`^(\S+)\s+->\s+(\S+)\s*(.*)$`)
+
+	fullIPHeaderPattern = regexp.MustCompile(`^(TCP|UDP|ICMP|IP|PROTO:\d+)\s+TTL:`)
+
+	fullTCPFlagsPattern = regexp.MustCompile(`^[*12CEUAPRSF]{8}\s`)
+
+	fullFieldPattern = regexp.MustCompile(`(\w+):\s*(\S+)`)
+)
+
+// SnortFullParser parses records written by the Snort 2 and Snort 3 alert_full loggers.
+// An alert_full record spans several lines and ends with an empty line, so the parser
+// keeps the lines of the current record and returns ErrIncompleteRecord until it is complete.
+// Every input stream needs its own instance, see ForStream.
+type SnortFullParser struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// Fork returns a new parser without the buffered lines.
+func (p *SnortFullParser) Fork() Parser {
+	return &SnortFullParser{}
+}
+
+// Parse adds a line to the current record and returns the alert once the record is complete.
+func (p *SnortFullParser) Parse(record []byte) (*types.SnortAlert, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	line := strings.TrimRight(string(record), "\r\n")
+
+	switch {
+	case strings.TrimSpace(line) == "":
+		if len(p.lines) == 0 {
+			return nil, ErrSkipRecord
+		}
+		return p.flush()
+	case strings.HasPrefix(line, "[**]"):
+		// A new record also completes a previous one that was not followed by an empty line.
+		if len(p.lines) > 0 {
+			alert, err := p.flush()
+			p.lines = append(p.lines, line)
+			return alert, err
+		}
+		p.lines = append(p.lines, line)
+		return nil, ErrIncompleteRecord
+	case len(p.lines) == 0:
+		return nil, fmt.Errorf("unexpected line outside of a full alert: %q", line)
+	default:
+		p.lines = append(p.lines, line)
+		return nil, ErrIncompleteRecord
+	}
+}
+
+func (p *SnortFullParser) flush() (*types.SnortAlert, error) {
+	lines := p.lines
+	p.lines = nil
+
+	return parseFullRecord(lines)
+}
+
+// parseFullRecord parses the lines of a single alert_full record.
+func parseFullRecord(lines []string) (*types.SnortAlert, error) {
+	// The header lines carry the same information as a fast alert.
+	var header []string
+	var timestamp string
+	rest := lines
+	for len(rest) > 0 && strings.HasPrefix(rest[0], "[") {
+		header = append(header, strings.TrimSpace(rest[0]))
+		rest = rest[1:]
+	}
+
+	var details []string
+	for _, line := range rest {
+		line = strings.TrimSpace(line)
+		if m := fullTimestampPattern.FindStringSubmatch(line); m != nil && timestamp == "" {
+			timestamp = m[1]
+			line = m[2]
+		}
+		details = append(details, line)
+	}
+
+	fast := strings.Join(header, " ")
+	if timestamp != "" {
+		fast = timestamp + " " + fast
+	}
+
+	alert, err := parseFastLine(fast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid full alert: %w", err)
+	}
+
+	for _, line := range details {
+		switch {
+		case strings.HasPrefix(line, "[Xref"):
+		case fullEthPattern.MatchString(line):
+			m := fullEthPattern.FindStringSubmatch(line)
+			alert.EthSrc = toUpperPtr(m[1])
+			alert.EthDst = toUpperPtr(m[2])
+			alert.EthType = &m[3]
+			alert.EthLen = parseIntPtr(m[4])
+		case fullIPHeaderPattern.MatchString(line):
+			setIPHeader(alert, line)
+		case fullTCPFlagsPattern.MatchString(line):
+			flags := line[:8]
+			alert.TCPFlags = &flags
+			fields := parseFullFields(line[8:])
+			alert.TCPSeq = parseIntPtr(fields["Seq"])
+			alert.TCPAck = parseIntPtr(fields["Ack"])
+			alert.TCPWin = parseIntPtr(fields["Win"])
+			alert.TCPLen = parseIntPtr(fields["TcpLen"])
+		case strings.HasPrefix(line, "Len:"):
+			alert.UDPLen = parseIntPtr(parseFullFields(line)["Len"])
+		case strings.HasPrefix(line, "Type:"):
+			fields := parseFullFields(line)
+			alert.ICMPType = parseIntPtr(fields["Type"])
+			alert.ICMPCode = parseIntPtr(fields["Code"])
+			alert.ICMPID = parseIntPtr(fields["ID"])
+			alert.ICMPSeq = parseIntPtr(fields["Seq"])
+		case fullEndpointsPattern.MatchString(line):
+			m := fullEndpointsPattern.FindStringSubmatch(line)
+			setEndpoints(alert, m[1], m[2])
+			if fullIPHeaderPattern.MatchString(m[3]) {
+				setIPHeader(alert, m[3])
+			}
+		}
+	}
+
+	return alert, nil
+}
+
+// setIPHeader parses "TCP TTL:64 TOS:0x0 ID:4660 IpLen:20 DgmLen:45 DF".
+func setIPHeader(alert *types.SnortAlert, line string) {
+	proto, _, _ := strings.Cut(line, " ")
+	if alert.Protocol == "" && !strings.HasPrefix(proto, "PROTO:") {
+		alert.Protocol = proto
+	}
+
+	fields := parseFullFields(line)
+	alert.TTL = parseIntPtr(fields["TTL"])
+	alert.TOS = parseIntPtr(fields["TOS"])
+	alert.IPID = parseIntPtr(fields["ID"])
+	alert.IPLen = parseIntPtr(fields["DgmLen"])
+}
+
+// parseFullFields returns the "Name:value" fields of a line. Snort 2 writes a space after some colons.
+func parseFullFields(line string) map[string]string {
+	fields := make(map[string]string)
+	for _, m := range fullFieldPattern.FindAllStringSubmatch(line, -1) {
+		if _, ok := fields[m[1]]; !ok {
+			fields[m[1]] = m[2]
+		}
+	}
+
+	return fields
+}
+
+// parseIntPtr parses a decimal or 0x prefixed hexadecimal number, returning nil when it is missing or invalid.
+func parseIntPtr(s string) *int64 {
+	if s == "" {
+		return nil
+	}
+
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return nil
+	}
+
+	return &v
+}
+
+func toUpperPtr(s string) *string {
+	return upperPtr(&s)
+}
@@ -0,0 +1,20 @@
+package parser
+
+import (
+	"encoding/json"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
+)
+
+// SnortJSONParser parses records written by the Snort 3 alert_json logger.
+type SnortJSONParser struct{}
+
+// Parse unmarshals a single alert_json line.
+func (p *SnortJSONParser) Parse(record []byte) (*types.SnortAlert, error) {
+	var alert types.SnortAlert
+	if err := json.Unmarshal(record, &alert); err != nil {
+		return nil, err
+	}
+
+	return &alert, nil
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
+)
+
+const (
+	// suricataTimeLayout is the timestamp layout used in EVE records.
+	suricataTimeLayout = "2006-01-02T15:04:05.999999-0700"
+
+	// SnortTimeLayout is the timestamp layout used by Snort, e.g. "24/10/10-05:32:11.000107".
+	SnortTimeLayout = "06/01/02-15:04:05.000000"
+)
+
+// SuricataEVEParser parses Suricata EVE JSON records.
+// Only records with event_type "alert" are converted, other records are skipped.
+type SuricataEVEParser struct{}
+
+// Parse unmarshals a single EVE line and maps it onto a SnortAlert.
+func (p *SuricataEVEParser) Parse(record []byte) (*types.SnortAlert, error) {
+	var eve types.SuricataEveAlert
+	if err := json.Unmarshal(record, &eve); err != nil {
+		return nil, err
+	}
+
+	if eve.EventType != "alert" || eve.Alert == nil {
+		return nil, ErrSkipRecord
+	}
+
+	ts, err := time.Parse(suricataTimeLayout, eve.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EVE timestamp: %w", err)
+	}
+	ts = ts.UTC()
+
+	alert := &types.SnortAlert{
+		Action:         suricataAction(eve.Alert.Action),
+		Base64Data:     eve.Payload,
+		Classification: eve.Alert.Category,
+		Direction:      suricataDirection(eve.Direction),
+		DstAddr:        eve.DestIP,
+		DstAp:          joinAddrPort(eve.DestIP, eve.DestPort),
+		DstPort:        eve.DestPort,
+		GID:            eve.Alert.GID,
+		ICMPCode:       eve.ICMPCode,
+		ICMPType:       eve.ICMPType,
+		Interface:      eve.InIface,
+		Message:        eve.Alert.Signature,
+		PktGen:         eve.PktSrc,
+		Priority:       eve.Alert.Severity,
+		Protocol:       strings.ToUpper(eve.Proto),
+		Revision:       eve.Alert.Rev,
+		RuleID:         fmt.Sprintf("%d:%d:%d", eve.Alert.GID, eve.Alert.SignatureID, eve.Alert.Rev),
+		Seconds:        ts.Unix(),
+		Service:        suricataService(eve.AppProto),
+		SID:            eve.Alert.SignatureID,
+		SrcAddr:        eve.SrcIP,
+		SrcAp:          joinAddrPort(eve.SrcIP, eve.SrcPort),
+		SrcPort:        eve.SrcPort,
+		Timestamp:      ts.Format(SnortTimeLayout),
+	}
+
+	if len(eve.Vlan) > 0 {
+		alert.VLAN = &eve.Vlan[0]
+	}
+
+	if eve.Flow != nil {
+		alert.ClientPkts = eve.Flow.PktsToServer
+		alert.ServerPkts = eve.Flow.PktsToClient
+		alert.ClientBytes = eve.Flow.BytesToServer
+		alert.ServerBytes = eve.Flow.BytesToClient
+
+		if eve.Flow.Start != nil {
+			if start, err := time.Parse(suricataTimeLayout, *eve.Flow.Start); err == nil {
+				startSeconds := start.Unix()
+				alert.FlowStartTime = &startSeconds
+			}
+		}
+	}
+
+	if eve.Ether != nil {
+		alert.EthSrc = upperPtr(eve.Ether.SrcMac)
+		alert.EthDst = upperPtr(eve.Ether.DestMac)
+	}
+
+	return alert, nil
+}
+
+// suricataAction maps the EVE action onto the Snort action names.
+func suricataAction(action string) *string {
+	switch action {
+	case "":
+		return nil
+	case "allowed":
+		action = "allow"
+	case "blocked":
+		action = "block"
+	}
+
+	return &action
+}
+
+// suricataDirection maps the EVE direction onto the Snort direction names.
+func suricataDirection(direction *string) *string {
+	if direction == nil {
+		return nil
+	}
+
+	var dir string
+	switch *direction {
+	case "to_server":
+		dir = "C2S"
+	case "to_client":
+		dir = "S2C"
+	default:
+		dir = *direction
+	}
+
+	return &dir
+}
+
+// suricataService drops the placeholder values Suricata uses when no application protocol was detected.
+func suricataService(appProto *string) *string {
+	if appProto == nil || *appProto == "failed" || *appProto == "" {
+		return nil
+	}
+
+	return appProto
+}
+
+func joinAddrPort(addr *string, port *int64) *string {
+	if addr == nil {
+		return nil
+	}
+
+	ap := *addr
+	if port != nil {
+		ap = fmt.Sprintf("%s:%d", *addr, *port)
+	}
+
+	return &ap
+}
+
+func upperPtr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+
+	upper := strings.ToUpper(*s)
+	return &upper
+}
@@ -392,6 +392,7 @@ type SensorEvent struct {
 	SnortSeconds        int64     `protobuf:"varint,21,opt,name=snort_seconds,json=snortSeconds,proto3" json:"snort_seconds,omitempty"`
 	SnortService        *string   `protobuf:"bytes,22,opt,name=snort_service,json=snortService,proto3,oneof" json:"snort_service,omitempty"`
 	SnortTypeOfService  *int64    `protobuf:"varint,23,opt,name=snort_type_of_service,json=snortTypeOfService,proto3,oneof" json:"snort_type_of_service,omitempty"`
+	SourceHostname      *string   `protobuf:"bytes,24,opt,name=source_hostname,json=sourceHostname,proto3,oneof" json:"source_hostname,omitempty"`
 }
 
 func (x *SensorEvent) Reset() {
@@ -587,6 +588,13 @@ func (x *SensorEvent) GetSnortTypeOfService() int64 {
 	return 0
 }
 
+func (x *SensorEvent) GetSourceHostname() string {
+	if x != nil && x.SourceHostname != nil {
+		return *x.SourceHostname
+	}
+	return ""
+}
+
 type AlertSummary struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -634,6 +642,69 @@ func (x *AlertSummary) GetTotalAlerts() int32 {
 	return 0
 }
 
+type EventAck struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	EventHashSha256 string `protobuf:"bytes,1,opt,name=event_hash_sha256,json=eventHashSha256,proto3" json:"event_hash_sha256,omitempty"`
+	Success         bool   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	Error           string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *EventAck) Reset() {
+	*x = EventAck{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_protos_sensor_event_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EventAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EventAck) ProtoMessage() {}
+
+func (x *EventAck) ProtoReflect() protoreflect.Message {
+	mi := &file_protos_sensor_event_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EventAck.ProtoReflect.Descriptor instead.
+func (*EventAck) Descriptor() ([]byte, []int) {
+	return file_protos_sensor_event_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *EventAck) GetEventHashSha256() string {
+	if x != nil {
+		return x.EventHashSha256
+	}
+	return ""
+}
+
+func (x *EventAck) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *EventAck) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
 var File_protos_sensor_event_proto protoreflect.FileDescriptor
 
 var file_protos_sensor_event_proto_rawDesc = []byte{
@@ -790,7 +861,7 @@ var file_protos_sensor_event_proto_rawDesc = []byte{
 	0x72, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x74, 0x6f, 0x5f, 0x6c, 0x69, 0x76, 0x65, 0x42,
 	0x13, 0x0a, 0x11, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x75, 0x64, 0x70, 0x5f, 0x6c, 0x65,
 	0x6e, 0x67, 0x74, 0x68, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x76,
-	0x6c, 0x61, 0x6e, 0x22, 0x98, 0x08, 0x0a, 0x0b, 0x53, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x45, 0x76,
+	0x6c, 0x61, 0x6e, 0x22, 0xda, 0x08, 0x0a, 0x0b, 0x53, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x45, 0x76,
 	0x65, 0x6e, 0x74, 0x12, 0x24, 0x0a, 0x07, 0x6d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73, 0x18, 0x01,
 	0x20, 0x03, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x65, 0x74, 0x72, 0x69, 0x63,
 	0x52, 0x07, 0x6d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x73, 0x12, 0x2a, 0x0a, 0x11, 0x65, 0x76, 0x65,
@@ -849,22 +920,37 @@ var file_protos_sensor_event_proto_rawDesc = []byte{
 	0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x5f, 0x6f, 0x66, 0x5f, 0x73, 0x65,
 	0x72, 0x76, 0x69, 0x63, 0x65, 0x18, 0x17, 0x20, 0x01, 0x28, 0x03, 0x48, 0x04, 0x52, 0x12, 0x73,
 	0x6e, 0x6f, 0x72, 0x74, 0x54, 0x79, 0x70, 0x65, 0x4f, 0x66, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63,
-	0x65, 0x88, 0x01, 0x01, 0x42, 0x0f, 0x0a, 0x0d, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x61,
-	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x42, 0x17, 0x0a, 0x15, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f,
-	0x63, 0x6c, 0x61, 0x73, 0x73, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x42, 0x12,
-	0x0a, 0x10, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69,
-	0x6f, 0x6e, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x73, 0x65, 0x72,
-	0x76, 0x69, 0x63, 0x65, 0x42, 0x18, 0x0a, 0x16, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x74,
-	0x79, 0x70, 0x65, 0x5f, 0x6f, 0x66, 0x5f, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x22, 0x31,
-	0x0a, 0x0c, 0x41, 0x6c, 0x65, 0x72, 0x74, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x79, 0x12, 0x21,
-	0x0a, 0x0c, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x61, 0x6c, 0x65, 0x72, 0x74, 0x73, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x41, 0x6c, 0x65, 0x72, 0x74,
-	0x73, 0x32, 0x4a, 0x0a, 0x0d, 0x53, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x53, 0x65, 0x72, 0x76, 0x69,
-	0x63, 0x65, 0x12, 0x39, 0x0a, 0x0a, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x44, 0x61, 0x74, 0x61,
-	0x12, 0x0f, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x45, 0x76, 0x65, 0x6e,
-	0x74, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x28, 0x01, 0x42, 0x06, 0x5a,
-	0x04, 0x2e, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x65, 0x88, 0x01, 0x01, 0x12, 0x2c, 0x0a, 0x0f, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x68,
+	0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x18, 0x20, 0x01, 0x28, 0x09, 0x48, 0x05, 0x52,
+	0x0e, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x48, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x88,
+	0x01, 0x01, 0x42, 0x0f, 0x0a, 0x0d, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x61, 0x63, 0x74,
+	0x69, 0x6f, 0x6e, 0x42, 0x17, 0x0a, 0x15, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x63, 0x6c,
+	0x61, 0x73, 0x73, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x42, 0x12, 0x0a, 0x10,
+	0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e,
+	0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x73, 0x65, 0x72, 0x76, 0x69,
+	0x63, 0x65, 0x42, 0x18, 0x0a, 0x16, 0x5f, 0x73, 0x6e, 0x6f, 0x72, 0x74, 0x5f, 0x74, 0x79, 0x70,
+	0x65, 0x5f, 0x6f, 0x66, 0x5f, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x42, 0x12, 0x0a, 0x10,
+	0x5f, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x68, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65,
+	0x22, 0x31, 0x0a, 0x0c, 0x41, 0x6c, 0x65, 0x72, 0x74, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x79,
+	0x12, 0x21, 0x0a, 0x0c, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x61, 0x6c, 0x65, 0x72, 0x74, 0x73,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x41, 0x6c, 0x65,
+	0x72, 0x74, 0x73, 0x22, 0x66, 0x0a, 0x08, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x41, 0x63, 0x6b, 0x12,
+	0x2a, 0x0a, 0x11, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x5f, 0x73, 0x68,
+	0x61, 0x32, 0x35, 0x36, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x65, 0x76, 0x65, 0x6e,
+	0x74, 0x48, 0x61, 0x73, 0x68, 0x53, 0x68, 0x61, 0x32, 0x35, 0x36, 0x12, 0x18, 0x0a, 0x07, 0x73,
+	0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75,
+	0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x32, 0x84, 0x01, 0x0a, 0x0d,
+	0x53, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x39, 0x0a,
+	0x0a, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x44, 0x61, 0x74, 0x61, 0x12, 0x0f, 0x2e, 0x70, 0x62,
+	0x2e, 0x53, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x1a, 0x16, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45,
+	0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x28, 0x01, 0x12, 0x38, 0x0a, 0x11, 0x53, 0x74, 0x72, 0x65,
+	0x61, 0x6d, 0x44, 0x61, 0x74, 0x61, 0x57, 0x69, 0x74, 0x68, 0x41, 0x63, 0x6b, 0x12, 0x0f, 0x2e,
+	0x70, 0x62, 0x2e, 0x53, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x1a, 0x0c,
+	0x2e, 0x70, 0x62, 0x2e, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x41, 0x63, 0x6b, 0x22, 0x00, 0x28, 0x01,
+	0x30, 0x01, 0x42, 0x06, 0x5a, 0x04, 0x2e, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
 }
 
 var (
@@ -879,19 +965,22 @@ func file_protos_sensor_event_proto_rawDescGZIP() []byte {
 	return file_protos_sensor_event_proto_rawDescData
 }
 
-var file_protos_sensor_event_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_protos_sensor_event_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_protos_sensor_event_proto_goTypes = []interface{}{
 	(*Metric)(nil),        // 0: pb.Metric
 	(*SensorEvent)(nil),   // 1: pb.SensorEvent
 	(*AlertSummary)(nil),  // 2: pb.AlertSummary
-	(*emptypb.Empty)(nil), // 3: google.protobuf.Empty
+	(*EventAck)(nil),      // 3: pb.EventAck
+	(*emptypb.Empty)(nil), // 4: google.protobuf.Empty
 }
 var file_protos_sensor_event_proto_depIdxs = []int32{
 	0, // 0: pb.SensorEvent.metrics:type_name -> pb.Metric
 	1, // 1: pb.SensorService.StreamData:input_type -> pb.SensorEvent
-	3, // 2: pb.SensorService.StreamData:output_type -> google.protobuf.Empty
-	2, // [2:3] is the sub-list for method output_type
-	1, // [1:2] is the sub-list for method input_type
+	1, // 2: pb.SensorService.StreamDataWithAck:input_type -> pb.SensorEvent
+	4, // 3: pb.SensorService.StreamData:output_type -> google.protobuf.Empty
+	3, // 4: pb.SensorService.StreamDataWithAck:output_type -> pb.EventAck
+	3, // [3:5] is the sub-list for method output_type
+	1, // [1:3] is the sub-list for method input_type
 	1, // [1:1] is the sub-list for extension type_name
 	1, // [1:1] is the sub-list for extension extendee
 	0, // [0:1] is the sub-list for field type_name
@@ -939,6 +1028,18 @@ func file_protos_sensor_event_proto_init() {
 				return nil
 			}
 		}
+		file_protos_sensor_event_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EventAck); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	file_protos_sensor_event_proto_msgTypes[0].OneofWrappers = []interface{}{}
 	file_protos_sensor_event_proto_msgTypes[1].OneofWrappers = []interface{}{}
@@ -948,7 +1049,7 @@ func file_protos_sensor_event_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_protos_sensor_event_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
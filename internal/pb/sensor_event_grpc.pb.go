@@ -20,7 +20,8 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	SensorService_StreamData_FullMethodName = "/pb.SensorService/StreamData"
+	SensorService_StreamData_FullMethodName        = "/pb.SensorService/StreamData"
+	SensorService_StreamDataWithAck_FullMethodName = "/pb.SensorService/StreamDataWithAck"
 )
 
 // SensorServiceClient is the client API for SensorService service.
@@ -28,6 +29,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type SensorServiceClient interface {
 	StreamData(ctx context.Context, opts ...grpc.CallOption) (SensorService_StreamDataClient, error)
+	StreamDataWithAck(ctx context.Context, opts ...grpc.CallOption) (SensorService_StreamDataWithAckClient, error)
 }
 
 type sensorServiceClient struct {
@@ -72,11 +74,43 @@ func (x *sensorServiceStreamDataClient) CloseAndRecv() (*emptypb.Empty, error) {
 	return m, nil
 }
 
+func (c *sensorServiceClient) StreamDataWithAck(ctx context.Context, opts ...grpc.CallOption) (SensorService_StreamDataWithAckClient, error) {
+	stream, err := c.cc.NewStream(ctx, &SensorService_ServiceDesc.Streams[1], SensorService_StreamDataWithAck_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &sensorServiceStreamDataWithAckClient{stream}
+	return x, nil
+}
+
+type SensorService_StreamDataWithAckClient interface {
+	Send(*SensorEvent) error
+	Recv() (*EventAck, error)
+	grpc.ClientStream
+}
+
+type sensorServiceStreamDataWithAckClient struct {
+	grpc.ClientStream
+}
+
+func (x *sensorServiceStreamDataWithAckClient) Send(m *SensorEvent) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *sensorServiceStreamDataWithAckClient) Recv() (*EventAck, error) {
+	m := new(EventAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // SensorServiceServer is the server API for SensorService service.
 // All implementations must embed UnimplementedSensorServiceServer
 // for forward compatibility
 type SensorServiceServer interface {
 	StreamData(SensorService_StreamDataServer) error
+	StreamDataWithAck(SensorService_StreamDataWithAckServer) error
 	mustEmbedUnimplementedSensorServiceServer()
 }
 
@@ -87,6 +121,9 @@ type UnimplementedSensorServiceServer struct {
 func (UnimplementedSensorServiceServer) StreamData(SensorService_StreamDataServer) error {
 	return status.Errorf(codes.Unimplemented, "method StreamData not implemented")
 }
+func (UnimplementedSensorServiceServer) StreamDataWithAck(SensorService_StreamDataWithAckServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamDataWithAck not implemented")
+}
 func (UnimplementedSensorServiceServer) mustEmbedUnimplementedSensorServiceServer() {}
 
 // UnsafeSensorServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -126,6 +163,32 @@ func (x *sensorServiceStreamDataServer) Recv() (*SensorEvent, error) {
 	return m, nil
 }
 
+func _SensorService_StreamDataWithAck_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SensorServiceServer).StreamDataWithAck(&sensorServiceStreamDataWithAckServer{stream})
+}
+
+type SensorService_StreamDataWithAckServer interface {
+	Send(*EventAck) error
+	Recv() (*SensorEvent, error)
+	grpc.ServerStream
+}
+
+type sensorServiceStreamDataWithAckServer struct {
+	grpc.ServerStream
+}
+
+func (x *sensorServiceStreamDataWithAckServer) Send(m *EventAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *sensorServiceStreamDataWithAckServer) Recv() (*SensorEvent, error) {
+	m := new(SensorEvent)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // SensorService_ServiceDesc is the grpc.ServiceDesc for SensorService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -139,6 +202,12 @@ var SensorService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _SensorService_StreamData_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "StreamDataWithAck",
+			Handler:       _SensorService_StreamDataWithAck_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "protos/sensor_event.proto",
 }
@@ -0,0 +1,181 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+)
+
+// HashVersion prefixes every event hash so consumers can tell hash schemes apart.
+// It must be changed whenever the encoding of the hashed fields changes. It is followed by a short
+// digest of the selected fields, so hashes over different field sets never look alike:
+// v1-<field set digest>:<hash>.
+const HashVersion = "v1"
+
+// fieldSetDigestLength is the number of hex characters of the field set digest in the hash prefix.
+const fieldSetDigestLength = 8
+
+// DefaultHashFields are the SensorEvent fields hashed by default, in the order they are hashed.
+var DefaultHashFields = []string{
+	"sensor_id",
+	"sensor_version",
+	"source_hostname",
+	"snort_action",
+	"snort_classification",
+	"snort_direction",
+	"snort_interface",
+	"snort_message",
+	"snort_priority",
+	"snort_protocol",
+	"snort_rule_gid",
+	"snort_rule_rev",
+	"snort_rule_sid",
+	"snort_rule",
+	"snort_seconds",
+	"snort_service",
+	"snort_type_of_service",
+}
+
+// hashField returns the value of a SensorEvent field, or nil when an optional field is not set.
+type hashField func(e *pb.SensorEvent) *string
+
+func stringField(get func(e *pb.SensorEvent) string) hashField {
+	return func(e *pb.SensorEvent) *string {
+		v := get(e)
+		return &v
+	}
+}
+
+func intField(get func(e *pb.SensorEvent) int64) hashField {
+	return func(e *pb.SensorEvent) *string {
+		v := strconv.FormatInt(get(e), 10)
+		return &v
+	}
+}
+
+func optionalIntField(get func(e *pb.SensorEvent) *int64) hashField {
+	return func(e *pb.SensorEvent) *string {
+		v := get(e)
+		if v == nil {
+			return nil
+		}
+		s := strconv.FormatInt(*v, 10)
+		return &s
+	}
+}
+
+// hashFields are the SensorEvent fields that can be part of the hash, by their proto name.
+var hashFields = map[string]hashField{
+	"sensor_id":             stringField(func(e *pb.SensorEvent) string { return e.SensorId }),
+	"sensor_version":        stringField(func(e *pb.SensorEvent) string { return e.SensorVersion }),
+	"source_hostname":       func(e *pb.SensorEvent) *string { return e.SourceHostname },
+	"snort_action":          func(e *pb.SensorEvent) *string { return e.SnortAction },
+	"snort_classification":  func(e *pb.SensorEvent) *string { return e.SnortClassification },
+	"snort_direction":       func(e *pb.SensorEvent) *string { return e.SnortDirection },
+	"snort_interface":       stringField(func(e *pb.SensorEvent) string { return e.SnortInterface }),
+	"snort_message":         stringField(func(e *pb.SensorEvent) string { return e.SnortMessage }),
+	"snort_priority":        intField(func(e *pb.SensorEvent) int64 { return e.SnortPriority }),
+	"snort_protocol":        stringField(func(e *pb.SensorEvent) string { return e.SnortProtocol }),
+	"snort_rule_gid":        intField(func(e *pb.SensorEvent) int64 { return e.SnortRuleGid }),
+	"snort_rule_rev":        intField(func(e *pb.SensorEvent) int64 { return e.SnortRuleRev }),
+	"snort_rule_sid":        intField(func(e *pb.SensorEvent) int64 { return e.SnortRuleSid }),
+	"snort_rule":            stringField(func(e *pb.SensorEvent) string { return e.SnortRule }),
+	"snort_seconds":         intField(func(e *pb.SensorEvent) int64 { return e.SnortSeconds }),
+	"snort_service":         func(e *pb.SensorEvent) *string { return e.SnortService },
+	"snort_type_of_service": optionalIntField(func(e *pb.SensorEvent) *int64 { return e.SnortTypeOfService }),
+}
+
+var (
+	hashMu         sync.RWMutex
+	selectedFields = DefaultHashFields
+	selectedPrefix = hashPrefix(DefaultHashFields)
+)
+
+// SetHashFields selects the fields of the event hash. An empty include list selects DefaultHashFields,
+// and the fields in exclude are left out, e.g. snort_seconds to group alerts regardless of their time.
+func SetHashFields(include, exclude []string) error {
+	if len(include) == 0 {
+		include = DefaultHashFields
+	}
+
+	fields := make([]string, 0, len(include))
+	for _, name := range include {
+		if _, ok := hashFields[name]; !ok {
+			return fmt.Errorf("unknown hash field %q", name)
+		}
+		if !slices.Contains(exclude, name) && !slices.Contains(fields, name) {
+			fields = append(fields, name)
+		}
+	}
+	for _, name := range exclude {
+		if _, ok := hashFields[name]; !ok {
+			return fmt.Errorf("unknown hash field %q", name)
+		}
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("no fields left to hash")
+	}
+
+	hashMu.Lock()
+	selectedFields = fields
+	selectedPrefix = hashPrefix(fields)
+	hashMu.Unlock()
+
+	return nil
+}
+
+// GetHashFields returns the fields of the event hash in the order they are hashed.
+func GetHashFields() []string {
+	hashMu.RLock()
+	defer hashMu.RUnlock()
+
+	return slices.Clone(selectedFields)
+}
+
+// generateHashSHA256 generates a SHA256 hash over the selected fields of the event.
+// It is used to identify the sensor event record, so records with the same hash are grouped.
+// Every field is written as its length-prefixed name followed by a presence byte and its
+// length-prefixed value, so the result does not depend on the protobuf library version.
+func generateHashSHA256(payload *pb.SensorEvent) string {
+	hashMu.RLock()
+	fields, prefix := selectedFields, selectedPrefix
+	hashMu.RUnlock()
+
+	h := sha256.New()
+	for _, name := range fields {
+		writeHashString(h, name)
+
+		value := hashFields[name](payload)
+		if value == nil {
+			h.Write([]byte{0})
+			continue
+		}
+		h.Write([]byte{1})
+		writeHashString(h, *value)
+	}
+
+	return prefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// hashPrefix returns the prefix of the hashes over the given fields, in order.
+func hashPrefix(fields []string) string {
+	h := sha256.New()
+	for _, name := range fields {
+		writeHashString(h, name)
+	}
+
+	return HashVersion + "-" + hex.EncodeToString(h.Sum(nil))[:fieldSetDigestLength] + ":"
+}
+
+func writeHashString(h hash.Hash, s string) {
+	var size [binary.MaxVarintLen64]byte
+	h.Write(size[:binary.PutUvarint(size[:], uint64(len(s)))])
+	h.Write([]byte(s))
+}
@@ -1,9 +1,6 @@
 package processor
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-
 	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
@@ -21,12 +18,13 @@ func GetRawDataFromMetrics(data *pb.SensorEvent, metric *pb.Metric) *types.Snort
 
 	return &types.SnortAlert{
 		Metadata: types.Metadata{
-			SensorID:      data.SensorId,
-			SensorVersion: data.SensorVersion,
-			HashSHA256:    data.EventHashSha256,
-			SentAt:        data.EventSentAt,
-			ReadAt:        data.EventReadAt,
-			ReceivedAt:    data.EventReceivedAt,
+			SensorID:       data.SensorId,
+			SensorVersion:  data.SensorVersion,
+			HashSHA256:     data.EventHashSha256,
+			SentAt:         data.EventSentAt,
+			ReadAt:         data.EventReadAt,
+			ReceivedAt:     data.EventReceivedAt,
+			SourceHostname: data.SourceHostname,
 		},
 		Action:         data.SnortAction,
 		Base64Data:     metric.SnortBase64Data,
@@ -111,6 +109,7 @@ func ConvertSnortAlertToSensorEvent(data *types.SnortAlert) (*pb.SensorEvent, *p
 		SnortSeconds:        data.Seconds,
 		SnortService:        data.Service,
 		SnortTypeOfService:  data.TOS,
+		SourceHostname:      data.Metadata.SourceHostname,
 	}
 
 	// Generate SHA256 hash
@@ -163,10 +162,3 @@ func ConvertSnortAlertToSensorEvent(data *types.SnortAlert) (*pb.SensorEvent, *p
 
 	return sensorEvent, sensorMetric
 }
-
-// generateHashSHA256 generates a SHA256 hash from the attributes.
-// It is used to identify the sensor event record.
-func generateHashSHA256(payload *pb.SensorEvent) string {
-	hash := sha256.Sum256([]byte(payload.String()))
-	return hex.EncodeToString(hash[:])
-}
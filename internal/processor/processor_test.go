@@ -2,6 +2,7 @@ package processor
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -196,7 +197,7 @@ func Test_ConvertSnortAlertToSensorEvent(t *testing.T) {
 			want: &pb.SensorEvent{
 				SensorId:            "sensor-v2",
 				SensorVersion:       "v2",
-				EventHashSha256:     "ddf1571108fffa276a60a670a30655799b5d68e47eb7a4f106e1826fa26450fb",
+				EventHashSha256:     "v1-c25e7d39:2928abfe5afd5078e65bcdbd47a3f118c990dc952c830479cc75668697e3f199",
 				EventMetricsCount:   1,
 				EventSeconds:        1728513131,
 				EventSentAt:         1732161976384394,
@@ -261,6 +262,102 @@ func Test_ConvertSnortAlertToSensorEvent(t *testing.T) {
 	}
 }
 
+func Test_ConvertSnortAlertHashesSourceHostname(t *testing.T) {
+	alert := func(hostname string) *types.SnortAlert {
+		return &types.SnortAlert{
+			Metadata: types.Metadata{SensorID: "sensor-v2", SourceHostname: toPtr(hostname)},
+			Message:  "PUA-ADWARE Js.Adware.Agent variant redirect attempt",
+			SID:      54307,
+			Seconds:  1728513131,
+		}
+	}
+
+	a, _ := ConvertSnortAlertToSensorEvent(alert("appliance-a"))
+	b, _ := ConvertSnortAlertToSensorEvent(alert("appliance-b"))
+	if a.EventHashSha256 == b.EventHashSha256 {
+		t.Errorf("Expected alerts from two hostnames to have two hashes, got %s", a.EventHashSha256)
+	}
+}
+
+func Test_generateHashSHA256(t *testing.T) {
+	t.Cleanup(func() {
+		_ = SetHashFields(nil, nil)
+	})
+
+	event := func(seconds int64, classification *string) *pb.SensorEvent {
+		return &pb.SensorEvent{
+			SensorId:            "sensor-v2",
+			SnortClassification: classification,
+			SnortMessage:        "PUA-ADWARE Js.Adware.Agent variant redirect attempt",
+			SnortRuleSid:        54307,
+			SnortSeconds:        seconds,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		a, b     *pb.SensorEvent
+		wantSame bool
+		wantErr  bool
+	}{
+		{
+			name:     "Must separate alerts from different seconds by default",
+			a:        event(1728513131, nil),
+			b:        event(1728513132, nil),
+			wantSame: false,
+		},
+		{
+			name:     "Must group alerts from different seconds without snort_seconds",
+			exclude:  []string{"snort_seconds"},
+			a:        event(1728513131, nil),
+			b:        event(1728513132, nil),
+			wantSame: true,
+		},
+		{
+			name:     "Must tell an unset optional field from an empty one",
+			a:        event(1728513131, nil),
+			b:        event(1728513131, toPtr("")),
+			wantSame: false,
+		},
+		{
+			name:     "Must only hash the included fields",
+			include:  []string{"sensor_id", "snort_rule_sid"},
+			a:        event(1728513131, toPtr("none")),
+			b:        event(1728513132, nil),
+			wantSame: true,
+		},
+		{
+			name:    "Must reject unknown fields",
+			include: []string{"sensor_id", "event_read_at"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SetHashFields(tt.include, tt.exclude)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetHashFields() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			a, b := generateHashSHA256(tt.a), generateHashSHA256(tt.b)
+			if a != generateHashSHA256(proto.Clone(tt.a).(*pb.SensorEvent)) {
+				t.Errorf("generateHashSHA256() is not deterministic")
+			}
+			if !strings.HasPrefix(a, hashPrefix(GetHashFields())) {
+				t.Errorf("generateHashSHA256() = %s, want the %s prefix", a, hashPrefix(GetHashFields()))
+			}
+			if (a == b) != tt.wantSame {
+				t.Errorf("generateHashSHA256() = %s and %s, want same %t", a, b, tt.wantSame)
+			}
+		})
+	}
+}
+
 // func Test_parseLogLine(t *testing.T) {
 // 	type args struct {
 // 		sensorID     string
@@ -414,3 +511,41 @@ func Test_ConvertSnortAlertToSensorEvent(t *testing.T) {
 //		})
 //	}
 //}
+
+func Test_hashPrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want bool
+	}{
+		{
+			name: "Must be the same for the same fields",
+			a:    []string{"sensor_id", "snort_rule_sid"},
+			b:    []string{"sensor_id", "snort_rule_sid"},
+			want: true,
+		},
+		{
+			name: "Must differ for different fields",
+			a:    DefaultHashFields,
+			b:    []string{"sensor_id", "snort_rule_sid"},
+			want: false,
+		},
+		{
+			name: "Must differ for the same fields in another order",
+			a:    []string{"sensor_id", "snort_rule_sid"},
+			b:    []string{"snort_rule_sid", "sensor_id"},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := hashPrefix(tt.a), hashPrefix(tt.b)
+			if !strings.HasPrefix(a, HashVersion+"-") {
+				t.Errorf("hashPrefix() = %s, want the %s version", a, HashVersion)
+			}
+			if (a == b) != tt.want {
+				t.Errorf("hashPrefix() = %s and %s, want same %t", a, b, tt.want)
+			}
+		})
+	}
+}
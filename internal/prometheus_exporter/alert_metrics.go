@@ -0,0 +1,213 @@
+package prometheus_exporter
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	MESRuleAlerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_sensor_rule_alerts",
+		Help: "Total number of alerts by rule. Rules outside the top K are counted as other.",
+	}, []string{"snort_rule_gid", "snort_rule_sid"})
+	MESPriorityAlerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_sensor_priority_alerts",
+		Help: "Total number of alerts by priority. Priorities outside the top K are counted as other.",
+	}, []string{"snort_priority"})
+	MESClassificationAlerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_sensor_classification_alerts",
+		Help: "Total number of alerts by classification. Classifications outside the top K are counted as other.",
+	}, []string{"snort_classification"})
+	MESActionAlerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_sensor_action_alerts",
+		Help: "Total number of alerts by action. Actions outside the top K are counted as other.",
+	}, []string{"snort_action"})
+)
+
+// OtherLabel is the label value alerts outside the top K are counted under.
+const OtherLabel = "other"
+
+const (
+	// alertScoreDecay is applied to the alert counts every time they are recorded,
+	// so the top K follows the recent alerts. With the 10s interval it halves a count in about a minute.
+	alertScoreDecay = 0.9
+
+	// alertTrackedFactor bounds the label sets whose counts are kept, and the series of
+	// label sets that left the top K, to this multiple of K.
+	alertTrackedFactor = 4
+
+	// alertSeriesIdle is how long the series of a label set that left the top K is kept,
+	// so that its counter continues instead of restarting from zero when it returns.
+	alertSeriesIdle = time.Hour
+)
+
+// topK keeps a series for the K label sets with the most recent alerts and counts the others as other.
+// A label set that leaves the top K keeps its series, without new alerts, until it has been out of
+// the top K for alertSeriesIdle.
+type topK struct {
+	vec      *prometheus.CounterVec
+	k        int
+	other    []string
+	scores   map[string]float64
+	promoted map[string]bool
+	demoted  map[string]time.Time
+}
+
+func newTopK(vec *prometheus.CounterVec, k int, labels int) *topK {
+	other := make([]string, labels)
+	for i := range other {
+		other[i] = OtherLabel
+	}
+
+	return &topK{
+		vec:      vec,
+		k:        k,
+		other:    other,
+		scores:   make(map[string]float64),
+		promoted: make(map[string]bool),
+		demoted:  make(map[string]time.Time),
+	}
+}
+
+// topKKey joins label values into a map key.
+func topKKey(values ...string) string {
+	return strings.Join(values, "\x00")
+}
+
+// add counts the alerts by label set key and updates the top K.
+func (t *topK) add(counts map[string]int64) {
+	for key, score := range t.scores {
+		t.scores[key] = score * alertScoreDecay
+	}
+	for key, n := range counts {
+		t.scores[key] += float64(n)
+	}
+
+	ranked := make([]string, 0, len(t.scores))
+	for key := range t.scores {
+		ranked = append(ranked, key)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if t.scores[ranked[i]] != t.scores[ranked[j]] {
+			return t.scores[ranked[i]] > t.scores[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+
+	promoted := make(map[string]bool, t.k)
+	for _, key := range ranked[:min(t.k, len(ranked))] {
+		promoted[key] = true
+	}
+	now := time.Now()
+	for key := range t.promoted {
+		if !promoted[key] {
+			t.demoted[key] = now
+		}
+	}
+	for key := range promoted {
+		delete(t.demoted, key)
+	}
+	t.promoted = promoted
+	t.expire(now)
+
+	if len(ranked) > alertTrackedFactor*t.k {
+		for _, key := range ranked[alertTrackedFactor*t.k:] {
+			delete(t.scores, key)
+		}
+	}
+
+	other := int64(0)
+	for key, n := range counts {
+		if promoted[key] {
+			t.vec.WithLabelValues(strings.Split(key, "\x00")...).Add(float64(n))
+		} else {
+			other += n
+		}
+	}
+	if other > 0 {
+		t.vec.WithLabelValues(t.other...).Add(float64(other))
+	}
+}
+
+// expire removes the series of the label sets that have been out of the top K for alertSeriesIdle,
+// and of the ones that left it first when more than alertTrackedFactor times K are kept.
+func (t *topK) expire(now time.Time) {
+	demoted := make([]string, 0, len(t.demoted))
+	for key, since := range t.demoted {
+		if now.Sub(since) >= alertSeriesIdle {
+			t.remove(key)
+			continue
+		}
+		demoted = append(demoted, key)
+	}
+
+	if len(demoted) <= alertTrackedFactor*t.k {
+		return
+	}
+	sort.Slice(demoted, func(i, j int) bool {
+		return t.demoted[demoted[i]].Before(t.demoted[demoted[j]])
+	})
+	for _, key := range demoted[:len(demoted)-alertTrackedFactor*t.k] {
+		t.remove(key)
+	}
+}
+
+func (t *topK) remove(key string) {
+	t.vec.DeleteLabelValues(strings.Split(key, "\x00")...)
+	delete(t.demoted, key)
+}
+
+// alertMetrics counts the alerts by rule, priority, classification and action.
+type alertMetrics struct {
+	rules           *topK
+	priorities      *topK
+	classifications *topK
+	actions         *topK
+}
+
+// EnableAlertMetrics registers the alert counters. Each of them keeps a series for the k most
+// frequent values of the recent alerts, so a noisy sensor cannot create an unbounded number of series.
+func (prom *Metrics) EnableAlertMetrics(k int) {
+	prom.reg.MustRegister(
+		MESRuleAlerts,
+		MESPriorityAlerts,
+		MESClassificationAlerts,
+		MESActionAlerts,
+	)
+
+	prom.alerts = &alertMetrics{
+		rules:           newTopK(MESRuleAlerts, k, 2),
+		priorities:      newTopK(MESPriorityAlerts, k, 1),
+		classifications: newTopK(MESClassificationAlerts, k, 1),
+		actions:         newTopK(MESActionAlerts, k, 1),
+	}
+}
+
+// RecordAlertMetrics adds the alerts counted by the queue since the last call.
+func (prom *Metrics) RecordAlertMetrics(eventQueue *queue.EventBatchQueue) {
+	if prom.alerts == nil {
+		return
+	}
+
+	rules := make(map[string]int64)
+	priorities := make(map[string]int64)
+	classifications := make(map[string]int64)
+	actions := make(map[string]int64)
+
+	for key, n := range eventQueue.GetAlertCounts() {
+		rules[topKKey(strconv.FormatInt(key.GID, 10), strconv.FormatInt(key.SID, 10))] += n
+		priorities[topKKey(strconv.FormatInt(key.Priority, 10))] += n
+		classifications[topKKey(key.Classification)] += n
+		actions[topKKey(key.Action)] += n
+	}
+
+	prom.alerts.rules.add(rules)
+	prom.alerts.priorities.add(priorities)
+	prom.alerts.classifications.add(classifications)
+	prom.alerts.actions.add(actions)
+}
@@ -0,0 +1,92 @@
+package prometheus_exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_TopK(t *testing.T) {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_alerts"}, []string{"rule"})
+	top := newTopK(vec, 2, 1)
+
+	top.add(map[string]int64{"a": 10, "b": 5, "c": 1, "d": 1})
+
+	if got := testutil.CollectAndCount(vec); got != 3 {
+		t.Errorf("Expected 2 rules and other, got %d series", got)
+	}
+	for label, want := range map[string]float64{"a": 10, "b": 5, OtherLabel: 2} {
+		if got := testutil.ToFloat64(vec.WithLabelValues(label)); got != want {
+			t.Errorf("Expected %v alerts for %s, got %v", want, label, got)
+		}
+	}
+
+	// A rule that becomes noisier than b takes its place, b keeps its count.
+	top.add(map[string]int64{"c": 100})
+
+	if got := testutil.CollectAndCount(vec); got != 4 {
+		t.Errorf("Expected 3 rules and other, got %d series", got)
+	}
+	for label, want := range map[string]float64{"a": 10, "b": 5, "c": 100, OtherLabel: 2} {
+		if got := testutil.ToFloat64(vec.WithLabelValues(label)); got != want {
+			t.Errorf("Expected %v alerts for %s, got %v", want, label, got)
+		}
+	}
+
+	// The alerts of b outside the top K are counted as other, and its counter continues once it returns.
+	top.add(map[string]int64{"b": 1})
+	top.add(map[string]int64{"b": 200})
+
+	for label, want := range map[string]float64{"a": 10, "b": 205, "c": 100, OtherLabel: 3} {
+		if got := testutil.ToFloat64(vec.WithLabelValues(label)); got != want {
+			t.Errorf("Expected %v alerts for %s, got %v", want, label, got)
+		}
+	}
+
+	// The series of a rule that has been out of the top K for long is removed.
+	top.demoted["a"] = time.Now().Add(-alertSeriesIdle)
+	top.add(nil)
+
+	if got := testutil.CollectAndCount(vec); got != 3 {
+		t.Errorf("Expected 2 rules and other, got %d series", got)
+	}
+}
+
+func Test_RecordAlertMetrics(t *testing.T) {
+	MESRuleAlerts.Reset()
+	MESActionAlerts.Reset()
+
+	prom := &Metrics{reg: prometheus.NewRegistry()}
+	prom.EnableAlertMetrics(1)
+
+	q := queue.NewEventBatchQueue()
+	q.EnableAlertCounts()
+
+	alert := func(sid int64, action string) {
+		q.AddRecordToQueue(&pb.SensorEvent{
+			EventHashSha256: "hash",
+			SnortRuleGid:    1,
+			SnortRuleSid:    sid,
+			SnortAction:     &action,
+		}, &pb.Metric{})
+	}
+	alert(1000, "alert")
+	alert(1000, "alert")
+	alert(2000, "drop")
+
+	prom.RecordAlertMetrics(q)
+
+	if got := testutil.ToFloat64(MESRuleAlerts.WithLabelValues("1", "1000")); got != 2 {
+		t.Errorf("Expected 2 alerts of rule 1:1000, got %v", got)
+	}
+	if got := testutil.ToFloat64(MESRuleAlerts.WithLabelValues(OtherLabel, OtherLabel)); got != 1 {
+		t.Errorf("Expected 1 other rule alert, got %v", got)
+	}
+	if got := testutil.ToFloat64(MESActionAlerts.WithLabelValues("alert")); got != 2 {
+		t.Errorf("Expected 2 alert actions, got %v", got)
+	}
+}
@@ -3,12 +3,21 @@ package prometheus_exporter
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mata-elang-stable/sensor-snort-service/internal/listener"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/spool"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -19,6 +28,22 @@ var (
 		Name: "mataelang_sensor_event_read_per_second",
 		Help: "Number of events read per second from Snort3 JSON File.",
 	})
+	MESFileEventReadPerSecond = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mataelang_sensor_file_event_read_per_second",
+		Help: "Number of events read per second from every alert file.",
+	}, []string{"file"})
+	MESSocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mataelang_sensor_socket_connections",
+		Help: "Number of Snort connections open on the unix socket.",
+	})
+	MESSocketConnectionLinesRead = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mataelang_sensor_socket_connection_lines_read",
+		Help: "Number of lines read so far by every open Snort connection on the unix socket.",
+	}, []string{"connection"})
+	MESSocketTruncatedRecords = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mataelang_sensor_socket_truncated_records",
+		Help: "Total number of records dropped because they were larger than the maximum record size.",
+	})
 	MESEventProcessedPerSecond = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "mataelang_sensor_event_processed_per_second",
 		Help: "Number of events processed per second.",
@@ -35,6 +60,18 @@ var (
 		Name: "mataelang_sensor_batch_queue_event_size",
 		Help: "Size of the event queued in the batch queue.",
 	})
+	MESBatchQueueBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mataelang_sensor_batch_queue_bytes",
+		Help: "Serialized size of the events held by the batch queue.",
+	})
+	MESDroppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_sensor_dropped_events",
+		Help: "Total number of events dropped because the batch queue was full, by reason.",
+	}, []string{"reason"})
+	MESSpilledEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mataelang_sensor_spilled_events",
+		Help: "Total number of events spilled to disk because the batch queue was full.",
+	})
 	MESTotalProcessedEvents = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "mataelang_sensor_total_processed_events",
 		Help: "Total number of processed events.",
@@ -43,12 +80,43 @@ var (
 		Name: "mataelang_sensor_total_sent_events",
 		Help: "Total number of sent events.",
 	})
+	MESTotalFailedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mataelang_sensor_total_failed_events",
+		Help: "Total number of events that failed to send and were queued again.",
+	})
+	MESSpoolPendingSegments = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mataelang_sensor_spool_pending_segments",
+		Help: "Number of spooled segments waiting to be delivered, by spool.",
+	}, []string{"spool"})
+	MESSpoolSpooledEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_sensor_spool_spooled_events",
+		Help: "Total number of events written to the spool, by spool.",
+	}, []string{"spool"})
+	MESSpoolDeliveredEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_sensor_spool_delivered_events",
+		Help: "Total number of events delivered from the spool, by spool.",
+	}, []string{"spool"})
+	MESReadToSentSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mataelang_sensor_read_to_sent_seconds",
+		Help:    "Time from reading the first alert of an event to sending the event, by sensor.",
+		Buckets: latencyBuckets,
+	}, []string{"sensor_id"})
 )
 
+// latencyBuckets span 1ms to about 2 minutes, as events wait in the queue and the spool before they are sent.
+var latencyBuckets = prometheus.ExponentialBuckets(0.001, 2, 18)
+
+// sinceMicro returns the seconds from the Unix microsecond timestamp from to to.
+// Clock differences between hosts that would make it negative count as zero.
+func sinceMicro(from, to int64) float64 {
+	return max(0, float64(to-from)/1e6)
+}
+
 var log = logger.GetLogger()
 
 type Metrics struct {
-	reg *prometheus.Registry
+	reg    *prometheus.Registry
+	alerts *alertMetrics
 }
 
 func NewMetrics() *Metrics {
@@ -58,12 +126,24 @@ func NewMetrics() *Metrics {
 
 	m.reg.MustRegister(
 		MESEventReadPerSecond,
+		MESFileEventReadPerSecond,
+		MESSocketConnections,
+		MESSocketConnectionLinesRead,
+		MESSocketTruncatedRecords,
 		MESEventProcessedPerSecond,
 		MESEventBatchSentPerSecond,
 		MESBatchQueueSize,
 		MESBatchQueueEventSize,
+		MESBatchQueueBytes,
+		MESDroppedEvents,
+		MESSpilledEvents,
 		MESTotalProcessedEvents,
 		MESTotalSentEvents,
+		MESTotalFailedEvents,
+		MESSpoolPendingSegments,
+		MESSpoolSpooledEvents,
+		MESSpoolDeliveredEvents,
+		MESReadToSentSeconds,
 	)
 
 	m.reg.MustRegister(collectors.NewGoCollector())
@@ -73,23 +153,132 @@ func NewMetrics() *Metrics {
 	return m
 }
 
-func (prom *Metrics) StartServer(ctx context.Context) error {
+// StartServer serves the metrics on addr until the context is done.
+// /readyz answers 503 with the error of ready until it returns nil.
+func (prom *Metrics) StartServer(ctx context.Context, addr *ListenAddress, ready ReadinessCheck) error {
+	return serveMetrics(ctx, addr, prom.reg, ready)
+}
+
+// ReadinessCheck returns why the application is not ready to do its work, or nil when it is.
+type ReadinessCheck func() error
+
+// ListenAddress is where the metrics are served.
+type ListenAddress struct {
+	// Network is "tcp" or "unix".
+	Network string
+
+	// Address is the host:port or the path of the unix socket.
+	Address string
+}
+
+func (a *ListenAddress) String() string {
+	if a.Network == "unix" {
+		return "unix:" + a.Address
+	}
+	return a.Address
+}
+
+// ParseListenAddress parses a metrics address given as host:port or unix:/path/to/socket.
+// It returns nil when addr is empty or "disabled".
+func ParseListenAddress(addr string) (*ListenAddress, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" || strings.EqualFold(addr, "disabled") {
+		return nil, nil
+	}
+
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return nil, fmt.Errorf("invalid metrics address %q: missing socket path", addr)
+		}
+		return &ListenAddress{Network: "unix", Address: path}, nil
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid metrics address %q: %w", addr, err)
+	}
+
+	return &ListenAddress{Network: "tcp", Address: addr}, nil
+}
+
+// CheckReady asks /readyz on addr whether the application serving it is ready.
+func CheckReady(ctx context.Context, addr *ListenAddress) error {
+	dialAddress := addr.Address
+	if addr.Network == "tcp" {
+		// A server listening on every interface is reached on the loopback interface.
+		host, port, err := net.SplitHostPort(addr.Address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			dialAddress = net.JoinHostPort("localhost", port)
+		}
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, addr.Network, dialAddress)
+		},
+	}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://metrics/readyz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("not ready: %s", strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// serveMetrics serves the metrics of the registry, /healthz and /readyz on addr until the context is done.
+// A nil ready check is always ready.
+func serveMetrics(ctx context.Context, addr *ListenAddress, reg *prometheus.Registry, ready ReadinessCheck) error {
 	server := &http.Server{
-		Addr: ":9101",
 		ReadHeaderTimeout: time.Second * 5,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == "/metrics" {
+			switch r.URL.Path {
+			case "/metrics":
 				promhttp.HandlerFor(
-					prom.reg, promhttp.HandlerOpts{
+					reg, promhttp.HandlerOpts{
 						EnableOpenMetrics: false,
-						Registry:          prom.reg,
+						Registry:          reg,
 					}).ServeHTTP(w, r)
-			} else {
+			case "/healthz":
+				_, _ = io.WriteString(w, "ok\n")
+			case "/readyz":
+				if ready != nil {
+					if err := ready(); err != nil {
+						http.Error(w, err.Error(), http.StatusServiceUnavailable)
+						return
+					}
+				}
+				_, _ = io.WriteString(w, "ok\n")
+			default:
 				http.NotFound(w, r)
 			}
 		}),
 	}
 
+	if addr.Network == "unix" {
+		// Remove the socket left behind by a previous run.
+		if err := os.Remove(addr.Address); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	l, err := net.Listen(addr.Network, addr.Address)
+	if err != nil {
+		return err
+	}
+
 	go func() {
 		<-ctx.Done()
 
@@ -101,7 +290,7 @@ func (prom *Metrics) StartServer(ctx context.Context) error {
 		}
 	}()
 
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
@@ -110,10 +299,58 @@ func (prom *Metrics) StartServer(ctx context.Context) error {
 
 func (prom *Metrics) RecordMetrics(l listener.Listener, eventQueue *queue.EventBatchQueue) {
 	MESEventReadPerSecond.Set(float64(l.GetEventReadPerSecond()))
+	if files, ok := l.(listener.FileRateReporter); ok {
+		// Reset so that files which are no longer tailed disappear from the metric.
+		MESFileEventReadPerSecond.Reset()
+		for name, rate := range files.GetEventReadPerSecondByFile() {
+			MESFileEventReadPerSecond.WithLabelValues(name).Set(float64(rate))
+		}
+	}
+	if conns, ok := l.(listener.ConnectionReporter); ok {
+		MESSocketConnections.Set(float64(conns.GetConnectionCount()))
+		// Reset so that closed connections disappear from the metric.
+		MESSocketConnectionLinesRead.Reset()
+		for id, lines := range conns.GetLinesReadPerConnection() {
+			MESSocketConnectionLinesRead.WithLabelValues(strconv.FormatUint(id, 10)).Set(float64(lines))
+		}
+	}
+	if truncation, ok := l.(listener.TruncationReporter); ok {
+		MESSocketTruncatedRecords.Add(float64(truncation.GetTruncatedRecords()))
+	}
 	MESEventProcessedPerSecond.Set(float64(eventQueue.GetEventProcessedPerSecond()))
 	MESEventBatchSentPerSecond.Set(float64(eventQueue.GetEventBatchSentPerSecond()))
 	MESBatchQueueSize.Set(float64(eventQueue.GetQueueSize()))
 	MESBatchQueueEventSize.Set(float64(eventQueue.GetEventQueueSize()))
+	MESBatchQueueBytes.Set(float64(eventQueue.GetQueueBytes()))
+	for reason, dropped := range eventQueue.GetDroppedEvents() {
+		MESDroppedEvents.WithLabelValues(reason).Add(float64(dropped))
+	}
+	MESSpilledEvents.Add(float64(eventQueue.GetSpilledEvents()))
 	MESTotalProcessedEvents.Add(float64(eventQueue.GetTotalProcessedEvents()))
 	MESTotalSentEvents.Add(float64(eventQueue.GetTotalSentEvents()))
+	MESTotalFailedEvents.Add(float64(eventQueue.GetTotalFailedEvents()))
+}
+
+// ObserveSent records the latency of an event that was sent.
+func (prom *Metrics) ObserveSent(event *pb.SensorEvent) {
+	if event.EventReadAt == 0 {
+		return
+	}
+	MESReadToSentSeconds.WithLabelValues(event.SensorId).Observe(sinceMicro(event.EventReadAt, event.EventSentAt))
+}
+
+// Names of the spools, used as the spool label.
+const (
+	// SpoolBatch is the spool every batch is written to before it is sent.
+	SpoolBatch = "batch"
+
+	// SpoolSpill is the spool events are moved to when the queue is full.
+	SpoolSpill = "spill"
+)
+
+// RecordSpoolMetrics records the metrics of the spool with the given name.
+func (prom *Metrics) RecordSpoolMetrics(name string, s *spool.Spool) {
+	MESSpoolPendingSegments.WithLabelValues(name).Set(float64(s.GetPendingSegments()))
+	MESSpoolSpooledEvents.WithLabelValues(name).Add(float64(s.GetTotalSpooled()))
+	MESSpoolDeliveredEvents.WithLabelValues(name).Add(float64(s.GetTotalDelivered()))
 }
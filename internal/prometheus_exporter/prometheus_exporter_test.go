@@ -0,0 +1,197 @@
+package prometheus_exporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/queue"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/spool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_ParseListenAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		want    *ListenAddress
+		wantErr bool
+	}{
+		{name: "Must parse a TCP address", addr: ":9101", want: &ListenAddress{Network: "tcp", Address: ":9101"}},
+		{name: "Must parse a unix socket", addr: "unix:/run/mes/metrics.sock", want: &ListenAddress{Network: "unix", Address: "/run/mes/metrics.sock"}},
+		{name: "Must disable an empty address", addr: ""},
+		{name: "Must disable with disabled", addr: "Disabled"},
+		{name: "Must reject an address without port", addr: "localhost", wantErr: true},
+		{name: "Must reject a unix socket without path", addr: "unix:", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseListenAddress(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseListenAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseListenAddress() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_ServeMetricsHealth(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "metrics.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var notReady error = errors.New("no server is connected")
+	done := make(chan error, 1)
+	go func() {
+		done <- serveMetrics(ctx, &ListenAddress{Network: "unix", Address: socket}, prometheus.NewRegistry(), func() error {
+			return notReady
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("serveMetrics() error = %v", err)
+		}
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	get := func(path string) (int, string) {
+		t.Helper()
+
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if resp, err = client.Get("http://metrics" + path); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to answer 200, got %d", code)
+	}
+	if code, body := get("/readyz"); code != http.StatusServiceUnavailable || body != "no server is connected\n" {
+		t.Errorf("Expected /readyz to answer 503 with the reason, got %d %q", code, body)
+	}
+
+	addr := &ListenAddress{Network: "unix", Address: socket}
+	if err := CheckReady(context.Background(), addr); err == nil || err.Error() != "not ready: no server is connected" {
+		t.Errorf("Expected CheckReady() to return the reason, got %v", err)
+	}
+
+	notReady = nil
+	if err := CheckReady(context.Background(), addr); err != nil {
+		t.Errorf("CheckReady() error = %v", err)
+	}
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected /readyz to answer 200 once ready, got %d", code)
+	}
+	if code, _ := get("/metrics"); code != http.StatusOK {
+		t.Errorf("Expected /metrics to answer 200, got %d", code)
+	}
+}
+
+// fakeSocketListener reports fixed connection counters.
+type fakeSocketListener struct {
+	lines map[uint64]int64
+}
+
+func (f *fakeSocketListener) GetEventReadPerSecond() int64                            { return 0 }
+func (f *fakeSocketListener) Start(_ context.Context, _ *queue.EventBatchQueue) error { return nil }
+func (f *fakeSocketListener) Stop() error                                             { return nil }
+func (f *fakeSocketListener) Ready() bool                                             { return true }
+func (f *fakeSocketListener) GetConnectionCount() int                                 { return len(f.lines) }
+func (f *fakeSocketListener) GetLinesReadPerConnection() map[uint64]int64             { return f.lines }
+
+func Test_RecordMetricsConnections(t *testing.T) {
+	prom := &Metrics{reg: prometheus.NewRegistry()}
+	q := queue.NewEventBatchQueue()
+
+	prom.RecordMetrics(&fakeSocketListener{lines: map[uint64]int64{1: 10, 2: 5}}, q)
+
+	if got := testutil.ToFloat64(MESSocketConnections); got != 2 {
+		t.Errorf("Expected 2 connections, got %v", got)
+	}
+	if got := testutil.ToFloat64(MESSocketConnectionLinesRead.WithLabelValues("1")); got != 10 {
+		t.Errorf("Expected 10 lines read by connection 1, got %v", got)
+	}
+
+	// A closed connection disappears from the metric.
+	prom.RecordMetrics(&fakeSocketListener{lines: map[uint64]int64{2: 7}}, q)
+
+	if got := testutil.CollectAndCount(MESSocketConnectionLinesRead); got != 1 {
+		t.Errorf("Expected 1 connection series, got %d", got)
+	}
+}
+
+// fakeDatagramListener reports a fixed number of truncated records.
+type fakeDatagramListener struct {
+	fakeSocketListener
+	truncated int64
+}
+
+func (f *fakeDatagramListener) GetTruncatedRecords() int64 { return f.truncated }
+
+func Test_RecordMetricsTruncatedRecords(t *testing.T) {
+	prom := &Metrics{reg: prometheus.NewRegistry()}
+	q := queue.NewEventBatchQueue()
+
+	before := testutil.ToFloat64(MESSocketTruncatedRecords)
+	prom.RecordMetrics(&fakeDatagramListener{truncated: 3}, q)
+
+	if got := testutil.ToFloat64(MESSocketTruncatedRecords) - before; got != 3 {
+		t.Errorf("Expected 3 truncated records, got %v", got)
+	}
+}
+
+func Test_RecordSpoolMetrics(t *testing.T) {
+	MESSpoolSpooledEvents.Reset()
+	MESSpoolPendingSegments.Reset()
+	prom := &Metrics{reg: prometheus.NewRegistry()}
+
+	batch, err := spool.NewSpool(t.TempDir(), time.Second)
+	if err != nil {
+		t.Fatalf("NewSpool() error = %v", err)
+	}
+	spill, err := spool.NewSpool(t.TempDir(), time.Second)
+	if err != nil {
+		t.Fatalf("NewSpool() error = %v", err)
+	}
+	if err := spill.Append([]*pb.SensorEvent{{EventHashSha256: "a", EventMetricsCount: 2}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	prom.RecordSpoolMetrics(SpoolBatch, batch)
+	prom.RecordSpoolMetrics(SpoolSpill, spill)
+
+	if got := testutil.CollectAndCount(MESSpoolPendingSegments); got != 2 {
+		t.Errorf("Expected a series per spool, got %d", got)
+	}
+	if got := testutil.ToFloat64(MESSpoolSpooledEvents.WithLabelValues(SpoolSpill)); got != 2 {
+		t.Errorf("Expected 2 spilled events, got %v", got)
+	}
+	if got := testutil.ToFloat64(MESSpoolSpooledEvents.WithLabelValues(SpoolBatch)); got != 0 {
+		t.Errorf("Expected no batch spooled events, got %v", got)
+	}
+}
@@ -0,0 +1,197 @@
+package prometheus_exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/auth"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	MESServerActiveStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mataelang_server_active_streams",
+		Help: "Number of open gRPC streams, by sensor.",
+	}, []string{"sensor_id"})
+	MESServerReceivedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_server_received_events",
+		Help: "Total number of alerts received, by sensor.",
+	}, []string{"sensor_id"})
+	MESServerReceivedBatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_server_received_batches",
+		Help: "Total number of events received, each aggregating one or more alerts, by sensor.",
+	}, []string{"sensor_id"})
+	MESServerReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_server_received_bytes",
+		Help: "Total serialized size of the events received, by sensor.",
+	}, []string{"sensor_id"})
+	MESServerKafkaProduceErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mataelang_server_kafka_produce_errors",
+		Help: "Total number of events that could not be serialized or queued for Kafka.",
+	})
+	MESServerKafkaDeliveryFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mataelang_server_kafka_delivery_failures",
+		Help: "Total number of events Kafka failed to deliver according to their delivery report.",
+	})
+	MESServerKafkaQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mataelang_server_kafka_queue_depth",
+		Help: "Number of messages and requests waiting in the librdkafka queue.",
+	})
+	MESServerSerializationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mataelang_server_serialization_seconds",
+		Help:    "Time it takes to serialize an event for Kafka.",
+		Buckets: prometheus.ExponentialBuckets(0.00005, 2, 14),
+	})
+	MESServerSentToReceivedSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mataelang_server_sent_to_received_seconds",
+		Help:    "Time from the sensor sending an event to the server receiving it, by sensor.",
+		Buckets: latencyBuckets,
+	}, []string{"sensor_id"})
+	MESServerReceivedToDeliveredSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mataelang_server_received_to_delivered_seconds",
+		Help:    "Time from the server receiving an event to its Kafka delivery report, by sensor.",
+		Buckets: latencyBuckets,
+	}, []string{"sensor_id"})
+	MESServerAuthRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mataelang_server_auth_rejections",
+		Help: "Total number of rejected sensor authentication attempts, by reason.",
+	}, []string{"reason"})
+)
+
+// maxUnauthenticatedSensors bounds the number of sensor_id series of sensors that are not authenticated,
+// as they name themselves. Further sensors are counted under OtherLabel.
+const maxUnauthenticatedSensors = 1000
+
+// ServerMetrics are the metrics of the server command.
+type ServerMetrics struct {
+	reg *prometheus.Registry
+
+	// streams is the number of open streams by sensor, a sensor's series is removed once it has none.
+	streamsMu sync.Mutex
+	streams   map[string]int
+
+	// unauthenticated are the sensors that are not authenticated and have their own series.
+	unauthenticatedMu sync.Mutex
+	unauthenticated   map[string]struct{}
+}
+
+// ProducerStats are the statistics of the Kafka producer.
+type ProducerStats interface {
+	GetProduceErrors() int64
+	GetDeliveryFailures() int64
+	Len() int
+}
+
+func NewServerMetrics() *ServerMetrics {
+	m := &ServerMetrics{
+		reg:             prometheus.NewRegistry(),
+		streams:         make(map[string]int),
+		unauthenticated: make(map[string]struct{}),
+	}
+
+	m.reg.MustRegister(
+		MESServerActiveStreams,
+		MESServerReceivedEvents,
+		MESServerReceivedBatches,
+		MESServerReceivedBytes,
+		MESServerKafkaProduceErrors,
+		MESServerKafkaDeliveryFailures,
+		MESServerKafkaQueueDepth,
+		MESServerSerializationSeconds,
+		MESServerSentToReceivedSeconds,
+		MESServerReceivedToDeliveredSeconds,
+		MESServerAuthRejections,
+	)
+
+	m.reg.MustRegister(collectors.NewGoCollector())
+	m.reg.MustRegister(collectors.NewBuildInfoCollector())
+	m.reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	return m
+}
+
+// StartServer serves the metrics on addr until the context is done.
+func (m *ServerMetrics) StartServer(ctx context.Context, addr *ListenAddress) error {
+	return serveMetrics(ctx, addr, m.reg, nil)
+}
+
+// SensorLabel returns the sensor_id label of a sensor. An authenticated sensor is labeled by its identity,
+// the others by the ID they send until maxUnauthenticatedSensors have their own series.
+func (m *ServerMetrics) SensorLabel(sensorID string, authenticated bool) string {
+	if authenticated {
+		return sensorID
+	}
+
+	m.unauthenticatedMu.Lock()
+	defer m.unauthenticatedMu.Unlock()
+
+	if _, ok := m.unauthenticated[sensorID]; !ok {
+		if len(m.unauthenticated) >= maxUnauthenticatedSensors {
+			return OtherLabel
+		}
+		m.unauthenticated[sensorID] = struct{}{}
+	}
+
+	return sensorID
+}
+
+// StreamStarted counts a stream of the sensor as open.
+func (m *ServerMetrics) StreamStarted(sensorLabel string) {
+	m.streamsMu.Lock()
+	defer m.streamsMu.Unlock()
+
+	m.streams[sensorLabel]++
+	MESServerActiveStreams.WithLabelValues(sensorLabel).Set(float64(m.streams[sensorLabel]))
+}
+
+// StreamEnded counts a stream of the sensor as closed.
+func (m *ServerMetrics) StreamEnded(sensorLabel string) {
+	m.streamsMu.Lock()
+	defer m.streamsMu.Unlock()
+
+	m.streams[sensorLabel]--
+	if m.streams[sensorLabel] > 0 {
+		MESServerActiveStreams.WithLabelValues(sensorLabel).Set(float64(m.streams[sensorLabel]))
+		return
+	}
+
+	delete(m.streams, sensorLabel)
+	MESServerActiveStreams.DeleteLabelValues(sensorLabel)
+}
+
+// EventReceived counts an event received from a sensor and records how long it took to arrive.
+func (m *ServerMetrics) EventReceived(sensorLabel string, event *pb.SensorEvent) {
+	MESServerReceivedBatches.WithLabelValues(sensorLabel).Inc()
+	MESServerReceivedEvents.WithLabelValues(sensorLabel).Add(float64(event.EventMetricsCount))
+	MESServerReceivedBytes.WithLabelValues(sensorLabel).Add(float64(proto.Size(event)))
+
+	if event.EventSentAt != 0 && event.EventReceivedAt != 0 {
+		MESServerSentToReceivedSeconds.WithLabelValues(sensorLabel).Observe(sinceMicro(event.EventSentAt, event.EventReceivedAt))
+	}
+}
+
+// EventDelivered records the time from receiving an event of the sensor at receivedAt to its Kafka delivery report.
+func (m *ServerMetrics) EventDelivered(sensorLabel string, receivedAt int64) {
+	MESServerReceivedToDeliveredSeconds.WithLabelValues(sensorLabel).Observe(sinceMicro(receivedAt, time.Now().UnixMicro()))
+}
+
+// ObserveSerialization records the time it took to serialize an event.
+func (m *ServerMetrics) ObserveSerialization(d time.Duration) {
+	MESServerSerializationSeconds.Observe(d.Seconds())
+}
+
+func (m *ServerMetrics) RecordProducerMetrics(p ProducerStats) {
+	MESServerKafkaProduceErrors.Add(float64(p.GetProduceErrors()))
+	MESServerKafkaDeliveryFailures.Add(float64(p.GetDeliveryFailures()))
+	MESServerKafkaQueueDepth.Set(float64(p.Len()))
+}
+
+func (m *ServerMetrics) RecordAuthMetrics(a *auth.TokenAuthenticator) {
+	for reason, rejected := range a.GetRejected() {
+		MESServerAuthRejections.WithLabelValues(reason).Add(float64(rejected))
+	}
+}
@@ -0,0 +1,132 @@
+package prometheus_exporter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_ServerMetricsEventReceived(t *testing.T) {
+	MESServerReceivedBatches.Reset()
+	MESServerReceivedEvents.Reset()
+	MESServerReceivedBytes.Reset()
+	MESServerSentToReceivedSeconds.Reset()
+
+	m := NewServerMetrics()
+	event := &pb.SensorEvent{
+		SensorId:          "sensor1",
+		EventMetricsCount: 3,
+		EventSentAt:       1_000_000,
+		EventReceivedAt:   1_500_000,
+	}
+	m.EventReceived("sensor1", event)
+	m.EventReceived("sensor1", &pb.SensorEvent{SensorId: "sensor1", EventMetricsCount: 2})
+
+	if got := testutil.ToFloat64(MESServerReceivedBatches.WithLabelValues("sensor1")); got != 2 {
+		t.Errorf("Expected 2 batches, got %v", got)
+	}
+	if got := testutil.ToFloat64(MESServerReceivedEvents.WithLabelValues("sensor1")); got != 5 {
+		t.Errorf("Expected 5 events, got %v", got)
+	}
+	want := float64(proto.Size(event) + proto.Size(&pb.SensorEvent{SensorId: "sensor1", EventMetricsCount: 2}))
+	if got := testutil.ToFloat64(MESServerReceivedBytes.WithLabelValues("sensor1")); got != want {
+		t.Errorf("Expected %v bytes, got %v", want, got)
+	}
+
+	// Only the event with both timestamps has a latency.
+	if got := testutil.CollectAndCount(MESServerSentToReceivedSeconds); got != 1 {
+		t.Errorf("Expected 1 latency series, got %d", got)
+	}
+}
+
+func Test_ServerMetricsStreams(t *testing.T) {
+	MESServerActiveStreams.Reset()
+
+	m := NewServerMetrics()
+	m.StreamStarted("sensor1")
+	m.StreamStarted("sensor1")
+	m.StreamStarted("sensor2")
+
+	if got := testutil.ToFloat64(MESServerActiveStreams.WithLabelValues("sensor1")); got != 2 {
+		t.Errorf("Expected 2 streams of sensor1, got %v", got)
+	}
+
+	m.StreamEnded("sensor1")
+	m.StreamEnded("sensor2")
+
+	if got := testutil.ToFloat64(MESServerActiveStreams.WithLabelValues("sensor1")); got != 1 {
+		t.Errorf("Expected 1 stream of sensor1, got %v", got)
+	}
+
+	// The series of a sensor without open streams is removed.
+	m.StreamEnded("sensor1")
+	if got := testutil.CollectAndCount(MESServerActiveStreams); got != 0 {
+		t.Errorf("Expected no active stream series, got %d", got)
+	}
+}
+
+func Test_ServerMetricsSensorLabel(t *testing.T) {
+	m := NewServerMetrics()
+
+	for i := range maxUnauthenticatedSensors {
+		sensorID := fmt.Sprintf("sensor%d", i)
+		if got := m.SensorLabel(sensorID, false); got != sensorID {
+			t.Fatalf("SensorLabel() = %s, want %s", got, sensorID)
+		}
+	}
+
+	if got := m.SensorLabel("sensor0", false); got != "sensor0" {
+		t.Errorf("Expected a known sensor to keep its label, got %s", got)
+	}
+	if got := m.SensorLabel("unknown", false); got != OtherLabel {
+		t.Errorf("Expected further unauthenticated sensors to be labeled %s, got %s", OtherLabel, got)
+	}
+	if got := m.SensorLabel("unknown", true); got != "unknown" {
+		t.Errorf("Expected an authenticated sensor to keep its label, got %s", got)
+	}
+}
+
+type fakeProducer struct {
+	produceErrors, deliveryFailures int64
+	queued                          int
+}
+
+func (f *fakeProducer) GetProduceErrors() int64 {
+	errors := f.produceErrors
+	f.produceErrors = 0
+	return errors
+}
+
+func (f *fakeProducer) GetDeliveryFailures() int64 {
+	failures := f.deliveryFailures
+	f.deliveryFailures = 0
+	return failures
+}
+
+func (f *fakeProducer) Len() int {
+	return f.queued
+}
+
+func Test_RecordProducerMetrics(t *testing.T) {
+	m := NewServerMetrics()
+	errorsBefore := testutil.ToFloat64(MESServerKafkaProduceErrors)
+	failuresBefore := testutil.ToFloat64(MESServerKafkaDeliveryFailures)
+
+	p := &fakeProducer{produceErrors: 2, deliveryFailures: 1, queued: 7}
+	m.RecordProducerMetrics(p)
+	p.queued = 4
+	m.RecordProducerMetrics(p)
+
+	if got := testutil.ToFloat64(MESServerKafkaProduceErrors) - errorsBefore; got != 2 {
+		t.Errorf("Expected 2 produce errors, got %v", got)
+	}
+	if got := testutil.ToFloat64(MESServerKafkaDeliveryFailures) - failuresBefore; got != 1 {
+		t.Errorf("Expected 1 delivery failure, got %v", got)
+	}
+	if got := testutil.ToFloat64(MESServerKafkaQueueDepth); got != 4 {
+		t.Errorf("Expected a queue depth of 4, got %v", got)
+	}
+}
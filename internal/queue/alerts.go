@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+)
+
+// AlertKey identifies the rule an alert was raised by and how Snort handled it.
+type AlertKey struct {
+	GID            int64
+	SID            int64
+	Priority       int64
+	Classification string
+	Action         string
+}
+
+// EnableAlertCounts makes the queue count the alerts added to it by AlertKey, reported by GetAlertCounts.
+func (q *EventBatchQueue) EnableAlertCounts() {
+	q.alertsMu.Lock()
+	defer q.alertsMu.Unlock()
+
+	if q.alerts == nil {
+		q.alerts = make(map[AlertKey]int64)
+	}
+}
+
+// countAlert counts an alert of the event when alert counting is enabled.
+func (q *EventBatchQueue) countAlert(event *pb.SensorEvent) {
+	q.alertsMu.Lock()
+	defer q.alertsMu.Unlock()
+
+	if q.alerts == nil {
+		return
+	}
+
+	q.alerts[AlertKey{
+		GID:            event.SnortRuleGid,
+		SID:            event.SnortRuleSid,
+		Priority:       event.SnortPriority,
+		Classification: event.GetSnortClassification(),
+		Action:         event.GetSnortAction(),
+	}]++
+}
+
+// GetAlertCounts retrieves the number of alerts added to the queue by AlertKey since the last call.
+// It returns nil when alert counting is not enabled.
+func (q *EventBatchQueue) GetAlertCounts() map[AlertKey]int64 {
+	q.alertsMu.Lock()
+	defer q.alertsMu.Unlock()
+
+	if q.alerts == nil {
+		return nil
+	}
+
+	counts := q.alerts
+	q.alerts = make(map[AlertKey]int64, len(counts))
+
+	return counts
+}
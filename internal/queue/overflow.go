@@ -0,0 +1,369 @@
+package queue
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+)
+
+// OverflowPolicy decides what happens to new alerts when the queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock makes the listener wait until queued events are sent and acknowledged.
+	OverflowBlock OverflowPolicy = "block"
+
+	// OverflowDropOldest drops the oldest queued events to make room.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// OverflowDropLowestPriority drops queued events less important than the new alert to make room,
+	// or the new alert when nothing queued is less important. Snort priority 1 is the most important.
+	OverflowDropLowestPriority OverflowPolicy = "drop-lowest-priority"
+
+	// OverflowSpill moves the oldest queued events to disk to make room.
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// Reasons for dropping events, reported by GetDroppedEvents.
+const (
+	DropReasonOldest         = "oldest"
+	DropReasonLowestPriority = "lowest_priority"
+	DropReasonQueueFull      = "queue_full"
+	DropReasonSpillFailed    = "spill_failed"
+	DropReasonShutdown       = "shutdown"
+)
+
+var dropReasons = []string{DropReasonOldest, DropReasonLowestPriority, DropReasonQueueFull, DropReasonSpillFailed, DropReasonShutdown}
+
+// ParseOverflowPolicy validates the name of an overflow policy.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(name); policy {
+	case OverflowBlock, OverflowDropOldest, OverflowDropLowestPriority, OverflowSpill:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown overflow policy %q, must be one of block, drop-oldest, drop-lowest-priority, spill", name)
+}
+
+// Spiller stores events that do not fit in the queue. It is implemented by the on-disk spool.
+type Spiller interface {
+	Append(events []*pb.SensorEvent) error
+}
+
+// SetSpiller sets where events are moved with the spill policy.
+func (q *EventBatchQueue) SetSpiller(s Spiller) {
+	q.memMu.Lock()
+	defer q.memMu.Unlock()
+
+	q.spiller = s
+}
+
+// full reports whether another size bytes do not fit in the queue. memMu must be held.
+func (q *EventBatchQueue) full(size int) bool {
+	// A single alert is always accepted, or an oversized one would block forever.
+	if q.usedEvents == 0 {
+		return false
+	}
+
+	return (q.limits.MaxQueueBytes > 0 && q.usedBytes+int64(size) > int64(q.limits.MaxQueueBytes)) ||
+		(q.limits.MaxQueueEvents > 0 && q.usedEvents+1 > int64(q.limits.MaxQueueEvents))
+}
+
+// admit reserves room for a new alert of size bytes, applying the overflow policy when the queue is full.
+// It returns false when the alert must be dropped.
+func (q *EventBatchQueue) admit(event *pb.SensorEvent, size int) bool {
+	var spilled []*SensorEventRecord
+	defer func() {
+		if len(spilled) > 0 {
+			q.spill(spilled)
+		}
+	}()
+
+	q.memMu.Lock()
+	defer q.memMu.Unlock()
+
+	for q.full(size) {
+		switch q.limits.Overflow {
+		case OverflowDropOldest, OverflowSpill:
+			victim := q.takeVictimLocked(false, 0)
+			if victim == nil {
+				q.drop(DropReasonQueueFull, 1)
+				return false
+			}
+			if q.limits.Overflow == OverflowSpill && q.spiller != nil {
+				spilled = append(spilled, victim)
+			} else {
+				q.drop(DropReasonOldest, victim.Payload.EventMetricsCount)
+			}
+
+		case OverflowDropLowestPriority:
+			victim := q.takeVictimLocked(true, event.SnortPriority)
+			if victim == nil {
+				q.drop(DropReasonLowestPriority, 1)
+				return false
+			}
+			q.drop(DropReasonLowestPriority, victim.Payload.EventMetricsCount)
+
+		default:
+			if q.closed {
+				// Nothing is sent anymore, keep the alert for the shutdown drain.
+				q.usedBytes += int64(size)
+				q.usedEvents++
+				return true
+			}
+			q.memCond.Wait()
+		}
+	}
+
+	q.usedBytes += int64(size)
+	q.usedEvents++
+
+	return true
+}
+
+// takeVictimLocked removes a record to make room and releases its memory. By priority it is the least
+// important record that is less important than priority, otherwise the oldest record.
+// Records that are being sent cannot be taken. memMu must be held.
+func (q *EventBatchQueue) takeVictimLocked(byPriority bool, priority int64) *SensorEventRecord {
+	for {
+		victim, inReady := q.findVictim(byPriority, priority)
+		if victim == nil {
+			return nil
+		}
+
+		if inReady {
+			q.readyMu.Lock()
+			removed := false
+			for i, record := range q.ready {
+				if record == victim {
+					q.ready = append(q.ready[:i], q.ready[i+1:]...)
+					removed = true
+					break
+				}
+			}
+			q.readyMu.Unlock()
+			if !removed {
+				// The watcher took it in the meantime.
+				continue
+			}
+		} else {
+			victim.mu.Lock()
+			if victim.sealed {
+				victim.mu.Unlock()
+				continue
+			}
+			victim.sealed = true
+			q.queue.CompareAndDelete(victim.Payload.EventHashSha256, victim)
+			victim.mu.Unlock()
+		}
+
+		q.releaseLocked(victim)
+		return victim
+	}
+}
+
+// findVictim returns the record to take and whether it waits in the ready list.
+func (q *EventBatchQueue) findVictim(byPriority bool, priority int64) (*SensorEventRecord, bool) {
+	var victim *SensorEventRecord
+	inReady := false
+
+	better := func(record *SensorEventRecord) bool {
+		if byPriority {
+			p := importance(record.Payload.SnortPriority)
+			if p <= importance(priority) {
+				return false
+			}
+			if victim != nil && p != importance(victim.Payload.SnortPriority) {
+				return p > importance(victim.Payload.SnortPriority)
+			}
+		}
+		return victim == nil || record.CreatedAt.Load() < victim.CreatedAt.Load()
+	}
+
+	q.readyMu.Lock()
+	for _, record := range q.ready {
+		if better(record) {
+			victim, inReady = record, true
+		}
+	}
+	q.readyMu.Unlock()
+
+	q.queue.Range(func(_, value any) bool {
+		record := value.(*SensorEventRecord)
+		if better(record) {
+			victim, inReady = record, false
+		}
+		return true
+	})
+
+	return victim, inReady
+}
+
+// importance orders Snort priorities, a higher value is less important. Priority 1 is the most
+// important and a missing priority (0) is the least important.
+func importance(priority int64) int64 {
+	if priority <= 0 {
+		return math.MaxInt64
+	}
+
+	return priority
+}
+
+// spill moves records that did not fit in the queue to disk.
+func (q *EventBatchQueue) spill(records []*SensorEventRecord) {
+	events := make([]*pb.SensorEvent, 0, len(records))
+	count := int64(0)
+	for _, record := range records {
+		events = append(events, record.Payload)
+		count += record.Payload.EventMetricsCount
+	}
+
+	if err := q.spiller.Append(events); err != nil {
+		log.WithFields(logger.Fields{
+			"error":   err,
+			"package": "queue",
+			"events":  count,
+		}).Errorln("Failed to spill events to disk, dropping them")
+		q.drop(DropReasonSpillFailed, count)
+		return
+	}
+
+	q.totalSpilled.Add(count)
+}
+
+// reserve accounts for memory that was not reserved by admit.
+func (q *EventBatchQueue) reserve(size int) {
+	q.memMu.Lock()
+	defer q.memMu.Unlock()
+
+	q.usedBytes += int64(size)
+}
+
+// release frees the memory of records that were sent.
+func (q *EventBatchQueue) release(records []*SensorEventRecord) {
+	q.memMu.Lock()
+	defer q.memMu.Unlock()
+
+	for _, record := range records {
+		q.releaseLocked(record)
+	}
+}
+
+func (q *EventBatchQueue) releaseLocked(record *SensorEventRecord) {
+	q.usedBytes -= int64(record.size)
+	q.usedEvents -= int64(len(record.Payload.Metrics))
+	q.memCond.Broadcast()
+}
+
+// trackAcks keeps the memory of sent events reserved until they are acknowledged
+// when the sender implements AckNotifier.
+func (q *EventBatchQueue) trackAcks(handler BatchSender) {
+	notifier, ok := handler.(AckNotifier)
+	if !ok {
+		return
+	}
+
+	q.ackOnce.Do(func() {
+		q.unackedMu.Lock()
+		q.unacked = make(map[*pb.SensorEvent]*SensorEventRecord)
+		q.unackedMu.Unlock()
+
+		notifier.SetAckObserver(q.acked)
+	})
+}
+
+// awaitAcks registers records that are about to be sent. They are registered before sending
+// because the acknowledgement may arrive before SendBulkEvent returns.
+func (q *EventBatchQueue) awaitAcks(records []*SensorEventRecord) {
+	q.unackedMu.Lock()
+	defer q.unackedMu.Unlock()
+
+	if q.unacked == nil {
+		return
+	}
+	for _, record := range records {
+		q.unacked[record.Payload] = record
+	}
+}
+
+// forgetAcks unregisters records that were not sent and are queued again.
+func (q *EventBatchQueue) forgetAcks(records []*SensorEventRecord) {
+	q.unackedMu.Lock()
+	defer q.unackedMu.Unlock()
+
+	for _, record := range records {
+		delete(q.unacked, record.Payload)
+	}
+}
+
+// acked frees the memory of a sent event once the server acknowledged it.
+func (q *EventBatchQueue) acked(event *pb.SensorEvent) {
+	q.unackedMu.Lock()
+	record, ok := q.unacked[event]
+	delete(q.unacked, event)
+	q.unackedMu.Unlock()
+
+	if ok {
+		q.release([]*SensorEventRecord{record})
+	}
+}
+
+// releaseSent frees the memory of sent records, unless it is freed once they are acknowledged.
+func (q *EventBatchQueue) releaseSent(records []*SensorEventRecord) {
+	q.unackedMu.Lock()
+	tracked := q.unacked != nil
+	q.unackedMu.Unlock()
+
+	if !tracked {
+		q.release(records)
+	}
+}
+
+// closeAdmission stops blocking listeners once nothing is sent anymore.
+func (q *EventBatchQueue) closeAdmission() {
+	q.memMu.Lock()
+	defer q.memMu.Unlock()
+
+	q.closed = true
+	q.memCond.Broadcast()
+}
+
+func (q *EventBatchQueue) drop(reason string, count int64) {
+	q.dropped[reason].Add(count)
+}
+
+// GetDroppedEvents retrieves the number of events dropped by reason since the last call.
+func (q *EventBatchQueue) GetDroppedEvents() map[string]int64 {
+	dropped := make(map[string]int64, len(q.dropped))
+	for reason, count := range q.dropped {
+		dropped[reason] = count.Swap(0)
+	}
+
+	return dropped
+}
+
+// GetSpilledEvents retrieves the number of events spilled to disk since the last call.
+func (q *EventBatchQueue) GetSpilledEvents() int64 {
+	return q.totalSpilled.Swap(0)
+}
+
+// GetQueueBytes retrieves the serialized size of the events held by the queue,
+// including the batch being sent and the events waiting for an acknowledgement.
+func (q *EventBatchQueue) GetQueueBytes() int64 {
+	q.memMu.Lock()
+	defer q.memMu.Unlock()
+
+	return q.usedBytes
+}
+
+func newDropCounters() map[string]*atomic.Int64 {
+	dropped := make(map[string]*atomic.Int64, len(dropReasons))
+	for _, reason := range dropReasons {
+		dropped[reason] = &atomic.Int64{}
+	}
+
+	return dropped
+}
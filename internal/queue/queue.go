@@ -2,137 +2,527 @@ package queue
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/mata-elang-stable/sensor-snort-service/internal/output/grpc"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/util"
 
 	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/output"
 	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 )
 
 var log = logger.GetLogger()
 
+// BatchSender delivers a batch of sensor events.
+// It is implemented by the gRPC stream manager and by the on-disk spool.
+type BatchSender interface {
+	SendBulkEvent(ctx context.Context, events []*pb.SensorEvent) (int64, error)
+}
+
+// AckNotifier is implemented by senders that keep the events they sent until the server acknowledges them.
+// The queue keeps such events counted against MaxQueueBytes and MaxQueueEvents until they are acknowledged,
+// so that a server that stops acknowledging applies the overflow policy instead of growing memory.
+type AckNotifier interface {
+	SetAckObserver(o func(event *pb.SensorEvent))
+}
+
+// Limits bound how long alerts are aggregated into a single sensor event and how large it may grow.
+// A zero value disables the limit.
+type Limits struct {
+	// IdleWindow is how long a record must go without new alerts before it is sent.
+	IdleWindow time.Duration
+
+	// MaxAge is how long a record may aggregate alerts before it is sent, even when alerts keep arriving.
+	MaxAge time.Duration
+
+	// MaxMetrics is the maximum number of metrics in a single sensor event.
+	MaxMetrics int
+
+	// MaxBytes is the maximum serialized size of a single sensor event.
+	MaxBytes int
+
+	// MaxQueueBytes is the maximum serialized size of all events held by the queue,
+	// including the batch that is being sent and the events waiting for an acknowledgement.
+	MaxQueueBytes int
+
+	// MaxQueueEvents is the maximum number of alerts held by the queue, including the batch that is being sent
+	// and the events waiting for an acknowledgement.
+	MaxQueueEvents int
+
+	// Overflow is what happens to new alerts when MaxQueueBytes or MaxQueueEvents is reached.
+	Overflow OverflowPolicy
+}
+
+// DefaultLimits returns the limits used by NewEventBatchQueue.
+func DefaultLimits() Limits {
+	return Limits{
+		IdleWindow: time.Second,
+		Overflow:   OverflowBlock,
+	}
+}
+
+const (
+	// maxFlushInterval is the longest time between two checks for records to send.
+	maxFlushInterval = time.Second
+
+	// minFlushInterval keeps tiny windows from turning the watcher into a busy loop.
+	minFlushInterval = 10 * time.Millisecond
+
+	// eventSizeSlack covers the growth of event_metrics_count, which is not part of the tracked size.
+	eventSizeSlack = binary.MaxVarintLen64
+)
+
+// metricsFieldNumber is the field number of SensorEvent.metrics.
+var metricsFieldNumber = (&pb.SensorEvent{}).ProtoReflect().Descriptor().Fields().ByName("metrics").Number()
+
 // SensorEventRecord represents a sensor event record.
 type SensorEventRecord struct {
 	Payload   *pb.SensorEvent
 	CreatedAt atomic.Int64
 	UpdatedAt atomic.Int64
 	mu        sync.Mutex
+
+	// size is the serialized size of Payload.
+	size int
+
+	// sealed is set once the record is taken out of the queue, it must not receive more metrics.
+	sealed bool
 }
 
 // EventBatchQueue represents a queue for storing sensor event records.
 type EventBatchQueue struct {
-	delta                int
+	limits               Limits
 	queue                sync.Map
 	latestEventPerSec    atomic.Int64
 	EventThisSec         atomic.Int64
 	latestBatchPerSec    atomic.Int64
 	BatchThisSec         atomic.Int64
 	TotalSentEvents      atomic.Int64
+	TotalFailedEvents    atomic.Int64
 	TotalProcessedEvents atomic.Int64
+
+	// ready holds records that are taken out of the queue and wait to be sent.
+	readyMu  sync.Mutex
+	ready    []*SensorEventRecord
+	flushNow chan struct{}
+
+	// memMu guards the memory accounting of the records that are queued, ready, being sent or not acknowledged yet.
+	memMu        sync.Mutex
+	memCond      *sync.Cond
+	usedBytes    int64
+	usedEvents   int64
+	closed       bool
+	spiller      Spiller
+	dropped      map[string]*atomic.Int64
+	totalSpilled atomic.Int64
+
+	// unacked holds the sent records that wait for an acknowledgement, by payload.
+	// It is nil unless the sender implements AckNotifier.
+	unackedMu sync.Mutex
+	unacked   map[*pb.SensorEvent]*SensorEventRecord
+	ackOnce   sync.Once
+
+	// alerts counts the alerts added by rule, it is nil unless alert counting is enabled.
+	alertsMu sync.Mutex
+	alerts   map[AlertKey]int64
 }
 
-// NewEventBatchQueue creates a new instance of EventBatchQueue.
+// NewEventBatchQueue creates a new instance of EventBatchQueue with the default limits.
 func NewEventBatchQueue() *EventBatchQueue {
-	return &EventBatchQueue{
-		delta: 1,
+	return NewEventBatchQueueWithLimits(DefaultLimits())
+}
+
+// NewEventBatchQueueWithLimits creates a new instance of EventBatchQueue.
+func NewEventBatchQueueWithLimits(limits Limits) *EventBatchQueue {
+	if limits.IdleWindow <= 0 {
+		limits.IdleWindow = DefaultLimits().IdleWindow
 	}
+
+	q := &EventBatchQueue{
+		limits:   limits,
+		flushNow: make(chan struct{}, 1),
+		dropped:  newDropCounters(),
+	}
+	q.memCond = sync.NewCond(&q.memMu)
+
+	return q
 }
 
 // AddRecordToQueue adds a sensor event record to the queue.
 // If the record already exists, it will update the record with the new metric.
 // The record is identified by the SHA256 hash of the metadata.
+// A record that reaches the maximum number of metrics or the maximum size is sent right away,
+// and later alerts with the same hash start a new record.
+// When the queue is full the overflow policy decides whether the call blocks or an alert is dropped.
+// Dropped alerts are still counted by GetAlertCounts.
 func (q *EventBatchQueue) AddRecordToQueue(pbRecord *pb.SensorEvent, metric *pb.Metric) {
-	now := time.Now().Unix()
-	newEventRecord := &SensorEventRecord{Payload: pbRecord}
-	newEventRecord.CreatedAt.Store(now)
-
-	selectedRecord, _ := q.queue.LoadOrStore(pbRecord.EventHashSha256, newEventRecord)
-	record := selectedRecord.(*SensorEventRecord)
-	record.mu.Lock()
-	record.Payload.Metrics = append(record.Payload.Metrics, metric)
-	record.Payload.EventMetricsCount = int64(len(record.Payload.Metrics))
-	record.UpdatedAt.Store(now)
-	record.mu.Unlock()
+	q.countAlert(pbRecord)
+
+	metricSize := protowire.SizeTag(metricsFieldNumber) + protowire.SizeBytes(proto.Size(metric))
+	if !q.admit(pbRecord, metricSize) {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	baseSize := 0
+
+	for {
+		newEventRecord := &SensorEventRecord{Payload: pbRecord}
+		newEventRecord.CreatedAt.Store(now)
+
+		selectedRecord, _ := q.queue.LoadOrStore(pbRecord.EventHashSha256, newEventRecord)
+		record := selectedRecord.(*SensorEventRecord)
+		record.mu.Lock()
+
+		if record.sealed {
+			// The record is being sent, start a new one.
+			record.mu.Unlock()
+			q.queue.CompareAndDelete(pbRecord.EventHashSha256, record)
+			continue
+		}
+		if record.size == 0 {
+			baseSize = proto.Size(record.Payload) + eventSizeSlack
+			record.size = baseSize
+		}
+
+		if q.limits.MaxBytes > 0 && len(record.Payload.Metrics) > 0 && record.size+metricSize > q.limits.MaxBytes {
+			q.sealLocked(pbRecord.EventHashSha256, record, "max_bytes")
+			record.mu.Unlock()
+			continue
+		}
+
+		record.Payload.Metrics = append(record.Payload.Metrics, metric)
+		record.Payload.EventMetricsCount = int64(len(record.Payload.Metrics))
+		record.size += metricSize
+		record.UpdatedAt.Store(now)
+
+		if q.limits.MaxBytes > 0 && record.size > q.limits.MaxBytes {
+			log.WithFields(logger.Fields{
+				"package": "queue",
+				"hash":    pbRecord.EventHashSha256,
+				"size":    record.size,
+			}).Warnln("Single alert exceeds the maximum event size")
+		}
+		if q.limits.MaxMetrics > 0 && len(record.Payload.Metrics) >= q.limits.MaxMetrics {
+			q.sealLocked(pbRecord.EventHashSha256, record, "max_metrics")
+		}
+
+		record.mu.Unlock()
+		break
+	}
+
+	if baseSize > 0 {
+		q.reserve(baseSize)
+	}
 
 	q.EventThisSec.Add(1)
 }
 
+// sealLocked takes a record out of the queue and hands it to the watcher to be sent right away.
+// The record must be locked by the caller.
+func (q *EventBatchQueue) sealLocked(key string, record *SensorEventRecord, reason string) {
+	record.sealed = true
+	q.queue.CompareAndDelete(key, record)
+
+	log.WithFields(logger.Fields{
+		"package": "queue",
+		"hash":    key,
+		"metrics": record.Payload.EventMetricsCount,
+		"reason":  reason,
+	}).Debugln("Flushing event before it is idle")
+
+	q.readyMu.Lock()
+	q.ready = append(q.ready, record)
+	q.readyMu.Unlock()
+	q.updateMetricsCounter(record.Payload.EventMetricsCount)
+
+	select {
+	case q.flushNow <- struct{}{}:
+	default:
+	}
+}
+
+// takeReady returns the records that wait to be sent.
+func (q *EventBatchQueue) takeReady() []*SensorEventRecord {
+	q.readyMu.Lock()
+	defer q.readyMu.Unlock()
+
+	ready := q.ready
+	q.ready = nil
+
+	return ready
+}
+
+// flushInterval is how often the queue is checked for records that are idle or too old.
+func (q *EventBatchQueue) flushInterval() time.Duration {
+	interval := min(q.limits.IdleWindow, maxFlushInterval)
+	if q.limits.MaxAge > 0 {
+		interval = min(interval, q.limits.MaxAge)
+	}
+
+	return max(interval, minFlushInterval)
+}
+
 // StartWatcher starts a watcher to process the queue.
-// The watcher will send the sensor events to the channel if the record is already older than the delta time.
-func (q *EventBatchQueue) StartWatcher(ctx context.Context, handler *grpc.StreamManager) error {
+// The watcher will send the sensor events to the handler once a record is idle for the idle window,
+// older than the maximum age, or has reached the maximum number of metrics or the maximum size.
+// One batch is sent at a time; records that become ready meanwhile are sent together in the next one.
+func (q *EventBatchQueue) StartWatcher(ctx context.Context, handler BatchSender) error {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	var wg sync.WaitGroup
+	flushTicker := time.NewTicker(q.flushInterval())
+	defer flushTicker.Stop()
+
+	defer q.closeAdmission()
+
+	q.trackAcks(handler)
+
+	sendDone := make(chan sendResult, 1)
+	sending := false
+
+	send := func() {
+		if sending {
+			return
+		}
+
+		records := q.takeReady()
+		if len(records) == 0 {
+			return
+		}
+
+		eventsBatch := make([]*pb.SensorEvent, 0, len(records))
+		for _, record := range records {
+			eventsBatch = append(eventsBatch, record.Payload)
+		}
+
+		sending = true
+		q.awaitAcks(records)
+		go func() {
+			totalEvent, err := handler.SendBulkEvent(ctx, eventsBatch)
+			q.TotalSentEvents.Add(totalEvent)
+
+			sent, unsent := q.requeueUnsent(records, err)
+			q.forgetAcks(unsent)
+			if len(unsent) > 0 && ctx.Err() == nil {
+				log.WithFields(logger.Fields{
+					"error":   err,
+					"package": "queue",
+					"events":  len(unsent),
+				}).Warnln("Failed to send batch, the events are queued again")
+			}
+
+			sendDone <- sendResult{sent: sent, failed: len(unsent) > 0}
+		}()
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			// Context is done, wait for all goroutines to finish and return
+			// Context is done, wait for the batch being sent and return
 			log.WithField("package", "queue").Infof("Stopping watcher due to context cancellation")
-			wg.Wait()
+			if sending {
+				q.releaseSent((<-sendDone).sent)
+			}
 			return nil
 		case <-ticker.C:
 			util.UpdateAndReset(&q.latestEventPerSec, &q.EventThisSec)
 			util.UpdateAndReset(&q.latestBatchPerSec, &q.BatchThisSec)
-
-			eventsBatch := q.processQueue()
-
-			if len(eventsBatch) > 0 {
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					totalEvent, err := handler.SendBulkEvent(ctx, eventsBatch)
-					if err != nil {
-						log.WithField("package", "queue").Errorf("Failed to send data to gRPC server: %v", err)
-					}
-					q.TotalSentEvents.Add(totalEvent)
-				}()
+		case result := <-sendDone:
+			sending = false
+			q.releaseSent(result.sent)
+			// After a failure the next batch waits for the next check instead of retrying right away.
+			if !result.failed {
+				send()
 			}
+		case <-q.flushNow:
+			send()
+		case <-flushTicker.C:
+			q.processQueue()
+			send()
+		}
+	}
+}
+
+// drainRetryInterval is the wait between two attempts to send a batch that failed during the drain.
+const drainRetryInterval = 100 * time.Millisecond
+
+// Drain sends every record left in the queue, regardless of the idle window and the maximum age,
+// until the queue is empty or the context is done. It is called once the listener and the watcher
+// have stopped. What cannot be sent in time is spilled when a spiller is set, otherwise it is
+// dropped. It returns the number of events that were not sent.
+func (q *EventBatchQueue) Drain(ctx context.Context, handler BatchSender) int64 {
+	q.trackAcks(handler)
+
+	for {
+		q.sealAll()
+
+		records := q.takeReady()
+		if len(records) == 0 {
+			return 0
+		}
+
+		eventsBatch := make([]*pb.SensorEvent, 0, len(records))
+		for _, record := range records {
+			eventsBatch = append(eventsBatch, record.Payload)
+		}
+
+		q.awaitAcks(records)
+		totalEvent, err := handler.SendBulkEvent(ctx, eventsBatch)
+		q.TotalSentEvents.Add(totalEvent)
+
+		sent, unsent := q.requeueUnsent(records, err)
+		q.forgetAcks(unsent)
+		q.releaseSent(sent)
+		if len(unsent) == 0 {
+			continue
 		}
+
+		select {
+		case <-ctx.Done():
+			return q.discardRemaining()
+		case <-time.After(drainRetryInterval):
+		}
+	}
+}
+
+// sealAll moves every record in the queue to the ready list.
+func (q *EventBatchQueue) sealAll() {
+	q.queue.Range(func(key, value any) bool {
+		record := value.(*SensorEventRecord)
+
+		record.mu.Lock()
+		defer record.mu.Unlock()
+
+		if !record.sealed {
+			q.sealLocked(key.(string), record, "drain")
+		}
+
+		return true
+	})
+}
+
+// discardRemaining spills or drops the records that are left after the drain and returns their number of events.
+func (q *EventBatchQueue) discardRemaining() int64 {
+	q.sealAll()
+	records := q.takeReady()
+
+	left := int64(0)
+	for _, record := range records {
+		left += record.Payload.EventMetricsCount
+	}
+	if left == 0 {
+		return 0
+	}
+
+	q.release(records)
+
+	q.memMu.Lock()
+	spiller := q.spiller
+	q.memMu.Unlock()
+
+	if spiller != nil {
+		q.spill(records)
+	} else {
+		q.drop(DropReasonShutdown, left)
 	}
+
+	return left
+}
+
+// sendResult is the outcome of sending a batch.
+type sendResult struct {
+	// sent are the records that left the queue.
+	sent []*SensorEventRecord
+
+	// failed is set when records were queued again.
+	failed bool
 }
 
-func (q *EventBatchQueue) processQueue() []*pb.SensorEvent {
-	now := time.Now().Unix()
+// requeueUnsent puts the records of a batch that were not sent back in front of the ready list.
+// It returns the records that left the queue and the ones that were queued again.
+// Without an output.UnsentError nothing of a failed batch was sent.
+func (q *EventBatchQueue) requeueUnsent(records []*SensorEventRecord, err error) ([]*SensorEventRecord, []*SensorEventRecord) {
+	if err == nil {
+		return records, nil
+	}
+
+	sent, unsent := records[:0:0], records
+	var unsentErr *output.UnsentError
+	if errors.As(err, &unsentErr) {
+		events := make(map[*pb.SensorEvent]bool, len(unsentErr.Unsent))
+		for _, event := range unsentErr.Unsent {
+			events[event] = true
+		}
+
+		unsent = make([]*SensorEventRecord, 0, len(unsentErr.Unsent))
+		for _, record := range records {
+			if events[record.Payload] {
+				unsent = append(unsent, record)
+			} else {
+				sent = append(sent, record)
+			}
+		}
+	}
+	if len(unsent) == 0 {
+		return sent, nil
+	}
+
+	failed := int64(0)
+	for _, record := range unsent {
+		failed += record.Payload.EventMetricsCount
+	}
+	q.TotalFailedEvents.Add(failed)
+
+	q.readyMu.Lock()
+	q.ready = append(unsent[:len(unsent):len(unsent)], q.ready...)
+	q.readyMu.Unlock()
+
+	return sent, unsent
+}
 
-	eventsBatch := make([]*pb.SensorEvent, 0)
+// processQueue moves the records that are idle or too old to the ready list.
+func (q *EventBatchQueue) processQueue() {
+	now := time.Now().UnixNano()
+	idleWindow := q.limits.IdleWindow.Nanoseconds()
+	maxAge := q.limits.MaxAge.Nanoseconds()
 
 	q.queue.Range(func(key, value any) bool {
 		record := value.(*SensorEventRecord)
-		updatedAt := record.UpdatedAt.Load()
 
-		if now <= updatedAt+int64(q.delta) {
+		idle := now-record.UpdatedAt.Load() >= idleWindow
+		expired := maxAge > 0 && now-record.CreatedAt.Load() >= maxAge
+		if !idle && !expired {
 			return true
 		}
 
 		record.mu.Lock()
-		payloadCopy := *record.Payload
-		payloadCopy.Metrics = append([]*pb.Metric{}, record.Payload.Metrics...)
-		eventMetricsCount := record.Payload.EventMetricsCount
-		record.mu.Unlock()
+		defer record.mu.Unlock()
 
-		//ch <- &payloadCopy
-
-		eventsBatch = append(eventsBatch, &payloadCopy)
+		if record.sealed {
+			return true
+		}
+		record.sealed = true
+		q.queue.CompareAndDelete(key, record)
 
-		q.updateMetricsCounter(eventMetricsCount)
-		q.queue.Delete(key)
+		q.readyMu.Lock()
+		q.ready = append(q.ready, record)
+		q.readyMu.Unlock()
+		q.updateMetricsCounter(record.Payload.EventMetricsCount)
 
 		return true
 	})
-
-	return eventsBatch
 }
 
 func (q *EventBatchQueue) updateMetricsCounter(metricsCount int64) {
 	q.BatchThisSec.Add(1)
-	q.TotalSentEvents.Add(metricsCount)
-	q.TotalProcessedEvents.Store(metricsCount)
+	q.TotalProcessedEvents.Add(metricsCount)
 }
 
 // GetEventProcessedPerSecond retrieves the latest event per second.
@@ -150,6 +540,11 @@ func (q *EventBatchQueue) GetTotalSentEvents() int64 {
 	return q.TotalSentEvents.Swap(0)
 }
 
+// GetTotalFailedEvents retrieves the number of events that failed to send and were queued again since the last call.
+func (q *EventBatchQueue) GetTotalFailedEvents() int64 {
+	return q.TotalFailedEvents.Swap(0)
+}
+
 // GetTotalProcessedEvents retrieves the total number of processed events.
 func (q *EventBatchQueue) GetTotalProcessedEvents() int64 {
 	return q.TotalProcessedEvents.Swap(0)
@@ -0,0 +1,420 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/output"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeSender struct {
+	mu     sync.Mutex
+	events []*pb.SensorEvent
+}
+
+func (f *fakeSender) SendBulkEvent(_ context.Context, events []*pb.SensorEvent) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	total := int64(0)
+	for _, event := range events {
+		f.events = append(f.events, event)
+		total += event.EventMetricsCount
+	}
+
+	return total, nil
+}
+
+func (f *fakeSender) sent() []*pb.SensorEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*pb.SensorEvent(nil), f.events...)
+}
+
+func Test_EventBatchQueueLimits(t *testing.T) {
+	metric := func() *pb.Metric {
+		data := "XfJuK3a9vcXc5/B85kIOOH4u5HNvuXFIL56mFmingSiQN5kB6UYxEfY0VdUHR6gER5VW4pKmhg7o+uXq/ahgSro/osIgWnnbktyE"
+		return &pb.Metric{SnortTimestamp: "24/10/10-05:32:11.000107", SnortBase64Data: &data}
+	}
+
+	tests := []struct {
+		name   string
+		limits Limits
+		alerts int
+		// interval is the time between two alerts.
+		interval time.Duration
+		// within is how long the alerts may take to be sent.
+		within      time.Duration
+		wantEvents  int
+		wantMetrics int
+	}{
+		{
+			name:        "Must aggregate alerts until the record is idle",
+			limits:      Limits{IdleWindow: 100 * time.Millisecond},
+			alerts:      10,
+			within:      time.Second,
+			wantEvents:  1,
+			wantMetrics: 10,
+		},
+		{
+			name:        "Must flush when the maximum number of metrics is reached",
+			limits:      Limits{IdleWindow: time.Hour, MaxMetrics: 4},
+			alerts:      8,
+			within:      time.Second,
+			wantEvents:  2,
+			wantMetrics: 4,
+		},
+		{
+			name:        "Must flush before the maximum size is exceeded",
+			limits:      Limits{IdleWindow: time.Hour, MaxBytes: 1024},
+			alerts:      20,
+			within:      time.Second,
+			wantEvents:  2,
+			wantMetrics: 0,
+		},
+		{
+			name:        "Must flush when the record is too old even if alerts keep arriving",
+			limits:      Limits{IdleWindow: time.Hour, MaxAge: 100 * time.Millisecond},
+			alerts:      20,
+			interval:    20 * time.Millisecond,
+			within:      2 * time.Second,
+			wantEvents:  2,
+			wantMetrics: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewEventBatchQueueWithLimits(tt.limits)
+			sender := &fakeSender{}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_ = q.StartWatcher(ctx, sender)
+			}()
+
+			for i := 0; i < tt.alerts; i++ {
+				q.AddRecordToQueue(&pb.SensorEvent{EventHashSha256: "v1:flood", SensorId: "sensor1"}, metric())
+				time.Sleep(tt.interval)
+			}
+
+			deadline := time.Now().Add(tt.within)
+			for len(sender.sent()) < tt.wantEvents && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			events := sender.sent()
+			if len(events) < tt.wantEvents {
+				t.Fatalf("Expected at least %d events to be sent, got %d", tt.wantEvents, len(events))
+			}
+			if tt.wantMetrics > 0 && events[0].EventMetricsCount != int64(tt.wantMetrics) {
+				t.Errorf("Expected %d metrics in the first event, got %d", tt.wantMetrics, events[0].EventMetricsCount)
+			}
+			if tt.limits.MaxBytes > 0 {
+				for _, event := range events {
+					if size := proto.Size(event); size > tt.limits.MaxBytes {
+						t.Errorf("Expected events of at most %d bytes, got %d", tt.limits.MaxBytes, size)
+					}
+				}
+			}
+
+			// Alerts must not be lost when a record is flushed while alerts arrive.
+			metrics := int64(0)
+			for _, event := range events {
+				if int(event.EventMetricsCount) != len(event.Metrics) {
+					t.Errorf("EventMetricsCount = %d, want %d", event.EventMetricsCount, len(event.Metrics))
+				}
+				metrics += event.EventMetricsCount
+			}
+			if pending := int64(q.GetEventQueueSize()); metrics+pending != int64(tt.alerts) {
+				t.Errorf("Expected %d alerts sent or queued, got %d sent and %d queued", tt.alerts, metrics, pending)
+			}
+		})
+	}
+}
+
+type fakeSpiller struct {
+	events []*pb.SensorEvent
+}
+
+func (f *fakeSpiller) Append(events []*pb.SensorEvent) error {
+	f.events = append(f.events, events...)
+	return nil
+}
+
+func Test_EventBatchQueueOverflow(t *testing.T) {
+	type alert struct {
+		hash     string
+		priority int64
+	}
+	alerts := []alert{{"a", 3}, {"b", 1}, {"c", 3}, {"d", 2}, {"e", 1}, {"f", 3}}
+
+	tests := []struct {
+		name        string
+		policy      OverflowPolicy
+		wantQueued  []string
+		wantDropped map[string]int64
+		wantSpilled int64
+	}{
+		{
+			name:        "Must drop the oldest events",
+			policy:      OverflowDropOldest,
+			wantQueued:  []string{"d", "e", "f"},
+			wantDropped: map[string]int64{DropReasonOldest: 3},
+		},
+		{
+			name:        "Must drop the least important events first",
+			policy:      OverflowDropLowestPriority,
+			wantQueued:  []string{"b", "d", "e"},
+			wantDropped: map[string]int64{DropReasonLowestPriority: 3},
+		},
+		{
+			name:        "Must spill the oldest events to disk",
+			policy:      OverflowSpill,
+			wantQueued:  []string{"d", "e", "f"},
+			wantDropped: map[string]int64{},
+			wantSpilled: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewEventBatchQueueWithLimits(Limits{IdleWindow: time.Hour, MaxQueueEvents: 3, Overflow: tt.policy})
+			spiller := &fakeSpiller{}
+			q.SetSpiller(spiller)
+
+			for _, a := range alerts {
+				q.AddRecordToQueue(&pb.SensorEvent{EventHashSha256: a.hash, SnortPriority: a.priority}, &pb.Metric{})
+			}
+
+			queued := make([]string, 0)
+			for _, a := range alerts {
+				if _, ok := q.queue.Load(a.hash); ok {
+					queued = append(queued, a.hash)
+				}
+			}
+			if !reflect.DeepEqual(queued, tt.wantQueued) {
+				t.Errorf("Queued events = %v, want %v", queued, tt.wantQueued)
+			}
+
+			for reason, got := range q.GetDroppedEvents() {
+				if got != tt.wantDropped[reason] {
+					t.Errorf("Dropped events for %s = %d, want %d", reason, got, tt.wantDropped[reason])
+				}
+			}
+			if got := q.GetSpilledEvents(); got != tt.wantSpilled || int64(len(spiller.events)) != tt.wantSpilled {
+				t.Errorf("Spilled events = %d, want %d", got, tt.wantSpilled)
+			}
+		})
+	}
+}
+
+func Test_EventBatchQueueOverflowBlock(t *testing.T) {
+	q := NewEventBatchQueueWithLimits(Limits{IdleWindow: 50 * time.Millisecond, MaxQueueEvents: 2, Overflow: OverflowBlock})
+
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		for _, hash := range []string{"a", "b", "c"} {
+			q.AddRecordToQueue(&pb.SensorEvent{EventHashSha256: hash}, &pb.Metric{})
+		}
+	}()
+
+	select {
+	case <-added:
+		t.Fatal("Expected the third alert to wait until the queue has room")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sender := &fakeSender{}
+	go func() {
+		_ = q.StartWatcher(ctx, sender)
+	}()
+
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the alert to be added once queued events are sent")
+	}
+}
+
+// ackingSender keeps the events it sent until ackAll is called, like a server that acknowledges late.
+type ackingSender struct {
+	fakeSender
+	observer func(event *pb.SensorEvent)
+}
+
+func (f *ackingSender) SetAckObserver(o func(event *pb.SensorEvent)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.observer = o
+}
+
+func (f *ackingSender) ackAll() {
+	f.mu.Lock()
+	events, observer := f.events, f.observer
+	f.events = nil
+	f.mu.Unlock()
+
+	for _, event := range events {
+		observer(event)
+	}
+}
+
+func Test_EventBatchQueueCountsUnackedEvents(t *testing.T) {
+	q := NewEventBatchQueueWithLimits(Limits{IdleWindow: 20 * time.Millisecond, MaxQueueEvents: 2, Overflow: OverflowBlock})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sender := &ackingSender{}
+	go func() {
+		_ = q.StartWatcher(ctx, sender)
+	}()
+
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		for _, hash := range []string{"a", "b", "c"} {
+			q.AddRecordToQueue(&pb.SensorEvent{EventHashSha256: hash}, &pb.Metric{})
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sender.sent()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-added:
+		t.Fatal("Expected the third alert to wait until the sent events are acknowledged")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if got := q.GetQueueBytes(); got == 0 {
+		t.Error("Expected the unacknowledged events to be counted in the queue size")
+	}
+
+	sender.ackAll()
+
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the alert to be added once the sent events are acknowledged")
+	}
+}
+
+// flakySender gives up on all but the first event of the first batch.
+type flakySender struct {
+	fakeSender
+	failed bool
+}
+
+func (f *flakySender) SendBulkEvent(ctx context.Context, events []*pb.SensorEvent) (int64, error) {
+	if !f.failed && len(events) > 1 {
+		f.failed = true
+		total, _ := f.fakeSender.SendBulkEvent(ctx, events[:1])
+		return total, &output.UnsentError{Unsent: events[1:], Err: errors.New("unavailable")}
+	}
+
+	return f.fakeSender.SendBulkEvent(ctx, events)
+}
+
+func Test_EventBatchQueueRequeuesUnsentEvents(t *testing.T) {
+	q := NewEventBatchQueueWithLimits(Limits{IdleWindow: 20 * time.Millisecond})
+	for _, hash := range []string{"a", "b", "c"} {
+		q.AddRecordToQueue(&pb.SensorEvent{EventHashSha256: hash}, &pb.Metric{})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sender := &flakySender{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = q.StartWatcher(ctx, sender)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sender.sent()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	hashes := make(map[string]int)
+	for _, event := range sender.sent() {
+		hashes[event.EventHashSha256]++
+	}
+	if len(hashes) != 3 || len(sender.sent()) != 3 {
+		t.Errorf("Expected every event to be sent once, got %v", hashes)
+	}
+	if got := q.GetTotalSentEvents(); got != 3 {
+		t.Errorf("Expected 3 sent events, got %d", got)
+	}
+	if got := q.GetTotalFailedEvents(); got != 2 {
+		t.Errorf("Expected 2 failed events, got %d", got)
+	}
+	if got := q.GetQueueBytes(); got != 0 {
+		t.Errorf("Expected the queue to be empty, got %d bytes", got)
+	}
+}
+
+// downSender never reaches the server.
+type downSender struct{}
+
+func (downSender) SendBulkEvent(_ context.Context, events []*pb.SensorEvent) (int64, error) {
+	return 0, &output.UnsentError{Unsent: events, Err: errors.New("unavailable")}
+}
+
+func Test_EventBatchQueueDrain(t *testing.T) {
+	t.Run("Must send records that are not idle yet", func(t *testing.T) {
+		q := NewEventBatchQueueWithLimits(Limits{IdleWindow: time.Hour})
+		for _, hash := range []string{"a", "b", "a"} {
+			q.AddRecordToQueue(&pb.SensorEvent{EventHashSha256: hash}, &pb.Metric{})
+		}
+
+		sender := &fakeSender{}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		if left := q.Drain(ctx, sender); left != 0 {
+			t.Errorf("Expected no events left, got %d", left)
+		}
+		if got := len(sender.sent()); got != 2 {
+			t.Errorf("Expected 2 events to be sent, got %d", got)
+		}
+		if got := q.GetQueueBytes(); got != 0 {
+			t.Errorf("Expected the queue to be empty, got %d bytes", got)
+		}
+	})
+
+	t.Run("Must count the events left when the deadline passes", func(t *testing.T) {
+		q := NewEventBatchQueueWithLimits(Limits{IdleWindow: time.Hour})
+		for _, hash := range []string{"a", "b", "a"} {
+			q.AddRecordToQueue(&pb.SensorEvent{EventHashSha256: hash}, &pb.Metric{})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+
+		if left := q.Drain(ctx, downSender{}); left != 3 {
+			t.Errorf("Expected 3 events left, got %d", left)
+		}
+		if got := q.GetDroppedEvents()[DropReasonShutdown]; got != 3 {
+			t.Errorf("Expected 3 events dropped on shutdown, got %d", got)
+		}
+		if got := q.GetQueueBytes(); got != 0 {
+			t.Errorf("Expected the queue to be empty, got %d bytes", got)
+		}
+	})
+}
@@ -0,0 +1,426 @@
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/logger"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/output"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+var log = logger.GetLogger()
+
+const (
+	// segmentExt is the extension of a committed segment file.
+	segmentExt = ".seg"
+
+	// tmpExt is the extension of a segment that is still being written.
+	tmpExt = ".tmp"
+
+	// corruptExt is the extension given to segments that cannot be decoded.
+	corruptExt = ".corrupt"
+
+	// segmentMagic is written at the start of every segment file.
+	segmentMagic = "MESSPL01"
+)
+
+// ErrCorruptSegment is returned when a segment file cannot be decoded.
+var ErrCorruptSegment = errors.New("corrupt spool segment")
+
+// Sender delivers a batch of sensor events to the server.
+type Sender interface {
+	SendBulkEvent(ctx context.Context, events []*pb.SensorEvent) (int64, error)
+}
+
+// AckWaiter is implemented by senders that keep events until the server acknowledges them.
+type AckWaiter interface {
+	// WaitAcked waits until the server has acknowledged every given event or the context is done.
+	WaitAcked(ctx context.Context, events []*pb.SensorEvent) error
+}
+
+// Spool is a write-ahead store for sensor event batches.
+// Every batch is written to its own segment file on disk and the segment is
+// deleted only after the sender has accepted all of its events, and the server
+// has acknowledged them when the sender is an AckWaiter.
+type Spool struct {
+	dir           string
+	retryInterval time.Duration
+	mu            sync.Mutex
+	seq           uint64
+	notify        chan struct{}
+
+	pendingSegments atomic.Int64
+	TotalSpooled    atomic.Int64
+	TotalDelivered  atomic.Int64
+}
+
+// NewSpool opens or creates the spool directory.
+// Segments left over from a previous run are kept and will be replayed by Start.
+func NewSpool(dir string, retryInterval time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{
+		dir:           dir,
+		retryInterval: retryInterval,
+		notify:        make(chan struct{}, 1),
+	}
+
+	// Remove segments that were never committed, they are incomplete by definition.
+	tmpFiles, err := filepath.Glob(filepath.Join(dir, "*"+tmpExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range tmpFiles {
+		if err := os.Remove(name); err != nil {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "spool",
+				"segment": name,
+			}).Warnln("failed to remove incomplete segment")
+		}
+	}
+
+	segments, err := s.Segments()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range segments {
+		if seq, err := parseSegmentSeq(name); err == nil && seq >= s.seq {
+			s.seq = seq + 1
+		}
+	}
+	s.pendingSegments.Store(int64(len(segments)))
+
+	if len(segments) > 0 {
+		log.WithFields(logger.Fields{
+			"package":  "spool",
+			"dir":      dir,
+			"segments": len(segments),
+		}).Infoln("Found spooled segments from a previous run, they will be replayed.")
+	}
+
+	return s, nil
+}
+
+// SendBulkEvent persists the batch as a new segment.
+// It satisfies the same contract as the gRPC stream manager so the spool can be
+// placed in front of it; delivery happens asynchronously in Start. As nothing is
+// sent yet, it returns zero; the spooled events are counted by GetTotalSpooled.
+func (s *Spool) SendBulkEvent(_ context.Context, events []*pb.SensorEvent) (int64, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	if err := s.Append(events); err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// Append writes the events to a new segment file and counts them as spooled.
+// The segment is written to a temporary file, synced, and then renamed so a
+// crash never leaves a partially written segment behind.
+func (s *Spool) Append(events []*pb.SensorEvent) error {
+	s.mu.Lock()
+	seq := s.seq
+	s.seq++
+	s.mu.Unlock()
+
+	name := filepath.Join(s.dir, segmentName(seq))
+	tmpName := name + tmpExt
+
+	if err := writeSegment(tmpName, events); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Rename(tmpName, name); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("failed to commit spool segment: %w", err)
+	}
+
+	syncDir(s.dir)
+
+	total := int64(0)
+	for _, event := range events {
+		total += event.EventMetricsCount
+	}
+	s.TotalSpooled.Add(total)
+
+	s.pendingSegments.Add(1)
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Segments returns the committed segment files, oldest first.
+func (s *Spool) Segments() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+// Read decodes all events stored in the segment file.
+func (s *Spool) Read(name string) ([]*pb.SensorEvent, error) {
+	return readSegment(name)
+}
+
+// Remove deletes a delivered segment.
+func (s *Spool) Remove(name string) error {
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+
+	s.pendingSegments.Add(-1)
+
+	return nil
+}
+
+// Start delivers spooled segments to the sender, oldest first, until the context is done.
+// A segment is removed only after the sender returns without error and, for an AckWaiter,
+// the server has acknowledged its events; otherwise it is retried after the retry interval.
+func (s *Spool) Start(ctx context.Context, sender Sender) error {
+	ticker := time.NewTicker(s.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.flush(ctx, sender); err != nil && ctx.Err() == nil {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "spool",
+			}).Warnf("Failed to deliver spooled segment, retrying in %s", s.retryInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.WithFields(logger.Fields{
+				"package":  "spool",
+				"segments": s.GetPendingSegments(),
+			}).Infoln("Stopping spool sender, undelivered segments are kept on disk.")
+			return nil
+		case <-s.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush sends every committed segment, stopping at the first failure.
+func (s *Spool) flush(ctx context.Context, sender Sender) error {
+	segments, err := s.Segments()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range segments {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		events, err := s.Read(name)
+		if errors.Is(err, ErrCorruptSegment) {
+			log.WithFields(logger.Fields{
+				"error":   err,
+				"package": "spool",
+				"segment": name,
+			}).Errorln("Moving corrupt segment out of the way")
+			if err := os.Rename(name, name+corruptExt); err == nil {
+				s.pendingSegments.Add(-1)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		total, err := sender.SendBulkEvent(ctx, events)
+		var unsentErr *output.UnsentError
+		if errors.As(err, &unsentErr) && len(unsentErr.Unsent) < len(events) {
+			// Keep only what was not sent, so it is not delivered twice, once the rest is acknowledged.
+			if ackErr := waitAcked(ctx, sender, events[:len(events)-len(unsentErr.Unsent)]); ackErr != nil {
+				return errors.Join(err, ackErr)
+			}
+			if rewriteErr := s.rewrite(name, unsentErr.Unsent); rewriteErr != nil {
+				return errors.Join(err, rewriteErr)
+			}
+			s.TotalDelivered.Add(total)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := waitAcked(ctx, sender, events); err != nil {
+			return fmt.Errorf("failed to wait for the acknowledgement of spooled events: %w", err)
+		}
+
+		if err := s.Remove(name); err != nil {
+			return fmt.Errorf("failed to remove delivered segment: %w", err)
+		}
+
+		s.TotalDelivered.Add(total)
+	}
+
+	return nil
+}
+
+// waitAcked waits for the server to acknowledge the events when the sender keeps them until then.
+func waitAcked(ctx context.Context, sender Sender, events []*pb.SensorEvent) error {
+	if waiter, ok := sender.(AckWaiter); ok {
+		return waiter.WaitAcked(ctx, events)
+	}
+
+	return nil
+}
+
+// rewrite replaces the events of a committed segment.
+func (s *Spool) rewrite(name string, events []*pb.SensorEvent) error {
+	tmpName := name + tmpExt
+
+	if err := writeSegment(tmpName, events); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Rename(tmpName, name); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("failed to rewrite spool segment: %w", err)
+	}
+
+	syncDir(s.dir)
+
+	return nil
+}
+
+// GetPendingSegments retrieves the number of segments waiting to be delivered.
+func (s *Spool) GetPendingSegments() int64 {
+	return s.pendingSegments.Load()
+}
+
+// GetTotalSpooled retrieves the number of events written to the spool since the last call.
+func (s *Spool) GetTotalSpooled() int64 {
+	return s.TotalSpooled.Swap(0)
+}
+
+// GetTotalDelivered retrieves the number of events delivered from the spool since the last call.
+func (s *Spool) GetTotalDelivered() int64 {
+	return s.TotalDelivered.Swap(0)
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, segmentExt)
+}
+
+func parseSegmentSeq(name string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+}
+
+// writeSegment writes the magic header followed by length-prefixed protobuf records.
+func writeSegment(name string, events []*pb.SensorEvent) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if _, err := w.WriteString(segmentMagic); err != nil {
+		return err
+	}
+
+	var lenBuf [4]byte
+	for _, event := range events {
+		data, err := proto.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal spooled event: %w", err)
+		}
+
+		binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+		if _, err := w.Write(lenBuf[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+func readSegment(name string) ([]*pb.SensorEvent, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	magic := make([]byte, len(segmentMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != segmentMagic {
+		return nil, fmt.Errorf("%w: bad header", ErrCorruptSegment)
+	}
+
+	events := make([]*pb.SensorEvent, 0)
+	var lenBuf [4]byte
+	for {
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return events, nil
+			}
+			return nil, fmt.Errorf("%w: %v", ErrCorruptSegment, err)
+		}
+
+		data := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptSegment, err)
+		}
+
+		event := &pb.SensorEvent{}
+		if err := proto.Unmarshal(data, event); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptSegment, err)
+		}
+
+		events = append(events, event)
+	}
+}
+
+// syncDir flushes the directory entry so a rename survives a crash.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	_ = d.Sync()
+}
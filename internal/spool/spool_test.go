@@ -0,0 +1,216 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/output"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/pb"
+)
+
+type fakeSender struct {
+	err    error
+	events []*pb.SensorEvent
+}
+
+func (f *fakeSender) SendBulkEvent(_ context.Context, events []*pb.SensorEvent) (int64, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.events = append(f.events, events...)
+	return int64(len(events)), nil
+}
+
+func Test_AppendAndReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewSpool(dir, time.Second)
+	if err != nil {
+		t.Fatalf("NewSpool() error = %v", err)
+	}
+
+	batch := []*pb.SensorEvent{
+		{EventHashSha256: "a", EventMetricsCount: 2},
+		{EventHashSha256: "b", EventMetricsCount: 1},
+	}
+
+	total, err := s.SendBulkEvent(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("SendBulkEvent() error = %v", err)
+	}
+	if total != 0 {
+		t.Errorf("Expected spooled events not to be reported as sent, got %d", total)
+	}
+	if got := s.GetTotalSpooled(); got != 3 {
+		t.Errorf("Expected 3 spooled events, got %d", got)
+	}
+
+	// Reopen the spool as if the client had been restarted.
+	s, err = NewSpool(dir, time.Second)
+	if err != nil {
+		t.Fatalf("NewSpool() error = %v", err)
+	}
+	if s.GetPendingSegments() != 1 {
+		t.Fatalf("Expected 1 pending segment after restart, got %d", s.GetPendingSegments())
+	}
+
+	sender := &fakeSender{}
+	if err := s.flush(context.Background(), sender); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+
+	if len(sender.events) != 2 || sender.events[0].EventHashSha256 != "a" || sender.events[1].EventHashSha256 != "b" {
+		t.Errorf("Unexpected replayed events: %v", sender.events)
+	}
+	if s.GetPendingSegments() != 0 {
+		t.Errorf("Expected no pending segments, got %d", s.GetPendingSegments())
+	}
+
+	segments, _ := s.Segments()
+	if len(segments) != 0 {
+		t.Errorf("Expected delivered segment to be removed, found %v", segments)
+	}
+}
+
+func Test_FailedSendKeepsSegment(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), time.Second)
+	if err != nil {
+		t.Fatalf("NewSpool() error = %v", err)
+	}
+
+	if err := s.Append([]*pb.SensorEvent{{EventHashSha256: "a", EventMetricsCount: 1}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	if err := s.flush(context.Background(), &fakeSender{err: errors.New("unavailable")}); err == nil {
+		t.Fatal("Expected flush to fail when the sender fails")
+	}
+
+	if s.GetPendingSegments() != 1 {
+		t.Errorf("Expected segment to be kept, got %d pending", s.GetPendingSegments())
+	}
+}
+
+// ackingSender sends events and reports them as acknowledged once acked is closed.
+type ackingSender struct {
+	fakeSender
+	acked chan struct{}
+}
+
+func (f *ackingSender) WaitAcked(ctx context.Context, _ []*pb.SensorEvent) error {
+	select {
+	case <-f.acked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func Test_SegmentIsKeptUntilAcked(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), time.Second)
+	if err != nil {
+		t.Fatalf("NewSpool() error = %v", err)
+	}
+
+	if err := s.Append([]*pb.SensorEvent{{EventHashSha256: "a", EventMetricsCount: 1}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	sender := &ackingSender{acked: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.flush(ctx, sender); err == nil {
+		t.Fatal("Expected flush to fail while the events are not acknowledged")
+	}
+	if s.GetPendingSegments() != 1 || s.GetTotalDelivered() != 0 {
+		t.Errorf("Expected the segment to be kept, got %d pending", s.GetPendingSegments())
+	}
+
+	close(sender.acked)
+	if err := s.flush(context.Background(), sender); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+	if s.GetPendingSegments() != 0 || s.GetTotalDelivered() != 1 {
+		t.Errorf("Expected the acknowledged segment to be removed, got %d pending", s.GetPendingSegments())
+	}
+}
+
+// partialSender sends the first event of a batch and gives up on the rest.
+type partialSender struct {
+	events []*pb.SensorEvent
+}
+
+func (f *partialSender) SendBulkEvent(_ context.Context, events []*pb.SensorEvent) (int64, error) {
+	f.events = append(f.events, events[0])
+	return events[0].EventMetricsCount, &output.UnsentError{Unsent: events[1:], Err: errors.New("unavailable")}
+}
+
+func Test_PartialSendKeepsUnsentEvents(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), time.Second)
+	if err != nil {
+		t.Fatalf("NewSpool() error = %v", err)
+	}
+
+	batch := []*pb.SensorEvent{
+		{EventHashSha256: "a", EventMetricsCount: 2},
+		{EventHashSha256: "b", EventMetricsCount: 1},
+	}
+	if err := s.Append(batch); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	sender := &partialSender{}
+	if err := s.flush(context.Background(), sender); err == nil {
+		t.Fatal("Expected flush to fail when the sender gives up")
+	}
+	if got := s.GetTotalDelivered(); got != 2 {
+		t.Errorf("Expected 2 delivered events, got %d", got)
+	}
+
+	// Only the unsent event must be delivered on the next attempt.
+	if err := s.flush(context.Background(), &fakeSender{}); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+	if got := s.GetTotalDelivered(); got != 1 {
+		t.Errorf("Expected 1 delivered event on retry, got %d", got)
+	}
+	if s.GetPendingSegments() != 0 {
+		t.Errorf("Expected no pending segments, got %d", s.GetPendingSegments())
+	}
+}
+
+func Test_CorruptSegmentIsSkipped(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewSpool(dir, time.Second)
+	if err != nil {
+		t.Fatalf("NewSpool() error = %v", err)
+	}
+
+	corrupt := filepath.Join(dir, segmentName(0))
+	if err := os.WriteFile(corrupt, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.seq = 1
+	s.pendingSegments.Add(1)
+
+	if err := s.Append([]*pb.SensorEvent{{EventHashSha256: "b", EventMetricsCount: 1}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	sender := &fakeSender{}
+	if err := s.flush(context.Background(), sender); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+
+	if len(sender.events) != 1 {
+		t.Errorf("Expected 1 delivered event, got %d", len(sender.events))
+	}
+	if _, err := os.Stat(corrupt + corruptExt); err != nil {
+		t.Errorf("Expected corrupt segment to be renamed: %v", err)
+	}
+}
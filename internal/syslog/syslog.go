@@ -0,0 +1,193 @@
+// Package syslog parses RFC 5424 and RFC 3164 (BSD) syslog messages carrying Snort alerts.
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidMessage is returned when a message does not start with a syslog priority.
+var ErrInvalidMessage = errors.New("invalid syslog message")
+
+// nilValue is the RFC 5424 placeholder for an empty header field.
+const nilValue = "-"
+
+// Message is a parsed syslog message.
+type Message struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+
+	// Body is the message content without the syslog header, e.g. the JSON or fast alert.
+	Body []byte
+}
+
+// Parse parses a single syslog message. Both RFC 5424 and RFC 3164 messages are accepted.
+func Parse(data []byte) (*Message, error) {
+	data = bytes.TrimRight(data, "\r\n\x00")
+
+	pri, rest, err := parsePriority(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{
+		Facility: pri / 8,
+		Severity: pri % 8,
+	}
+
+	// RFC 5424 messages continue with the version number, "1".
+	if len(rest) > 1 && rest[0] == '1' && rest[1] == ' ' {
+		if err := m.parseRFC5424(rest[2:]); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	m.parseRFC3164(rest)
+	return m, nil
+}
+
+func parsePriority(data []byte) (int, []byte, error) {
+	if len(data) < 3 || data[0] != '<' {
+		return 0, nil, ErrInvalidMessage
+	}
+
+	end := bytes.IndexByte(data[:min(len(data), 5)], '>')
+	if end < 2 {
+		return 0, nil, ErrInvalidMessage
+	}
+
+	pri, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || pri > 191 {
+		return 0, nil, ErrInvalidMessage
+	}
+
+	return pri, data[end+1:], nil
+}
+
+// parseRFC5424 parses TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG].
+func (m *Message) parseRFC5424(data []byte) error {
+	fields := make([]string, 5)
+	for i := range fields {
+		field, rest, ok := bytes.Cut(data, []byte(" "))
+		if !ok {
+			return ErrInvalidMessage
+		}
+		fields[i] = string(field)
+		data = rest
+	}
+
+	if fields[0] != nilValue {
+		if ts, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+			m.Timestamp = ts
+		}
+	}
+	m.Hostname = nilToEmpty(fields[1])
+	m.AppName = nilToEmpty(fields[2])
+	m.ProcID = nilToEmpty(fields[3])
+
+	msg, err := skipStructuredData(data)
+	if err != nil {
+		return err
+	}
+
+	msg = bytes.TrimPrefix(bytes.TrimLeft(msg, " "), []byte("\xef\xbb\xbf"))
+	m.Body = bytes.TrimSpace(msg)
+
+	return nil
+}
+
+// skipStructuredData returns the message following the structured data elements.
+func skipStructuredData(data []byte) ([]byte, error) {
+	if len(data) > 0 && data[0] == '-' {
+		return data[1:], nil
+	}
+
+	inValue, escaped, depth := false, false, 0
+	for i, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inValue && c == '\\':
+			escaped = true
+		case c == '"':
+			inValue = !inValue
+		case inValue:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			// The first character after the last element must be the separator.
+			if c == ' ' {
+				return data[i:], nil
+			}
+			return nil, ErrInvalidMessage
+		}
+	}
+
+	if depth != 0 || inValue {
+		return nil, ErrInvalidMessage
+	}
+	return nil, nil
+}
+
+// parseRFC3164 parses TIMESTAMP HOSTNAME TAG: MSG. Senders often leave out the
+// timestamp or the hostname, so every part of the header is optional.
+func (m *Message) parseRFC3164(data []byte) {
+	data = bytes.TrimLeft(data, " ")
+
+	if len(data) >= len(time.Stamp) {
+		if ts, err := time.Parse(time.Stamp, string(data[:len(time.Stamp)])); err == nil {
+			now := time.Now()
+			m.Timestamp = ts.AddDate(now.Year(), 0, 0)
+			data = bytes.TrimLeft(data[len(time.Stamp):], " ")
+
+			// The hostname follows the timestamp, unless the next token is already the tag.
+			if host, rest, ok := bytes.Cut(data, []byte(" ")); ok && !isTag(host) {
+				m.Hostname = string(host)
+				data = rest
+			}
+		}
+	}
+
+	if token, rest, ok := bytes.Cut(data, []byte(" ")); ok && isTag(token) {
+		tag := bytes.TrimSuffix(token, []byte(":"))
+		if name, pid, found := bytes.Cut(tag, []byte("[")); found {
+			m.AppName = string(name)
+			m.ProcID = string(bytes.TrimSuffix(pid, []byte("]")))
+		} else {
+			m.AppName = string(tag)
+		}
+		data = rest
+	}
+
+	m.Body = bytes.TrimSpace(data)
+}
+
+// isTag reports whether the token is a syslog tag such as "snort:" or "snort[1234]:".
+func isTag(token []byte) bool {
+	if len(token) < 2 || token[len(token)-1] != ':' {
+		return false
+	}
+
+	for _, c := range token[:len(token)-1] {
+		if c == '{' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+func nilToEmpty(s string) string {
+	if s == nilValue {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,106 @@
+package syslog
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_Parse(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		hostname string
+		appName  string
+		procID   string
+		body     string
+		severity int
+		wantErr  bool
+	}{
+		{
+			name:     "RFC 5424 with JSON body",
+			data:     `<134>1 2024-10-09T22:32:11.000107Z sensor-01 snort 1234 - - {"sid":2000,"msg":"test"}`,
+			hostname: "sensor-01",
+			appName:  "snort",
+			procID:   "1234",
+			body:     `{"sid":2000,"msg":"test"}`,
+			severity: 6,
+		},
+		{
+			name:     "RFC 5424 with structured data and BOM",
+			data:     "<33>1 2024-10-09T22:32:11Z sensor-02 snort - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"a \\] b\"][x@1 y=\"z\"] \xef\xbb\xbf[1:2000:3] test rule",
+			hostname: "sensor-02",
+			appName:  "snort",
+			body:     "[1:2000:3] test rule",
+			severity: 1,
+		},
+		{
+			name:     "RFC 5424 with nil hostname",
+			data:     `<134>1 - - - - - -`,
+			body:     "",
+			severity: 6,
+		},
+		{
+			name:     "RFC 3164 with fast alert body",
+			data:     "<33>Oct  9 22:32:11 sensor-03 snort[1234]: [1:2000:3] test rule [Classification: Unknown Traffic] [Priority: 3] {TCP} 192.168.10.15:55922 -> 206.54.163.50:80\n",
+			hostname: "sensor-03",
+			appName:  "snort",
+			procID:   "1234",
+			body:     "[1:2000:3] test rule [Classification: Unknown Traffic] [Priority: 3] {TCP} 192.168.10.15:55922 -> 206.54.163.50:80",
+			severity: 1,
+		},
+		{
+			name:     "RFC 3164 without hostname",
+			data:     `<14>Oct 10 05:32:11 snort: {"sid":2000}`,
+			appName:  "snort",
+			body:     `{"sid":2000}`,
+			severity: 6,
+		},
+		{
+			name:     "RFC 3164 without header",
+			data:     `<14>{"sid":2000}`,
+			body:     `{"sid":2000}`,
+			severity: 6,
+		},
+		{
+			name:    "Missing priority",
+			data:    `Oct 10 05:32:11 host snort: test`,
+			wantErr: true,
+		},
+		{
+			name:    "Unterminated structured data",
+			data:    `<14>1 2024-10-09T22:32:11Z host snort - - [x@1 y="z" test`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMessage) {
+					t.Errorf("Parse() error = %v, want ErrInvalidMessage", err)
+				}
+				return
+			}
+
+			if got.Hostname != tt.hostname {
+				t.Errorf("Hostname = %q, want %q", got.Hostname, tt.hostname)
+			}
+			if got.AppName != tt.appName {
+				t.Errorf("AppName = %q, want %q", got.AppName, tt.appName)
+			}
+			if got.ProcID != tt.procID {
+				t.Errorf("ProcID = %q, want %q", got.ProcID, tt.procID)
+			}
+			if string(got.Body) != tt.body {
+				t.Errorf("Body = %q, want %q", got.Body, tt.body)
+			}
+			if got.Severity != tt.severity {
+				t.Errorf("Severity = %d, want %d", got.Severity, tt.severity)
+			}
+		})
+	}
+}
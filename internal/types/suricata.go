@@ -0,0 +1,112 @@
+package types
+
+// SuricataEveAlert represents the fields of a Suricata EVE JSON record that are mapped onto a SnortAlert.
+type SuricataEveAlert struct {
+	// Timestamp: Time of the event in ISO 8601 format, e.g. "2024-10-10T05:32:11.000107+0000".
+	Timestamp string `json:"timestamp"`
+
+	// FlowID: Identifier of the flow the event belongs to.
+	FlowID *int64 `json:"flow_id"`
+
+	// InIface: Network interface on which the event occurred.
+	InIface string `json:"in_iface"`
+
+	// EventType: Type of the record. Only "alert" records are converted.
+	EventType string `json:"event_type"`
+
+	// SrcIP: Source IP address.
+	SrcIP *string `json:"src_ip"`
+
+	// SrcPort: Source port number.
+	SrcPort *int64 `json:"src_port"`
+
+	// DestIP: Destination IP address.
+	DestIP *string `json:"dest_ip"`
+
+	// DestPort: Destination port number.
+	DestPort *int64 `json:"dest_port"`
+
+	// Proto: Transport protocol of the event, e.g. "TCP".
+	Proto string `json:"proto"`
+
+	// AppProto: Application protocol detected on the flow, e.g. "http".
+	AppProto *string `json:"app_proto"`
+
+	// Direction: Direction of the packet, "to_server" or "to_client".
+	Direction *string `json:"direction"`
+
+	// PktSrc: Source of the packet, e.g. "wire/pcap" or "stream (flow timeout)".
+	PktSrc *string `json:"pkt_src"`
+
+	// Vlan: VLAN IDs associated with the event, outermost first.
+	Vlan []int64 `json:"vlan"`
+
+	// ICMPType: ICMP type for ICMP packets.
+	ICMPType *int64 `json:"icmp_type"`
+
+	// ICMPCode: ICMP code for ICMP packets.
+	ICMPCode *int64 `json:"icmp_code"`
+
+	// Payload: Base64-encoded payload data associated with the event.
+	Payload *string `json:"payload"`
+
+	// Alert: Rule information of the alert.
+	Alert *SuricataAlertInfo `json:"alert"`
+
+	// Flow: Flow statistics at the time of the alert.
+	Flow *SuricataFlowInfo `json:"flow"`
+
+	// Ether: Ethernet header information.
+	Ether *SuricataEtherInfo `json:"ether"`
+}
+
+// SuricataAlertInfo represents the "alert" object of an EVE record.
+type SuricataAlertInfo struct {
+	// Action: Action taken by Suricata, "allowed" or "blocked".
+	Action string `json:"action"`
+
+	// GID: Generator ID of the rule.
+	GID int64 `json:"gid"`
+
+	// SignatureID: Signature ID of the rule.
+	SignatureID int64 `json:"signature_id"`
+
+	// Rev: Revision number of the rule.
+	Rev int64 `json:"rev"`
+
+	// Signature: Message of the rule.
+	Signature string `json:"signature"`
+
+	// Category: Classification of the rule.
+	Category *string `json:"category"`
+
+	// Severity: Priority of the rule.
+	Severity int64 `json:"severity"`
+}
+
+// SuricataFlowInfo represents the "flow" object of an EVE record.
+type SuricataFlowInfo struct {
+	// PktsToServer: Number of packets sent by the client.
+	PktsToServer *int64 `json:"pkts_toserver"`
+
+	// PktsToClient: Number of packets sent by the server.
+	PktsToClient *int64 `json:"pkts_toclient"`
+
+	// BytesToServer: Number of bytes sent by the client.
+	BytesToServer *int64 `json:"bytes_toserver"`
+
+	// BytesToClient: Number of bytes sent by the server.
+	BytesToClient *int64 `json:"bytes_toclient"`
+
+	// Start: Start time of the flow in ISO 8601 format.
+	Start *string `json:"start"`
+}
+
+// SuricataEtherInfo represents the "ether" object of an EVE record.
+type SuricataEtherInfo struct {
+	// SrcMac: Source MAC address.
+	SrcMac *string `json:"src_mac"`
+
+	// DestMac: Destination MAC address.
+	DestMac *string `json:"dest_mac"`
+}
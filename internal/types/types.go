@@ -21,6 +21,9 @@ type Metadata struct {
 	// ReceivedAt: Time the event was received.
 	// Only used for testing purposes.
 	ReceivedAt int64 `json:"received_at"`
+
+	// SourceHostname: Hostname of the appliance that forwarded the event, e.g. the syslog hostname.
+	SourceHostname *string `json:"source_hostname"`
 }
 
 // SnortAlert represents an alert generated by Snort.
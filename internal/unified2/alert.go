@@ -0,0 +1,212 @@
+package unified2
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mata-elang-stable/sensor-snort-service/internal/parser"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
+)
+
+// linkTypeEthernet is the pcap link type of Ethernet frames.
+const linkTypeEthernet = 1
+
+var protocolNames = map[uint8]string{
+	1:  "ICMP",
+	6:  "TCP",
+	17: "UDP",
+	58: "ICMP",
+}
+
+// ToSnortAlert converts the event into a SnortAlert.
+// Rule messages and classifications are looked up in the given maps, which may be nil.
+func (e *Event) ToSnortAlert(messages *SidMap, classes *ClassificationMap) *types.SnortAlert {
+	ts := time.Unix(int64(e.EventSecond), int64(e.EventMicrosecond)*1000).UTC()
+
+	alert := &types.SnortAlert{
+		Action:    blockedAction(e.Blocked),
+		GID:       int64(e.GeneratorID),
+		Priority:  int64(e.PriorityID),
+		Protocol:  protocolName(e.Protocol),
+		Revision:  int64(e.SignatureRevision),
+		RuleID:    fmt.Sprintf("%d:%d:%d", e.GeneratorID, e.SignatureID, e.SignatureRevision),
+		Seconds:   int64(e.EventSecond),
+		SID:       int64(e.SignatureID),
+		Timestamp: ts.Format(parser.SnortTimeLayout),
+	}
+
+	alert.Message = messages.Lookup(e.GeneratorID, e.SignatureID)
+	if alert.Message == "" {
+		alert.Message = fmt.Sprintf("Snort Alert [%s]", alert.RuleID)
+	}
+
+	if class := classes.Lookup(e.ClassificationID); class != "" {
+		alert.Classification = &class
+	}
+
+	srcAddr := e.SrcIP.String()
+	dstAddr := e.DstIP.String()
+	alert.SrcAddr = &srcAddr
+	alert.DstAddr = &dstAddr
+
+	if alert.Protocol == "ICMP" {
+		icmpType := int64(e.SrcPort)
+		icmpCode := int64(e.DstPort)
+		alert.ICMPType = &icmpType
+		alert.ICMPCode = &icmpCode
+	} else {
+		srcPort := int64(e.SrcPort)
+		dstPort := int64(e.DstPort)
+		srcAp := fmt.Sprintf("%s:%d", srcAddr, srcPort)
+		dstAp := fmt.Sprintf("%s:%d", dstAddr, dstPort)
+		alert.SrcPort = &srcPort
+		alert.DstPort = &dstPort
+		alert.SrcAp = &srcAp
+		alert.DstAp = &dstAp
+	}
+
+	if e.VLANID != 0 {
+		vlan := int64(e.VLANID)
+		alert.VLAN = &vlan
+	}
+	if e.MPLSLabel != 0 {
+		mpls := int64(e.MPLSLabel)
+		alert.MPLS = &mpls
+	}
+
+	return alert
+}
+
+// ApplyToAlert adds the information decoded from the packet to the alert.
+func (p *Packet) ApplyToAlert(alert *types.SnortAlert) {
+	pktGen := "raw"
+	pktLen := int64(p.PacketLength)
+	alert.PktGen = &pktGen
+	alert.PktLen = &pktLen
+
+	payload := p.Data
+	if p.LinkType == linkTypeEthernet {
+		payload = decodeEthernet(p.Data, alert)
+	}
+
+	if len(payload) > 0 {
+		b64 := base64.StdEncoding.EncodeToString(payload)
+		alert.Base64Data = &b64
+	}
+}
+
+// decodeEthernet fills the link, network and transport fields and returns the application payload.
+func decodeEthernet(data []byte, alert *types.SnortAlert) []byte {
+	if len(data) < 14 {
+		return data
+	}
+
+	ethDst := strings.ToUpper(net.HardwareAddr(data[0:6]).String())
+	ethSrc := strings.ToUpper(net.HardwareAddr(data[6:12]).String())
+	ethType := binary.BigEndian.Uint16(data[12:14])
+	ethTypeStr := fmt.Sprintf("0x%x", ethType)
+	ethLen := int64(len(data))
+	alert.EthDst = &ethDst
+	alert.EthSrc = &ethSrc
+	alert.EthType = &ethTypeStr
+	alert.EthLen = &ethLen
+
+	data = data[14:]
+
+	// Skip a single 802.1Q tag.
+	if ethType == 0x8100 && len(data) >= 4 {
+		ethType = binary.BigEndian.Uint16(data[2:4])
+		data = data[4:]
+	}
+
+	if ethType != 0x0800 || len(data) < 20 || data[0]>>4 != 4 {
+		return data
+	}
+
+	ihl := int(data[0]&0x0f) * 4
+	if ihl < 20 || len(data) < ihl {
+		return data
+	}
+
+	tos := int64(data[1])
+	ipLen := int64(binary.BigEndian.Uint16(data[2:4]))
+	ipID := int64(binary.BigEndian.Uint16(data[4:6]))
+	ttl := int64(data[8])
+	proto := data[9]
+	alert.TOS = &tos
+	alert.IPLen = &ipLen
+	alert.IPID = &ipID
+	alert.TTL = &ttl
+
+	data = data[ihl:]
+
+	switch proto {
+	case 6:
+		if len(data) < 20 {
+			return data
+		}
+		seq := int64(binary.BigEndian.Uint32(data[4:8]))
+		ack := int64(binary.BigEndian.Uint32(data[8:12]))
+		tcpLen := int64(data[12]>>4) * 4
+		flags := tcpFlags(data[13])
+		win := int64(binary.BigEndian.Uint16(data[14:16]))
+		alert.TCPSeq = &seq
+		alert.TCPAck = &ack
+		alert.TCPLen = &tcpLen
+		alert.TCPFlags = &flags
+		alert.TCPWin = &win
+		if int(tcpLen) > len(data) {
+			return nil
+		}
+		return data[tcpLen:]
+	case 17:
+		if len(data) < 8 {
+			return data
+		}
+		udpLen := int64(binary.BigEndian.Uint16(data[4:6]))
+		alert.UDPLen = &udpLen
+		return data[8:]
+	}
+
+	return data
+}
+
+// tcpFlags formats the TCP flags the way Snort does, e.g. "***AP***".
+func tcpFlags(flags uint8) string {
+	const names = "12UAPRSF"
+
+	out := []byte("********")
+	for i := 0; i < 8; i++ {
+		if flags&(0x80>>i) != 0 {
+			out[i] = names[i]
+		}
+	}
+
+	return string(out)
+}
+
+func blockedAction(blocked uint8) *string {
+	var action string
+	switch blocked {
+	case 1:
+		action = "block"
+	case 2:
+		action = "would_block"
+	default:
+		action = "allow"
+	}
+
+	return &action
+}
+
+func protocolName(proto uint8) string {
+	if name, ok := protocolNames[proto]; ok {
+		return name
+	}
+
+	return "IP"
+}
@@ -0,0 +1,152 @@
+package unified2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type sigKey struct {
+	gid uint32
+	sid uint32
+}
+
+// SidMap maps rule IDs to rule messages, as loaded from a Snort sid-msg.map file.
+type SidMap struct {
+	messages map[sigKey]string
+}
+
+// LoadSidMap reads a sid-msg.map file in either the v1 format
+// ("sid || msg || refs...") or the v2 format
+// ("gid || sid || rev || class || priority || msg || refs...").
+func LoadSidMap(path string) (*SidMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseSidMap(file)
+}
+
+// ParseSidMap parses the content of a sid-msg.map file.
+func ParseSidMap(r io.Reader) (*SidMap, error) {
+	m := &SidMap{messages: make(map[sigKey]string)}
+
+	scanner := bufio.NewScanner(r)
+	version2 := false
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "#v2" {
+			version2 = true
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "||")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		var gid, sid uint64
+		var msg string
+		var err error
+		switch {
+		case version2 && len(fields) >= 6:
+			gid, err = strconv.ParseUint(fields[0], 10, 32)
+			if err == nil {
+				sid, err = strconv.ParseUint(fields[1], 10, 32)
+			}
+			msg = fields[5]
+		case !version2 && len(fields) >= 2:
+			gid = 1
+			sid, err = strconv.ParseUint(fields[0], 10, 32)
+			msg = fields[1]
+		default:
+			err = fmt.Errorf("unexpected number of fields")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sid-msg.map line %d: %w", lineNum, err)
+		}
+
+		m.messages[sigKey{gid: uint32(gid), sid: uint32(sid)}] = msg
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Lookup returns the message of the rule, or an empty string if it is unknown.
+func (m *SidMap) Lookup(gid, sid uint32) string {
+	if m == nil {
+		return ""
+	}
+
+	return m.messages[sigKey{gid: gid, sid: sid}]
+}
+
+// ClassificationMap maps classification IDs to descriptions, as loaded from classification.config.
+type ClassificationMap struct {
+	descriptions map[uint32]string
+}
+
+// LoadClassificationMap reads a classification.config file.
+// Classification IDs are assigned in order of appearance, starting at 1, as Snort does.
+func LoadClassificationMap(path string) (*ClassificationMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseClassificationMap(file)
+}
+
+// ParseClassificationMap parses the content of a classification.config file.
+func ParseClassificationMap(r io.Reader) (*ClassificationMap, error) {
+	const prefix = "config classification:"
+
+	m := &ClassificationMap{descriptions: make(map[uint32]string)}
+
+	scanner := bufio.NewScanner(r)
+	id := uint32(0)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		fields := strings.Split(strings.TrimPrefix(line, prefix), ",")
+		if len(fields) < 2 {
+			continue
+		}
+
+		id++
+		m.descriptions[id] = strings.TrimSpace(fields[1])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Lookup returns the description of the classification, or an empty string if it is unknown.
+func (m *ClassificationMap) Lookup(id uint32) string {
+	if m == nil {
+		return ""
+	}
+
+	return m.descriptions[id]
+}
@@ -0,0 +1,236 @@
+package unified2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Record types written by Snort 2.9 to unified2 files.
+const (
+	RecordTypePacket        uint32 = 2
+	RecordTypeEvent         uint32 = 7
+	RecordTypeEventIPv6     uint32 = 72
+	RecordTypeEventVLAN     uint32 = 104
+	RecordTypeEventIPv6VLAN uint32 = 105
+	RecordTypeExtraData     uint32 = 110
+)
+
+const (
+	// HeaderSize is the size of the header in front of every record.
+	HeaderSize = 8
+
+	// maxRecordSize protects against reading garbage as a huge record length.
+	maxRecordSize = 16 * 1024 * 1024
+
+	eventSize         = 52
+	eventIPv6Size     = 76
+	eventVLANExtra    = 8
+	packetHeaderSize  = 28
+	extraDataHdrSize  = 8
+	extraDataBodySize = 24
+)
+
+// ErrCorruptRecord is returned when a record cannot be decoded.
+var ErrCorruptRecord = errors.New("corrupt unified2 record")
+
+// Event is an IDS event record (types 7, 72, 104 and 105).
+type Event struct {
+	SensorID          uint32
+	EventID           uint32
+	EventSecond       uint32
+	EventMicrosecond  uint32
+	SignatureID       uint32
+	GeneratorID       uint32
+	SignatureRevision uint32
+	ClassificationID  uint32
+	PriorityID        uint32
+	SrcIP             net.IP
+	DstIP             net.IP
+	SrcPort           uint16 // ICMP type for ICMP events.
+	DstPort           uint16 // ICMP code for ICMP events.
+	Protocol          uint8
+	ImpactFlag        uint8
+	Impact            uint8
+	Blocked           uint8
+	MPLSLabel         uint32
+	VLANID            uint16
+}
+
+// Packet is a packet record (type 2) that belongs to an event.
+type Packet struct {
+	SensorID          uint32
+	EventID           uint32
+	EventSecond       uint32
+	PacketSecond      uint32
+	PacketMicrosecond uint32
+	LinkType          uint32
+	PacketLength      uint32
+	Data              []byte
+}
+
+// ExtraData is an extra-data record (type 110) that belongs to an event.
+type ExtraData struct {
+	SensorID    uint32
+	EventID     uint32
+	EventSecond uint32
+	Type        uint32
+	DataType    uint32
+	Data        []byte
+}
+
+// Unknown is a record of a type that is not decoded.
+type Unknown struct {
+	Type uint32
+}
+
+// ReadRecordAt reads the record starting at offset.
+// It returns the decoded record and the total number of bytes it occupies.
+// io.EOF is returned when there is no data at offset and io.ErrUnexpectedEOF
+// when the record is only partially written yet.
+func ReadRecordAt(r io.ReaderAt, offset int64) (any, int64, error) {
+	var header [HeaderSize]byte
+	n, err := r.ReadAt(header[:], offset)
+	if n == 0 && errors.Is(err, io.EOF) {
+		return nil, 0, io.EOF
+	}
+	if n < HeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	recordType := binary.BigEndian.Uint32(header[0:4])
+	length := binary.BigEndian.Uint32(header[4:8])
+	if length > maxRecordSize {
+		return nil, 0, fmt.Errorf("%w: record length %d exceeds limit", ErrCorruptRecord, length)
+	}
+
+	body := make([]byte, length)
+	if n, err := r.ReadAt(body, offset+HeaderSize); n < len(body) {
+		if err == nil || errors.Is(err, io.EOF) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	record, err := DecodeRecord(recordType, body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return record, HeaderSize + int64(length), nil
+}
+
+// DecodeRecord decodes the body of a record of the given type.
+func DecodeRecord(recordType uint32, body []byte) (any, error) {
+	switch recordType {
+	case RecordTypeEvent, RecordTypeEventVLAN:
+		return decodeEvent(body, net.IPv4len, recordType == RecordTypeEventVLAN)
+	case RecordTypeEventIPv6, RecordTypeEventIPv6VLAN:
+		return decodeEvent(body, net.IPv6len, recordType == RecordTypeEventIPv6VLAN)
+	case RecordTypePacket:
+		return decodePacketRecord(body)
+	case RecordTypeExtraData:
+		return decodeExtraData(body)
+	default:
+		return &Unknown{Type: recordType}, nil
+	}
+}
+
+func decodeEvent(body []byte, ipLen int, vlan bool) (*Event, error) {
+	size := eventSize
+	if ipLen == net.IPv6len {
+		size = eventIPv6Size
+	}
+	if vlan {
+		size += eventVLANExtra
+	}
+	if len(body) < size {
+		return nil, fmt.Errorf("%w: event record is %d bytes, expected %d", ErrCorruptRecord, len(body), size)
+	}
+
+	be := binary.BigEndian
+	e := &Event{
+		SensorID:          be.Uint32(body[0:]),
+		EventID:           be.Uint32(body[4:]),
+		EventSecond:       be.Uint32(body[8:]),
+		EventMicrosecond:  be.Uint32(body[12:]),
+		SignatureID:       be.Uint32(body[16:]),
+		GeneratorID:       be.Uint32(body[20:]),
+		SignatureRevision: be.Uint32(body[24:]),
+		ClassificationID:  be.Uint32(body[28:]),
+		PriorityID:        be.Uint32(body[32:]),
+	}
+
+	pos := 36
+	e.SrcIP = net.IP(append([]byte(nil), body[pos:pos+ipLen]...))
+	pos += ipLen
+	e.DstIP = net.IP(append([]byte(nil), body[pos:pos+ipLen]...))
+	pos += ipLen
+
+	e.SrcPort = be.Uint16(body[pos:])
+	e.DstPort = be.Uint16(body[pos+2:])
+	e.Protocol = body[pos+4]
+	e.ImpactFlag = body[pos+5]
+	e.Impact = body[pos+6]
+	e.Blocked = body[pos+7]
+	pos += 8
+
+	if vlan {
+		e.MPLSLabel = be.Uint32(body[pos:])
+		e.VLANID = be.Uint16(body[pos+4:])
+	}
+
+	return e, nil
+}
+
+func decodePacketRecord(body []byte) (*Packet, error) {
+	if len(body) < packetHeaderSize {
+		return nil, fmt.Errorf("%w: packet record is %d bytes", ErrCorruptRecord, len(body))
+	}
+
+	be := binary.BigEndian
+	p := &Packet{
+		SensorID:          be.Uint32(body[0:]),
+		EventID:           be.Uint32(body[4:]),
+		EventSecond:       be.Uint32(body[8:]),
+		PacketSecond:      be.Uint32(body[12:]),
+		PacketMicrosecond: be.Uint32(body[16:]),
+		LinkType:          be.Uint32(body[20:]),
+		PacketLength:      be.Uint32(body[24:]),
+	}
+
+	if int(p.PacketLength) > len(body)-packetHeaderSize {
+		return nil, fmt.Errorf("%w: packet length %d exceeds record", ErrCorruptRecord, p.PacketLength)
+	}
+	p.Data = body[packetHeaderSize : packetHeaderSize+int(p.PacketLength)]
+
+	return p, nil
+}
+
+func decodeExtraData(body []byte) (*ExtraData, error) {
+	if len(body) < extraDataHdrSize+extraDataBodySize {
+		return nil, fmt.Errorf("%w: extra data record is %d bytes", ErrCorruptRecord, len(body))
+	}
+
+	be := binary.BigEndian
+	body = body[extraDataHdrSize:]
+	x := &ExtraData{
+		SensorID:    be.Uint32(body[0:]),
+		EventID:     be.Uint32(body[4:]),
+		EventSecond: be.Uint32(body[8:]),
+		Type:        be.Uint32(body[12:]),
+		DataType:    be.Uint32(body[16:]),
+	}
+
+	// blob_length includes the 8 bytes of the blob header itself.
+	blobLength := int(be.Uint32(body[20:]))
+	dataLength := blobLength - 8
+	if dataLength < 0 || dataLength > len(body)-extraDataBodySize {
+		return nil, fmt.Errorf("%w: extra data length %d exceeds record", ErrCorruptRecord, blobLength)
+	}
+	x.Data = body[extraDataBodySize : extraDataBodySize+dataLength]
+
+	return x, nil
+}
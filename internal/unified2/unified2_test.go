@@ -0,0 +1,194 @@
+package unified2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mata-elang-stable/sensor-snort-service/internal/types"
+)
+
+func toPtr[T any](d T) *T {
+	return &d
+}
+
+func record(recordType uint32, body []byte) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, recordType)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(body)))
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func eventBody() []byte {
+	var buf bytes.Buffer
+	for _, v := range []uint32{
+		0,          // sensor_id
+		42,         // event_id
+		1728513131, // event_second
+		107,        // event_microsecond
+		2000,       // signature_id
+		1,          // generator_id
+		3,          // signature_revision
+		2,          // classification_id
+		1,          // priority_id
+	} {
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+	buf.Write([]byte{192, 168, 10, 15})
+	buf.Write([]byte{206, 54, 163, 50})
+	_ = binary.Write(&buf, binary.BigEndian, uint16(55922))
+	_ = binary.Write(&buf, binary.BigEndian, uint16(80))
+	buf.Write([]byte{6, 0, 0, 0}) // protocol, impact_flag, impact, blocked
+	return buf.Bytes()
+}
+
+func packetBody(data []byte) []byte {
+	var buf bytes.Buffer
+	for _, v := range []uint32{0, 42, 1728513131, 1728513131, 107, linkTypeEthernet, uint32(len(data))} {
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func ethernetTCPFrame(payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0x90, 0xb1, 0x1c, 0xa2, 0xc0, 0xd3}) // dst
+	buf.Write([]byte{0x70, 0xf3, 0x5a, 0x42, 0x73, 0xe8}) // src
+	buf.Write([]byte{0x08, 0x00})
+	ip := []byte{0x45, 0, 0, 0, 0x12, 0x34, 0, 0, 64, 6, 0, 0, 192, 168, 10, 15, 206, 54, 163, 50}
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+20+len(payload)))
+	buf.Write(ip)
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:], 55922)
+	binary.BigEndian.PutUint16(tcp[2:], 80)
+	binary.BigEndian.PutUint32(tcp[4:], 1000)
+	binary.BigEndian.PutUint32(tcp[8:], 2000)
+	tcp[12] = 5 << 4
+	tcp[13] = 0x18 // ACK, PSH
+	binary.BigEndian.PutUint16(tcp[14:], 502)
+	buf.Write(tcp)
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func Test_ReadRecordAt(t *testing.T) {
+	data := append(record(RecordTypeEvent, eventBody()), record(RecordTypePacket, packetBody(ethernetTCPFrame([]byte("GET /"))))...)
+	r := bytes.NewReader(data)
+
+	rec, n, err := ReadRecordAt(r, 0)
+	if err != nil {
+		t.Fatalf("ReadRecordAt() error = %v", err)
+	}
+	event, ok := rec.(*Event)
+	if !ok {
+		t.Fatalf("Expected *Event, got %T", rec)
+	}
+	if event.EventID != 42 || event.SignatureID != 2000 || event.SrcIP.String() != "192.168.10.15" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if n != HeaderSize+eventSize {
+		t.Errorf("Expected record size %d, got %d", HeaderSize+eventSize, n)
+	}
+
+	rec, m, err := ReadRecordAt(r, n)
+	if err != nil {
+		t.Fatalf("ReadRecordAt() error = %v", err)
+	}
+	if _, ok := rec.(*Packet); !ok {
+		t.Fatalf("Expected *Packet, got %T", rec)
+	}
+
+	if _, _, err := ReadRecordAt(r, n+m); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF at the end, got %v", err)
+	}
+
+	// A record that is still being written must not be consumed.
+	partial := bytes.NewReader(data[:HeaderSize+10])
+	if _, _, err := ReadRecordAt(partial, 0); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF for a partial record, got %v", err)
+	}
+}
+
+func Test_EventToSnortAlert(t *testing.T) {
+	rec, err := DecodeRecord(RecordTypeEvent, eventBody())
+	if err != nil {
+		t.Fatalf("DecodeRecord() error = %v", err)
+	}
+	packet, err := DecodeRecord(RecordTypePacket, packetBody(ethernetTCPFrame([]byte("GET /"))))
+	if err != nil {
+		t.Fatalf("DecodeRecord() error = %v", err)
+	}
+
+	messages, err := ParseSidMap(strings.NewReader("2000 || ET POLICY test rule || url,example.com\n"))
+	if err != nil {
+		t.Fatalf("ParseSidMap() error = %v", err)
+	}
+	classes, err := ParseClassificationMap(strings.NewReader(
+		"# comment\nconfig classification: not-suspicious,Not Suspicious Traffic,3\nconfig classification: unknown,Unknown Traffic,3\n"))
+	if err != nil {
+		t.Fatalf("ParseClassificationMap() error = %v", err)
+	}
+
+	got := rec.(*Event).ToSnortAlert(messages, classes)
+	packet.(*Packet).ApplyToAlert(got)
+
+	want := &types.SnortAlert{
+		Action:         toPtr("allow"),
+		Base64Data:     toPtr("R0VUIC8="),
+		Classification: toPtr("Unknown Traffic"),
+		DstAddr:        toPtr("206.54.163.50"),
+		DstAp:          toPtr("206.54.163.50:80"),
+		DstPort:        toPtr(int64(80)),
+		EthDst:         toPtr("90:B1:1C:A2:C0:D3"),
+		EthLen:         toPtr(int64(59)),
+		EthSrc:         toPtr("70:F3:5A:42:73:E8"),
+		EthType:        toPtr("0x800"),
+		GID:            1,
+		IPID:           toPtr(int64(0x1234)),
+		IPLen:          toPtr(int64(45)),
+		Message:        "ET POLICY test rule",
+		PktGen:         toPtr("raw"),
+		PktLen:         toPtr(int64(59)),
+		Priority:       1,
+		Protocol:       "TCP",
+		Revision:       3,
+		RuleID:         "1:2000:3",
+		Seconds:        1728513131,
+		SID:            2000,
+		SrcAddr:        toPtr("192.168.10.15"),
+		SrcAp:          toPtr("192.168.10.15:55922"),
+		SrcPort:        toPtr(int64(55922)),
+		TCPAck:         toPtr(int64(2000)),
+		TCPFlags:       toPtr("***AP***"),
+		TCPLen:         toPtr(int64(20)),
+		TCPSeq:         toPtr(int64(1000)),
+		TCPWin:         toPtr(int64(502)),
+		Timestamp:      "24/10/09-22:32:11.000107",
+		TOS:            toPtr(int64(0)),
+		TTL:            toPtr(int64(64)),
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ToSnortAlert() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_ParseSidMapV2(t *testing.T) {
+	m, err := ParseSidMap(strings.NewReader("#v2\n1 || 2000 || 3 || policy-violation || 1 || ET POLICY v2 rule || url,example.com\n"))
+	if err != nil {
+		t.Fatalf("ParseSidMap() error = %v", err)
+	}
+
+	if got := m.Lookup(1, 2000); got != "ET POLICY v2 rule" {
+		t.Errorf("Lookup() = %q", got)
+	}
+	if got := (*SidMap)(nil).Lookup(1, 2000); got != "" {
+		t.Errorf("Lookup() on nil map = %q", got)
+	}
+}